@@ -1,19 +1,74 @@
 package tigconfig
 
+/*
+How to store the config:
+- Line oriented, order does not matter
+- A line is a "key=value" pair, keys are dot separated
+- Lines starting with '#' are comments
+
+###FILE START
+# tig config
+rename.threshold=50
+rename.copies=true
+init.defaultBranch=main
+core.workers=8
+core.sshCommand=ssh -i ~/.ssh/id_tig
+lfs.cache=/mnt/shared/tig-lfs
+branch.main.remote=origin
+branch.main.merge=refs/heads/main
+###FILE END
+
+*/
+
 import (
 	"errors"
 	"fmt"
 	"io/fs"
 	"os"
 	"path"
+	"slices"
+	"strconv"
+	"strings"
 	"tig/internal/tigfile"
 )
 
 // TigConfigFileName path relative to TigRootPath
 const TigConfigFileName = "config"
 
+// Config keys
+const (
+	// Minimum similarity (percent) for two files to be considered renamed or copied
+	CONFIG_RENAME_THRESHOLD = "rename.threshold"
+	// "true" to detect the files copied from another file, in status, show and log
+	CONFIG_RENAME_COPIES = "rename.copies"
+	// Branch checked out in a new repository
+	CONFIG_DEFAULT_BRANCH = "init.defaultBranch"
+	// Size of the worker pools walking and hashing files, 0 = one worker per CPU
+	CONFIG_WORKERS = "core.workers"
+	// "true" in a bare repository, see [TigCtx.IsBare]
+	CONFIG_BARE = "core.bare"
+	// Age under which tig gc keeps unreachable objects, like "336h", "14d" or "now"
+	CONFIG_GC_GRACE = "gc.grace"
+	// Command connecting to the host of an ssh:// remote, run as "<command> [-p port] [user@]host tig <service> <path>"
+	CONFIG_SSH_COMMAND = "core.sshCommand"
+	// Directory of LFS objects shared by several repositories, relative to the project directory,
+	// or to the tig directory of a bare repository
+	CONFIG_LFS_CACHE = "lfs.cache"
+)
+
+// Environment variable overriding CONFIG_SSH_COMMAND
+const ENV_SSH_COMMAND = "TIG_SSH_COMMAND"
+
+// Default command connecting to the host of an ssh:// remote
+const DEFAULT_SSH_COMMAND = "ssh"
+
+// Default minimum similarity (percent) for rename and copy detection
+const DEFAULT_RENAME_THRESHOLD = 50
+
+// Default name of the first branch
+const DEFAULT_BRANCH = "main"
+
 // LoadConfig load the config file
-// TODO
 func (ctx *TigCtx) LoadConfig() error {
 	ctx.AuthorName = "codedude"
 	fd, err := tigfile.Open(path.Join(ctx.TigPath, TigConfigFileName), os.O_RDONLY)
@@ -24,5 +79,162 @@ func (ctx *TigCtx) LoadConfig() error {
 		return fmt.Errorf("LoadConfig: %w", err)
 	}
 	defer fd.Close()
+	ctx.Config = make(map[string]string, 16)
+	err = tigfile.ScanLines(fd, func(line string) error {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			return nil
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("bad line %s, must be key=value", line)
+		}
+		ctx.Config[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("LoadConfig: %w", err)
+	}
+	return nil
+}
+
+// SaveConfig write the config file
+func (ctx *TigCtx) SaveConfig() error {
+	var lines []string
+	for key, value := range ctx.Config {
+		lines = append(lines, key+"="+value)
+	}
+	slices.Sort(lines)
+	err := tigfile.WriteFileAtomicLines(path.Join(ctx.TigPath, TigConfigFileName), lines)
+	if err != nil {
+		return fmt.Errorf("SaveConfig: %w", err)
+	}
 	return nil
 }
+
+// GetConfig return the value of a config key, or def if it is not set
+func (ctx *TigCtx) GetConfig(key string, def string) string {
+	if value, ok := ctx.Config[key]; ok {
+		return value
+	}
+	return def
+}
+
+// GetConfigInt return the integer value of a config key, or def if it is not set or invalid
+func (ctx *TigCtx) GetConfigInt(key string, def int) int {
+	value, err := strconv.Atoi(ctx.GetConfig(key, ""))
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// SetConfig set a config key, [TigCtx.SaveConfig] must be called to persist it
+func (ctx *TigCtx) SetConfig(key string, value string) {
+	if ctx.Config == nil {
+		ctx.Config = make(map[string]string, 8)
+	}
+	ctx.Config[key] = value
+}
+
+// UnsetConfig remove a config key, [TigCtx.SaveConfig] must be called to persist it
+func (ctx *TigCtx) UnsetConfig(key string) {
+	delete(ctx.Config, key)
+}
+
+// RenameThreshold return the configured similarity threshold for rename detection
+func (ctx *TigCtx) RenameThreshold() int {
+	return ctx.GetConfigInt(CONFIG_RENAME_THRESHOLD, DEFAULT_RENAME_THRESHOLD)
+}
+
+// DetectCopies return true if copies are detected, off by default: every file is a possible source
+func (ctx *TigCtx) DetectCopies() bool {
+	return ctx.GetConfig(CONFIG_RENAME_COPIES, "") == "true"
+}
+
+// DefaultBranch return the configured name of the first branch
+func (ctx *TigCtx) DefaultBranch() string {
+	return ctx.GetConfig(CONFIG_DEFAULT_BRANCH, DEFAULT_BRANCH)
+}
+
+// BranchUpstream return the remote ("." for a local branch) and the branch name
+// the branch tracks, from the "branch.<name>.remote" and "branch.<name>.merge" keys
+func (ctx *TigCtx) BranchUpstream(branch string) (string, string, bool) {
+	remote := ctx.GetConfig("branch."+branch+".remote", "")
+	merge := ctx.GetConfig("branch."+branch+".merge", "")
+	if len(remote) == 0 || len(merge) == 0 {
+		return "", "", false
+	}
+	return remote, strings.TrimPrefix(merge, "refs/heads/"), true
+}
+
+// Workers return the configured size of the worker pools, 0 means one worker per CPU
+func (ctx *TigCtx) Workers() int {
+	return ctx.GetConfigInt(CONFIG_WORKERS, 0)
+}
+
+// SSHCommand return the command connecting to the host of an ssh:// remote, from the environment or the config
+func (ctx *TigCtx) SSHCommand() string {
+	if command := os.Getenv(ENV_SSH_COMMAND); len(command) > 0 {
+		return command
+	}
+	return ctx.GetConfig(CONFIG_SSH_COMMAND, DEFAULT_SSH_COMMAND)
+}
+
+// LFSCache return the absolute path of the LFS cache directory, empty if none
+func (ctx *TigCtx) LFSCache() string {
+	cache := ctx.GetConfig(CONFIG_LFS_CACHE, "")
+	if len(cache) == 0 || path.IsAbs(cache) {
+		return cache
+	}
+	if ctx.IsBare() {
+		return path.Join(ctx.TigPath, cache)
+	}
+	return path.Join(ctx.ProjectPath, cache)
+}
+
+// SetRemote set the url of the remote name and its default fetch refspec, which maps
+// its branches to refs/remotes/<name>/
+func (ctx *TigCtx) SetRemote(name string, url string) {
+	ctx.SetConfig("remote."+name+".url", url)
+	ctx.SetConfig("remote."+name+".fetch", "+refs/heads/*:refs/remotes/"+name+"/*")
+}
+
+// SetBranchUpstream make branch track the branch merge of remote, see [TigCtx.BranchUpstream]
+func (ctx *TigCtx) SetBranchUpstream(branch string, remote string, merge string) {
+	ctx.SetConfig("branch."+branch+".remote", remote)
+	ctx.SetConfig("branch."+branch+".merge", "refs/heads/"+merge)
+}
+
+// Remotes return the names of the configured remotes, sorted
+func (ctx *TigCtx) Remotes() []string {
+	var names []string
+	for key := range ctx.Config {
+		if name, ok := strings.CutPrefix(key, "remote."); ok {
+			if name, ok = strings.CutSuffix(name, ".url"); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// RemoteURL return the url of the remote name, false if it is not configured
+func (ctx *TigCtx) RemoteURL(name string) (string, bool) {
+	url := ctx.GetConfig("remote."+name+".url", "")
+	return url, len(url) > 0
+}
+
+// RemoveRemote remove the keys of the remote name, and the upstream of the branches tracking it
+func (ctx *TigCtx) RemoveRemote(name string) {
+	for key, value := range ctx.Config {
+		if strings.HasPrefix(key, "remote."+name+".") {
+			ctx.UnsetConfig(key)
+		} else if strings.HasPrefix(key, "branch.") && strings.HasSuffix(key, ".remote") && value == name {
+			branch := strings.TrimSuffix(key, ".remote")
+			ctx.UnsetConfig(branch + ".remote")
+			ctx.UnsetConfig(branch + ".merge")
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
 	"tig/internal/tigfile"
 	"tig/internal/tigfs"
 )
@@ -13,18 +14,24 @@ import (
 // TigRootPath path relative to the current directory
 const TigRootPath = ".tig"
 
+// TigLockFileName path relative to TigRootPath, held while the repository state is read and written
+const TigLockFileName = "index.lock"
+
 var ErrAlreadyInit = errors.New("Tig already initialized")
 var ErrNotInit = errors.New("Tig is not configured for this folder")
+var ErrBare = errors.New("This operation must be run in a working tree, the repository is bare")
 
 // TigCtx
 type TigCtx struct {
 	ProjectPath string
 	TigPath     string
 	AuthorName  string
+	Config      map[string]string
 	FS          *tigfs.TigFS
 }
 
 // InitTig initialize tig paths, must be called first.
+// The current directory is a bare repository if it has no .tig directory and is a tig directory itself.
 func (ctx *TigCtx) LoadPaths() error {
 	cwd, err := os.Getwd()
 	if err != nil {
@@ -32,11 +39,24 @@ func (ctx *TigCtx) LoadPaths() error {
 	}
 	ctx.ProjectPath = cwd
 	ctx.TigPath = path.Join(cwd, TigRootPath)
+	if _, err := os.Stat(ctx.TigPath); errors.Is(err, os.ErrNotExist) && isBareDir(cwd) {
+		ctx.ProjectPath, ctx.TigPath = path.Dir(cwd), cwd
+	}
 	return nil
 }
 
-// Init create directories and files needed by tig
+// isBareDir return true if dir is the tig directory of a bare repository
+func isBareDir(dir string) bool {
+	bare := TigCtx{TigPath: dir}
+	return bare.LoadConfig() == nil && bare.IsBare()
+}
+
+// Init create directories and files needed by tig. A bare repository is created if TigPath is not
+// the .tig directory of ProjectPath, its tig directory may exist if it has no config.
 func (ctx *TigCtx) Init() error {
+	if ctx.TigPath != path.Join(ctx.ProjectPath, TigRootPath) {
+		return ctx.initBare()
+	}
 	var err error
 	if err = os.Mkdir(ctx.TigPath, tigfile.DIR_PERM); err != nil {
 		if os.IsExist(err) {
@@ -52,11 +72,50 @@ func (ctx *TigCtx) Init() error {
 	return nil
 }
 
+// initBare create the tig directory of a bare repository, its config marks it bare
+func (ctx *TigCtx) initBare() error {
+	if err := os.MkdirAll(ctx.TigPath, tigfile.DIR_PERM); err != nil {
+		return fmt.Errorf("Init: %w", err)
+	}
+	if _, err := os.Stat(path.Join(ctx.TigPath, TigConfigFileName)); err == nil {
+		return ErrAlreadyInit
+	}
+	ctx.SetConfig(CONFIG_BARE, "true")
+	return ctx.SaveConfig()
+}
+
 // Delete removes tig root folder .tig (DELETE ALL FILES)
 func (ctx *TigCtx) Delete() error {
 	return os.RemoveAll(ctx.TigPath)
 }
 
+// OpenRepository return the context of the repository at repoPath, its project directory or its tig
+// directory. The config is loaded, not the FS.
+func OpenRepository(repoPath string) (TigCtx, error) {
+	absPath, err := filepath.Abs(repoPath)
+	if err != nil {
+		return TigCtx{}, fmt.Errorf("OpenRepository: %w", err)
+	}
+	ctx := TigCtx{ProjectPath: absPath, TigPath: path.Join(absPath, TigRootPath)}
+	if _, err := os.Stat(path.Join(ctx.TigPath, TigConfigFileName)); err != nil {
+		if _, err := os.Stat(path.Join(absPath, TigConfigFileName)); err != nil {
+			return TigCtx{}, fmt.Errorf("%s is not a tig repository", repoPath)
+		}
+		ctx.ProjectPath, ctx.TigPath = path.Dir(absPath), absPath
+	}
+	if err := ctx.LoadConfig(); err != nil {
+		return TigCtx{}, err
+	}
+	return ctx, nil
+}
+
+// IsBare return true if the repository has no project directory, as marked by CONFIG_BARE in its
+// config, which must be loaded. The files, refs and config of a bare repository are laid out as in
+// a .tig directory.
+func (ctx TigCtx) IsBare() bool {
+	return ctx.GetConfig(CONFIG_BARE, "") == "true"
+}
+
 // LoadFS initialize tig FS
 func (ctx *TigCtx) LoadFS() error {
 	var err error
@@ -68,5 +127,25 @@ func (ctx *TigCtx) LoadFS() error {
 	if err != nil {
 		return fmt.Errorf("LoadFS: %w", err)
 	}
+	if ctx.FS.LFS, err = ctx.OpenLFS(); err != nil {
+		return fmt.Errorf("LoadFS: %w", err)
+	}
 	return nil
 }
+
+// OpenLFS return the LFS store, with the tracked patterns of the project and the cache of the config
+func (ctx TigCtx) OpenLFS() (*tigfs.TigLFS, error) {
+	lfs := tigfs.NewLFS(ctx.TigPath)
+	if !ctx.IsBare() {
+		if err := lfs.LoadTrack(ctx.ProjectPath); err != nil {
+			return nil, err
+		}
+	}
+	lfs.CachePath = ctx.LFSCache()
+	return lfs, nil
+}
+
+// Lock take the repository lock, it must be held around every read-modify-write of the index, FS and tree
+func (ctx TigCtx) Lock() (*tigfile.LockFile, error) {
+	return tigfile.Lock(path.Join(ctx.TigPath, TigLockFileName))
+}
@@ -0,0 +1,85 @@
+package tigconfig
+
+import (
+	"errors"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestInitBare(t *testing.T) {
+	dir := path.Join(t.TempDir(), "repo.tig")
+	bare := TigCtx{ProjectPath: path.Dir(dir), TigPath: dir}
+	if err := bare.Init(); err != nil {
+		t.Fatalf("Init(): %s", err)
+	}
+	if err := bare.Init(); !errors.Is(err, ErrAlreadyInit) {
+		t.Fatalf("Init() twice must fail with ErrAlreadyInit, not %v", err)
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	var ctx TigCtx
+	if err := ctx.LoadPaths(); err != nil {
+		t.Fatalf("LoadPaths(): %s", err)
+	}
+	if err := ctx.LoadConfig(); err != nil {
+		t.Fatalf("LoadConfig(): %s", err)
+	}
+	if ctx.TigPath != dir || !ctx.IsBare() {
+		t.Fatalf("LoadPaths() in a bare repository must use it as tig directory, not %s", ctx.TigPath)
+	}
+
+	// A directory with a config file is not a repository
+	plain := t.TempDir()
+	if err := os.WriteFile(path.Join(plain, TigConfigFileName), []byte("a=b\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(plain); err != nil {
+		t.Fatal(err)
+	}
+	ctx = TigCtx{}
+	if err := ctx.LoadPaths(); err != nil {
+		t.Fatalf("LoadPaths(): %s", err)
+	}
+	if ctx.TigPath != path.Join(plain, TigRootPath) {
+		t.Fatalf("LoadPaths() must not take %s for a bare repository", plain)
+	}
+}
+
+func TestOpenRepositoryBare(t *testing.T) {
+	project := t.TempDir()
+	repo := TigCtx{ProjectPath: project, TigPath: path.Join(project, TigRootPath)}
+	if err := repo.Init(); err != nil {
+		t.Fatalf("Init(): %s", err)
+	}
+	// A bare repository whose directory is named like a tig directory
+	bareDir := path.Join(t.TempDir(), TigRootPath)
+	bare := TigCtx{ProjectPath: bareDir, TigPath: bareDir}
+	if err := bare.Init(); err != nil {
+		t.Fatalf("Init(): %s", err)
+	}
+
+	for _, test := range []struct {
+		repoPath string
+		bare     bool
+	}{
+		{project, false},
+		{repo.TigPath, false},
+		{bareDir, true},
+	} {
+		ctx, err := OpenRepository(test.repoPath)
+		if err != nil {
+			t.Fatalf("OpenRepository(%s): %s", test.repoPath, err)
+		}
+		if ctx.IsBare() != test.bare {
+			t.Fatalf("OpenRepository(%s).IsBare() must be %v, not %v", test.repoPath, test.bare, ctx.IsBare())
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Package tigdiff contains line oriented diff and merge functions
+package tigdiff
+
+import (
+	"bytes"
+	"strings"
+	"tig/internal/tigfile"
+)
+
+type EditOp int
+
+const (
+	EQUAL  EditOp = 0
+	INSERT EditOp = 1
+	DELETE EditOp = 2
+)
+
+// Over this number of differences, Diff stops searching for the shortest script
+// and replace the whole remaining block
+const maxEditDistance = 4096
+
+// Edit is a single line operation to go from the old lines to the new lines.
+// OldLine/NewLine are 0-based, -1 when the line does not exist on this side.
+type Edit struct {
+	Op      EditOp
+	OldLine int
+	NewLine int
+	Text    string
+}
+
+// Lines split data in lines, without the line ending.
+// A final line ending does not create an empty line.
+func Lines(data []byte) []string {
+	if len(data) == 0 {
+		return []string{}
+	}
+	data = bytes.TrimSuffix(data, []byte("\n"))
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// RawLines split data in lines like [Lines], each line keeps its line ending.
+// The last line has no line ending if data does not end with one.
+func RawLines(data []byte) []string {
+	lines := []string{}
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n') + 1
+		if end == 0 {
+			end = len(data)
+		}
+		lines = append(lines, string(data[:end]))
+		data = data[end:]
+	}
+	return lines
+}
+
+// Join is the reverse of [Lines]
+func Join(lines []string) []byte {
+	if len(lines) == 0 {
+		return []byte{}
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+// IsBinary guess if data is a binary content (NUL byte in the first tigfile.BINARY_CHECK_SIZE bytes)
+func IsBinary(data []byte) bool {
+	if len(data) > tigfile.BINARY_CHECK_SIZE {
+		data = data[:tigfile.BINARY_CHECK_SIZE]
+	}
+	return bytes.IndexByte(data, 0) != -1
+}
+
+// Diff compute the shortest edit script from a to b (Myers algorithm)
+func Diff(a, b []string) []Edit {
+	// Common prefix and suffix are trimmed first, it's the most common case
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	edits := make([]Edit, 0, len(a)+len(b))
+	for i := 0; i < prefix; i++ {
+		edits = append(edits, Edit{Op: EQUAL, OldLine: i, NewLine: i, Text: a[i]})
+	}
+	edits = append(edits, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix], prefix, prefix)...)
+	for i := suffix; i > 0; i-- {
+		oldLine, newLine := len(a)-i, len(b)-i
+		edits = append(edits, Edit{Op: EQUAL, OldLine: oldLine, NewLine: newLine, Text: a[oldLine]})
+	}
+	return edits
+}
+
+func myers(a, b []string, oldOffset, newOffset int) []Edit {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return replace(a, b, oldOffset, newOffset)
+	}
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+	found := false
+	for d := 0; d <= max && !found; d++ {
+		if d > maxEditDistance {
+			return replace(a, b, oldOffset, newOffset)
+		}
+		// Only diagonals -d..d are read when backtracking
+		snapshot := make([]int, 2*d+3)
+		copy(snapshot, v[offset-d-1:offset+d+2])
+		trace = append(trace, snapshot)
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+
+	// Backtrack from the end to build the script
+	var edits []Edit
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		snapshot := trace[d]
+		get := func(k int) int { return snapshot[k+d+1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && get(k-1) < get(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := get(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			edits = append(edits, Edit{Op: EQUAL, OldLine: oldOffset + x - 1, NewLine: newOffset + y - 1, Text: a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				edits = append(edits, Edit{Op: INSERT, OldLine: -1, NewLine: newOffset + y - 1, Text: b[y-1]})
+			} else {
+				edits = append(edits, Edit{Op: DELETE, OldLine: oldOffset + x - 1, NewLine: -1, Text: a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// replace delete all a lines then insert all b lines
+func replace(a, b []string, oldOffset, newOffset int) []Edit {
+	edits := make([]Edit, 0, len(a)+len(b))
+	for i, line := range a {
+		edits = append(edits, Edit{Op: DELETE, OldLine: oldOffset + i, NewLine: -1, Text: line})
+	}
+	for i, line := range b {
+		edits = append(edits, Edit{Op: INSERT, OldLine: -1, NewLine: newOffset + i, Text: line})
+	}
+	return edits
+}
+
+// HasChanges return true if the edit script contains any insertion or deletion
+func HasChanges(edits []Edit) bool {
+	for _, edit := range edits {
+		if edit.Op != EQUAL {
+			return true
+		}
+	}
+	return false
+}
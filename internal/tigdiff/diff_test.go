@@ -0,0 +1,147 @@
+package tigdiff
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// applyEdits rebuild the new lines from an edit script, checking old lines on the way
+func applyEdits(t *testing.T, a []string, edits []Edit) []string {
+	var result []string
+	oldLine := 0
+	for _, edit := range edits {
+		switch edit.Op {
+		case EQUAL:
+			if a[edit.OldLine] != edit.Text || edit.OldLine != oldLine {
+				t.Fatalf("Bad EQUAL edit at old line %d: %q", edit.OldLine, edit.Text)
+			}
+			result = append(result, edit.Text)
+			oldLine++
+		case DELETE:
+			if a[edit.OldLine] != edit.Text || edit.OldLine != oldLine {
+				t.Fatalf("Bad DELETE edit at old line %d: %q", edit.OldLine, edit.Text)
+			}
+			oldLine++
+		case INSERT:
+			result = append(result, edit.Text)
+		}
+	}
+	return result
+}
+
+func TestDiff(t *testing.T) {
+	cases := []struct {
+		a, b    string
+		changes int
+	}{
+		{"", "", 0},
+		{"a b c", "a b c", 0},
+		{"", "a b", 2},
+		{"a b", "", 2},
+		{"a b c a b b a", "c b a b a c", 5},
+		{"a b c d e", "a x c d y e", 3},
+	}
+	for _, c := range cases {
+		a, b := strings.Fields(c.a), strings.Fields(c.b)
+		edits := Diff(a, b)
+		if result := applyEdits(t, a, edits); !slices.Equal(result, b) {
+			t.Fatalf("Diff(%q, %q) gives %q", c.a, c.b, result)
+		}
+		changes := 0
+		for _, edit := range edits {
+			if edit.Op != EQUAL {
+				changes++
+			}
+		}
+		if changes != c.changes {
+			t.Fatalf("Diff(%q, %q) must have %d changes, not %d", c.a, c.b, c.changes, changes)
+		}
+	}
+}
+
+func TestLines(t *testing.T) {
+	if lines := Lines([]byte("a\nb\r\n\nc\n")); !slices.Equal(lines, []string{"a", "b", "", "c"}) {
+		t.Fatalf("Bad lines: %q", lines)
+	}
+	if data := string(Join([]string{"a", "", "c"})); data != "a\n\nc\n" {
+		t.Fatalf("Bad join: %q", data)
+	}
+	if lines := RawLines([]byte("a\nb\r\n\nc")); !slices.Equal(lines, []string{"a\n", "b\r\n", "\n", "c"}) {
+		t.Fatalf("Bad raw lines: %q", lines)
+	}
+}
+
+func TestMerge3(t *testing.T) {
+	cases := []struct {
+		base, ours, theirs, result string
+		conflict                   bool
+	}{
+		{"a b c", "a b c", "a x c", "a x c", false},
+		{"a b c", "a x c", "a b c", "a x c", false},
+		{"a b c d e", "z a b c d e", "a b c d e y", "z a b c d e y", false},
+		{"a b c d e", "a x c d e", "a b c y e", "a x c y e", false},
+		{"a b c", "a x c", "a x c", "a x c", false},
+		{"a b c", "a x c", "a y c", "a <<<<<<< ours x ======= y >>>>>>> theirs c", true},
+	}
+	for _, c := range cases {
+		merged, conflict := Merge3(strings.Fields(c.base), strings.Fields(c.ours),
+			strings.Fields(c.theirs), "ours", "theirs")
+		// Conflict markers contain a space, split again to compare
+		result := strings.Fields(strings.Join(merged, " "))
+		if !slices.Equal(result, strings.Fields(c.result)) {
+			t.Fatalf("Merge3(%q, %q, %q) gives %q", c.base, c.ours, c.theirs, result)
+		}
+		if conflict != c.conflict {
+			t.Fatalf("Merge3(%q, %q, %q) conflict must be %t", c.base, c.ours, c.theirs, c.conflict)
+		}
+	}
+}
+
+func TestHunks(t *testing.T) {
+	a := strings.Fields("1 2 3 4 5 6 7 8 9 10 11 12 13 14 15")
+	b := strings.Fields("1 x 3 4 5 6 7 8 9 10 11 12 13 y 15 16")
+	hunks := Hunks(Diff(a, b), DEFAULT_CONTEXT)
+	if len(hunks) != 2 {
+		t.Fatalf("Must have 2 hunks, not %d", len(hunks))
+	}
+	if header := hunks[0].Header(); header != "@@ -1,5 +1,5 @@" {
+		t.Fatalf("Bad first hunk header: %s", header)
+	}
+	if header := hunks[1].Header(); header != "@@ -11,5 +11,6 @@" {
+		t.Fatalf("Bad second hunk header: %s", header)
+	}
+	// Close changes are in the same hunk
+	b = strings.Fields("1 x 3 4 5 6 7 y 9 10 11 12 13 14 15")
+	if hunks = Hunks(Diff(a, b), DEFAULT_CONTEXT); len(hunks) != 1 {
+		t.Fatalf("Must have 1 hunk, not %d", len(hunks))
+	}
+}
+
+func TestSplitHunk(t *testing.T) {
+	a := strings.Fields("1 2 3 4 5 6 7 8 9 10")
+	b := strings.Fields("1 x 3 4 5 6 y 8 9 10")
+	hunks := Hunks(Diff(a, b), DEFAULT_CONTEXT)
+	if len(hunks) != 1 {
+		t.Fatalf("Must have 1 hunk, not %d", len(hunks))
+	}
+	parts := SplitHunk(hunks[0])
+	if len(parts) != 2 {
+		t.Fatalf("Must split in 2 hunks, not %d", len(parts))
+	}
+	if header := parts[0].Header(); header != "@@ -1,4 +1,4 @@" {
+		t.Fatalf("Bad first part header: %s", header)
+	}
+	if header := parts[1].Header(); header != "@@ -5,6 +5,6 @@" {
+		t.Fatalf("Bad second part header: %s", header)
+	}
+	// Every edit is kept, in order
+	var oldText, newText []string
+	for _, part := range parts {
+		oldText = append(oldText, part.OldText()...)
+		newText = append(newText, part.NewText()...)
+	}
+	if !slices.Equal(oldText, hunks[0].OldText()) || !slices.Equal(newText, hunks[0].NewText()) {
+		t.Fatalf("Split hunks differ from the hunk: %v %v", oldText, newText)
+	}
+}
@@ -0,0 +1,91 @@
+package tigdiff
+
+import (
+	"slices"
+	"strings"
+)
+
+const (
+	ConflictStart  = "<<<<<<<"
+	ConflictMiddle = "======="
+	ConflictEnd    = ">>>>>>>"
+)
+
+// matches return for each line of a the index of the same line in b, or -1
+func matches(a, b []string) []int {
+	match := make([]int, len(a))
+	for i := range match {
+		match[i] = -1
+	}
+	for _, edit := range Diff(a, b) {
+		if edit.Op == EQUAL {
+			match[edit.OldLine] = edit.NewLine
+		}
+	}
+	return match
+}
+
+// Merge3 merge the changes made from base to ours and from base to theirs (diff3 algorithm).
+// Conflicting blocks are written with conflict markers, named with oursName and theirsName.
+// It returns the merged lines and true if there is at least one conflict.
+func Merge3(base, ours, theirs []string, oursName, theirsName string) ([]string, bool) {
+	matchOurs := matches(base, ours)
+	matchTheirs := matches(base, theirs)
+	var result []string
+	conflict := false
+
+	i, j, k := 0, 0, 0
+	for i < len(base) || j < len(ours) || k < len(theirs) {
+		// Stable block: same lines in the 3 versions
+		l := 0
+		for i+l < len(base) && matchOurs[i+l] == j+l && matchTheirs[i+l] == k+l {
+			l++
+		}
+		if l > 0 {
+			result = append(result, base[i:i+l]...)
+			i, j, k = i+l, j+l, k+l
+			continue
+		}
+		// Unstable block: up to the next base line present in the 3 versions
+		next := i
+		for next < len(base) && (matchOurs[next] == -1 || matchTheirs[next] == -1) {
+			next++
+		}
+		nextOurs, nextTheirs := len(ours), len(theirs)
+		if next < len(base) {
+			nextOurs, nextTheirs = matchOurs[next], matchTheirs[next]
+		}
+		baseBlock, oursBlock, theirsBlock := base[i:next], ours[j:nextOurs], theirs[k:nextTheirs]
+		switch {
+		case slices.Equal(oursBlock, baseBlock):
+			result = append(result, theirsBlock...)
+		case slices.Equal(theirsBlock, baseBlock), slices.Equal(oursBlock, theirsBlock):
+			result = append(result, oursBlock...)
+		default:
+			conflict = true
+			result = append(result, ConflictStart+" "+oursName)
+			result = append(result, oursBlock...)
+			result = append(result, ConflictMiddle)
+			result = append(result, theirsBlock...)
+			result = append(result, ConflictEnd+" "+theirsName)
+		}
+		i, j, k = next, nextOurs, nextTheirs
+	}
+	return result, conflict
+}
+
+// HasConflictMarkers return true if lines contain a complete conflict block written by [Merge3]
+func HasConflictMarkers(lines []string) bool {
+	state := 0 // 1 after the start marker, 2 after the middle one
+	for _, line := range lines {
+		switch {
+		case state == 0 && strings.HasPrefix(line, ConflictStart+" "):
+			state = 1
+		case state == 1 && line == ConflictMiddle:
+			state = 2
+		case state == 2 && strings.HasPrefix(line, ConflictEnd+" "):
+			return true
+		}
+	}
+	return false
+}
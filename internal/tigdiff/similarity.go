@@ -0,0 +1,30 @@
+package tigdiff
+
+import "bytes"
+
+// Similarity return how much a and b are alike, in percent.
+// It's the proportion of lines they have in common, binary contents must be identical.
+func Similarity(a, b []byte) int {
+	if bytes.Equal(a, b) {
+		return 100
+	}
+	if IsBinary(a) || IsBinary(b) {
+		return 0
+	}
+	linesA, linesB := Lines(a), Lines(b)
+	if len(linesA)+len(linesB) == 0 {
+		return 100
+	}
+	count := make(map[string]int, len(linesA))
+	for _, line := range linesA {
+		count[line]++
+	}
+	common := 0
+	for _, line := range linesB {
+		if count[line] > 0 {
+			count[line]--
+			common++
+		}
+	}
+	return common * 2 * 100 / (len(linesA) + len(linesB))
+}
@@ -0,0 +1,212 @@
+package tigdiff
+
+import (
+	"fmt"
+	"io"
+)
+
+// Number of unchanged lines shown around a change
+const DEFAULT_CONTEXT = 3
+
+// Hunk is a group of close changes with their context lines. Start lines are 1-based.
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Edits    []Edit
+}
+
+// Header return the "@@ -l,s +l,s @@" line of the hunk
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+}
+
+// Hunks group the changes of an edit script, with context unchanged lines around them
+func Hunks(edits []Edit, context int) []Hunk {
+	var hunks []Hunk
+	oldPos, newPos := 0, 0 // Lines before edits[i]
+	i := 0
+	for i < len(edits) {
+		if edits[i].Op == EQUAL {
+			oldPos++
+			newPos++
+			i++
+			continue
+		}
+		start := max(0, i-context)
+		oldStart, newStart := oldPos-(i-start), newPos-(i-start)
+		end := i // Last change of the hunk
+		for j := i; j < len(edits); {
+			if edits[j].Op != EQUAL {
+				end = j
+				j++
+				continue
+			}
+			k := j
+			for k < len(edits) && edits[k].Op == EQUAL {
+				k++
+			}
+			if k == len(edits) || k-j > 2*context {
+				break
+			}
+			j = k
+		}
+		stop := min(len(edits), end+1+context)
+		hunks = append(hunks, newHunk(edits[start:stop], oldStart, newStart))
+		for _, edit := range edits[i:stop] {
+			if edit.Op != INSERT {
+				oldPos++
+			}
+			if edit.Op != DELETE {
+				newPos++
+			}
+		}
+		i = stop
+	}
+	return hunks
+}
+
+// newHunk create the hunk of edits, oldPos/newPos are the number of lines before it
+func newHunk(edits []Edit, oldPos, newPos int) Hunk {
+	hunk := Hunk{Edits: edits}
+	for _, edit := range hunk.Edits {
+		if edit.Op != INSERT {
+			hunk.OldLines++
+		}
+		if edit.Op != DELETE {
+			hunk.NewLines++
+		}
+	}
+	// Like diff, an empty side starts at the line before
+	hunk.OldStart, hunk.NewStart = oldPos, newPos
+	if hunk.OldLines > 0 {
+		hunk.OldStart++
+	}
+	if hunk.NewLines > 0 {
+		hunk.NewStart++
+	}
+	return hunk
+}
+
+// OldPos return the number of old lines before the hunk
+func (h Hunk) OldPos() int {
+	if h.OldLines > 0 {
+		return h.OldStart - 1
+	}
+	return h.OldStart
+}
+
+// NewPos return the number of new lines before the hunk
+func (h Hunk) NewPos() int {
+	if h.NewLines > 0 {
+		return h.NewStart - 1
+	}
+	return h.NewStart
+}
+
+// OldText return the lines of the hunk before the change
+func (h Hunk) OldText() []string {
+	lines := make([]string, 0, h.OldLines)
+	for _, edit := range h.Edits {
+		if edit.Op != INSERT {
+			lines = append(lines, edit.Text)
+		}
+	}
+	return lines
+}
+
+// NewText return the lines of the hunk after the change
+func (h Hunk) NewText() []string {
+	lines := make([]string, 0, h.NewLines)
+	for _, edit := range h.Edits {
+		if edit.Op != DELETE {
+			lines = append(lines, edit.Text)
+		}
+	}
+	return lines
+}
+
+// SplitHunk split a hunk in smaller ones, one per group of consecutive changes.
+// The unchanged lines between two groups are shared out, so every edit stays in a single hunk.
+func SplitHunk(hunk Hunk) []Hunk {
+	var groupStarts, groupEnds []int
+	for i, edit := range hunk.Edits {
+		if edit.Op == EQUAL {
+			continue
+		}
+		if n := len(groupEnds); n > 0 && groupEnds[n-1] == i {
+			groupEnds[n-1] = i + 1
+		} else {
+			groupStarts = append(groupStarts, i)
+			groupEnds = append(groupEnds, i+1)
+		}
+	}
+	if len(groupStarts) < 2 {
+		return []Hunk{hunk}
+	}
+	hunks := make([]Hunk, 0, len(groupStarts))
+	oldPos, newPos := hunk.OldPos(), hunk.NewPos()
+	start := 0
+	for g := range groupStarts {
+		stop := len(hunk.Edits)
+		if g+1 < len(groupStarts) {
+			stop = groupEnds[g] + (groupStarts[g+1]-groupEnds[g]+1)/2
+		}
+		part := newHunk(hunk.Edits[start:stop], oldPos, newPos)
+		hunks = append(hunks, part)
+		oldPos += part.OldLines
+		newPos += part.NewLines
+		start = stop
+	}
+	return hunks
+}
+
+// WriteHunk write the hunk header and lines in unified format
+func WriteHunk(w io.Writer, hunk Hunk) error {
+	if _, err := fmt.Fprintln(w, hunk.Header()); err != nil {
+		return err
+	}
+	for _, edit := range hunk.Edits {
+		prefix := " "
+		if edit.Op == INSERT {
+			prefix = "+"
+		} else if edit.Op == DELETE {
+			prefix = "-"
+		}
+		if _, err := fmt.Fprintln(w, prefix+edit.Text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteBinary write the diff of two different binary contents, which is only a notice
+func WriteBinary(w io.Writer, oldName, newName string) error {
+	_, err := fmt.Fprintf(w, "Binary files %s and %s differ\n", oldName, newName)
+	return err
+}
+
+// WriteUnified write the diff from oldData to newData in unified format.
+// Nothing is written if both are the same.
+func WriteUnified(w io.Writer, oldName, newName string, oldData, newData []byte, context int) error {
+	if IsBinary(oldData) || IsBinary(newData) {
+		if string(oldData) == string(newData) {
+			return nil
+		}
+		return WriteBinary(w, oldName, newName)
+	}
+	hunks := Hunks(Diff(Lines(oldData), Lines(newData)), context)
+	if len(hunks) == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "--- %s\n+++ %s\n", oldName, newName); err != nil {
+		return err
+	}
+	for _, hunk := range hunks {
+		if err := WriteHunk(w, hunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package tigfile
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Prefix of the temporary files of the atomic writes, next to the file they replace
+const ATOMIC_TMP_PREFIX = ".tmp-"
+
+var ErrHashMismatch = errors.New("Content does not match its hash")
+
+// writeAtomic replace filename by the content written by write. The content goes to a temporary file of
+// the same directory which is synced then renamed over filename, and the directory is synced so the rename
+// survives a crash. filename always has either its old or its new content, never a part of it.
+func writeAtomic(filename string, write func(f *os.File) error) (err error) {
+	dir := filepath.Dir(filename)
+	tmp, err := os.CreateTemp(dir, ATOMIC_TMP_PREFIX+filepath.Base(filename)+"-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if err = write(tmp); err != nil {
+		return err
+	}
+	if err = syncTemp(tmp); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+	return SyncDir(dir)
+}
+
+// syncTemp flush and close a temporary file before it is renamed
+func syncTemp(tmp *os.File) error {
+	if err := tmp.Chmod(FILE_PERM); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	return tmp.Close()
+}
+
+// StoreFileByHash copy fileSrc into the directory dir, named by the sha1 of its content, and return it.
+// The content is hashed while it is copied, in a single streaming pass, so the name always matches the
+// content even if fileSrc changes meanwhile. An existing file of the same name is kept.
+func StoreFileByHash(fileSrc string, dir string) (string, error) {
+	fSrc, err := Open(fileSrc, os.O_RDONLY)
+	if err != nil {
+		return "", err
+	}
+	defer fSrc.Close()
+	hash, _, err := StoreByHash(fSrc, dir)
+	return hash, err
+}
+
+// StoreByHash copy the content read from r into the directory dir, like [StoreFileByHash].
+// It returns the hash and the size of the content.
+func StoreByHash(r io.Reader, dir string) (hash string, size int64, err error) {
+	tmp, err := os.CreateTemp(dir, ATOMIC_TMP_PREFIX+"store-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+		}
+		os.Remove(tmp.Name()) // No-op once renamed
+	}()
+	h := sha1.New()
+	if size, err = io.Copy(io.MultiWriter(tmp, h), r); err != nil {
+		return "", 0, err
+	}
+	hash = hex.EncodeToString(h.Sum(nil))
+	filename := filepath.Join(dir, hash)
+	if _, err = os.Stat(filename); err == nil {
+		return hash, size, tmp.Close()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", 0, err
+	}
+	if err = syncTemp(tmp); err != nil {
+		return "", 0, err
+	}
+	if err = os.Rename(tmp.Name(), filename); err != nil {
+		return "", 0, err
+	}
+	return hash, size, SyncDir(dir)
+}
+
+// SyncDir flush the entries of the directory dir to the disk
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// WriteFileAtomic write a bytes buffer to a file with an atomic replace, see [writeAtomic]
+func WriteFileAtomic(filename string, data []byte) error {
+	return writeAtomic(filename, func(f *os.File) error {
+		_, err := f.Write(data)
+		return err
+	})
+}
+
+// WriteFileAtomicString write a string to a file with an atomic replace, see [writeAtomic]
+func WriteFileAtomicString(filename string, data string) error {
+	return writeAtomic(filename, func(f *os.File) error {
+		_, err := f.WriteString(data)
+		return err
+	})
+}
+
+// WriteFileAtomicLines write a list of string (one per line) to a file with an atomic replace, see [writeAtomic]
+func WriteFileAtomicLines(filename string, data []string) error {
+	return WriteFileAtomicString(filename, joinLines(data))
+}
+
+// WriteFileAtomicHash write the content read from r to a file with an atomic replace, see [writeAtomic].
+// The content is hashed while it is written, filename is not written if its sha1 is not hash.
+func WriteFileAtomicHash(filename string, r io.Reader, hash string) error {
+	return writeAtomic(filename, func(f *os.File) error {
+		h := sha1.New()
+		if _, err := io.Copy(io.MultiWriter(f, h), r); err != nil {
+			return err
+		}
+		if hex.EncodeToString(h.Sum(nil)) != hash {
+			return ErrHashMismatch
+		}
+		return nil
+	})
+}
+
+// CopyFileAtomic copy the fileSrc to fileDest with an atomic replace, see [writeAtomic]
+func CopyFileAtomic(fileSrc, fileDest string) error {
+	fSrc, err := Open(fileSrc, os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	defer fSrc.Close()
+	return writeAtomic(fileDest, func(f *os.File) error {
+		_, err := io.Copy(f, fSrc)
+		return err
+	})
+}
+
+// joinLines return the lines terminated by a newline
+func joinLines(data []string) string {
+	var builder strings.Builder
+	for i := 0; i < len(data); i++ {
+		builder.WriteString(data[i])
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
@@ -0,0 +1,63 @@
+package tigfile
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	tmpDirPath := t.TempDir()
+	filePath := path.Join(tmpDirPath, "index")
+	for _, content := range []string{"first", "second"} {
+		if err := WriteFileAtomic(filePath, []byte(content)); err != nil {
+			t.Fatalf("WriteFileAtomic(): %s", err)
+		}
+		data, err := ReadFileBytes(filePath, -1)
+		if err != nil || string(data) != content {
+			t.Fatalf("File must contain %q, not %q (%v)", content, data, err)
+		}
+	}
+	if err := WriteFileAtomic(path.Join(tmpDirPath, "missing", "index"), []byte("x")); err == nil {
+		t.Fatalf("WriteFileAtomic() in a missing directory must fail")
+	}
+	entries, err := os.ReadDir(tmpDirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ATOMIC_TMP_PREFIX) {
+			t.Fatalf("Temporary file %s must be removed", entry.Name())
+		}
+	}
+}
+
+func TestStoreFileByHash(t *testing.T) {
+	tmpDirPath := t.TempDir()
+	srcPath := path.Join(tmpDirPath, "src")
+	if err := WriteFileString(srcPath, "content\n"); err != nil {
+		t.Fatal(err)
+	}
+	store := path.Join(tmpDirPath, "store")
+	if err := os.Mkdir(store, DIR_PERM); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		hash, err := StoreFileByHash(srcPath, store)
+		if err != nil {
+			t.Fatalf("StoreFileByHash(): %s", err)
+		}
+		if expected := HashBytes([]byte("content\n")); hash != expected {
+			t.Fatalf("StoreFileByHash() must return %s, not %s", expected, hash)
+		}
+		data, err := ReadFileBytes(path.Join(store, hash), -1)
+		if err != nil || string(data) != "content\n" {
+			t.Fatalf("Stored file must contain the source, not %q (%v)", data, err)
+		}
+	}
+	entries, err := os.ReadDir(store)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Store must only contain the stored file: %v (%v)", entries, err)
+	}
+}
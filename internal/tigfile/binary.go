@@ -0,0 +1,141 @@
+package tigfile
+
+/*
+How to store a binary file:
+- Magic (4 bytes) identifying the file, then the format version (uint32)
+- Content written with the BinaryWriter methods, integers are big endian,
+  strings are prefixed with their length (uvarint) so any byte is allowed in them
+- The sha1 (20 bytes) of everything before, to detect a truncated or corrupted file
+*/
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var ErrBadMagic = errors.New("Not a tig binary file")
+var ErrBadChecksum = errors.New("Bad checksum, the file is corrupted")
+var ErrTruncated = errors.New("Unexpected end of file")
+
+// BinaryWriter build the content of a binary file in memory
+type BinaryWriter struct {
+	buf bytes.Buffer
+}
+
+// NewBinaryWriter start a binary file with its magic and version
+func NewBinaryWriter(magic string, version uint32) *BinaryWriter {
+	w := &BinaryWriter{}
+	w.buf.WriteString(magic)
+	w.WriteUint32(version)
+	return w
+}
+
+func (w *BinaryWriter) WriteUint32(v uint32) {
+	w.buf.Write(binary.BigEndian.AppendUint32(nil, v))
+}
+
+func (w *BinaryWriter) WriteUint64(v uint64) {
+	w.buf.Write(binary.BigEndian.AppendUint64(nil, v))
+}
+
+func (w *BinaryWriter) WriteString(s string) {
+	w.buf.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	w.buf.WriteString(s)
+}
+
+// Bytes return the content followed by its checksum
+func (w *BinaryWriter) Bytes() []byte {
+	sum := sha1.Sum(w.buf.Bytes())
+	return append(bytes.Clone(w.buf.Bytes()), sum[:]...)
+}
+
+// WriteFile write the content followed by its checksum to filename, atomically
+func (w *BinaryWriter) WriteFile(filename string) error {
+	return WriteFileAtomic(filename, w.Bytes())
+}
+
+// BinaryReader read the content of a binary file. The first error is kept,
+// following reads return zero values, so it can be checked once at the end with Err.
+type BinaryReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+// HasMagic return true if data starts with magic
+func HasMagic(data []byte, magic string) bool {
+	return bytes.HasPrefix(data, []byte(magic))
+}
+
+// NewBinaryReader check the magic and the checksum of data and return a reader on its content
+func NewBinaryReader(data []byte, magic string) (*BinaryReader, uint32, error) {
+	if !HasMagic(data, magic) {
+		return nil, 0, ErrBadMagic
+	}
+	if len(data) < len(magic)+4+sha1.Size {
+		return nil, 0, ErrTruncated
+	}
+	content := data[:len(data)-sha1.Size]
+	if sum := sha1.Sum(content); !bytes.Equal(sum[:], data[len(content):]) {
+		return nil, 0, ErrBadChecksum
+	}
+	r := &BinaryReader{data: content, pos: len(magic)}
+	version := r.ReadUint32()
+	return r, version, r.err
+}
+
+func (r *BinaryReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.pos+n > len(r.data) {
+		r.err = fmt.Errorf("%w at byte %d", ErrTruncated, r.pos)
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *BinaryReader) ReadUint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *BinaryReader) ReadUint64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *BinaryReader) ReadString() string {
+	if r.err != nil {
+		return ""
+	}
+	size, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 || size > uint64(len(r.data)) {
+		r.err = fmt.Errorf("%w at byte %d", ErrTruncated, r.pos)
+		return ""
+	}
+	r.pos += n
+	return string(r.next(int(size)))
+}
+
+// Err return the first error met while reading
+func (r *BinaryReader) Err() error {
+	return r.err
+}
+
+// Done return an error if the content was not read entirely, or the first read error
+func (r *BinaryReader) Done() error {
+	if r.err == nil && r.pos != len(r.data) {
+		return fmt.Errorf("Unexpected data at byte %d", r.pos)
+	}
+	return r.err
+}
@@ -15,6 +15,14 @@ func B64Str(s string) string {
 	return base64.StdEncoding.EncodeToString(StrToBytes(s))
 }
 
+func B64DecodeStr(s string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
 func HashBytes(data []byte) string {
 	h := sha1.New()
 	h.Write(data)
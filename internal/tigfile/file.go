@@ -1,20 +1,26 @@
 package tigfile
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/sha1"
 	"encoding/hex"
 	"errors"
 	"io"
 	"os"
-	"path"
 	"strconv"
-	"strings"
 )
 
-// In bytes, last number = Mo
+// Maximum size of a content loaded in memory to be diffed, merged or blamed, in bytes, last number = Mo.
+// Larger contents are only streamed: hashed, copied, restored, and handled as binary by the diffs.
 const MAX_FILE_SIZE = 1024 * 1024 * 64
 
+// Maximum length of a line of the metadata files, in bytes
+const MAX_LINE_SIZE = 1024 * 1024
+
+// Number of bytes read to guess if a content is binary, see [ReadFileText]
+const BINARY_CHECK_SIZE = 8000
+
 // Default permission when creating a file
 const FILE_PERM = 0o764
 
@@ -74,18 +80,39 @@ func ReadFdBytes(f *os.File, limit int) ([]byte, error) {
 	}
 }
 
+// ScanLines call fn with each line read from r, without its newline. Only the current line is in memory.
+func ScanLines(r io.Reader, fn func(line string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), MAX_LINE_SIZE)
+	for scanner.Scan() {
+		if err := fn(scanner.Text()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// ReadFdLines return the non empty lines of f, no more than 'limit' bytes are read (-1 for unlimited)
 func ReadFdLines(f *os.File, limit int) ([]string, error) {
-	fileBytes, err := ReadFdBytes(f, limit)
+	var lines []string
+	read := 0
+	err := ScanLines(f, func(line string) error {
+		read += len(line) + 1
+		if limit > 0 && read > limit {
+			return errors.New("Can't read file bigger than " + strconv.Itoa(limit) + " bytes")
+		}
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	var bufStrLines = []string{}
-	for _, v := range bytes.Split(fileBytes, []byte("\n")) {
-		if len(v) > 0 {
-			bufStrLines = append(bufStrLines, string(v))
-		}
+	if lines == nil {
+		lines = []string{}
 	}
-	return bufStrLines, nil
+	return lines, nil
 }
 
 func ReadFileBytes(filePath string, limit int) ([]byte, error) {
@@ -106,6 +133,36 @@ func ReadFileLines(filePath string, limit int) ([]string, error) {
 	return ReadFdLines(f, limit)
 }
 
+// ReadFileText return the content of a text file. A binary file (NUL byte in its first BINARY_CHECK_SIZE
+// bytes) or a file bigger than MAX_FILE_SIZE is not loaded: nil and true are returned.
+func ReadFileText(filePath string) ([]byte, bool, error) {
+	f, err := Open(filePath, os.O_RDONLY)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+	if info.Size() > MAX_FILE_SIZE {
+		return nil, true, nil
+	}
+	prefix := make([]byte, BINARY_CHECK_SIZE)
+	n, err := io.ReadFull(f, prefix)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, false, err
+	}
+	if bytes.IndexByte(prefix[:n], 0) != -1 {
+		return nil, true, nil
+	}
+	rest, err := ReadFdBytes(f, MAX_FILE_SIZE)
+	if err != nil {
+		return nil, false, err
+	}
+	return append(prefix[:n], rest...), false, nil
+}
+
 // WriteFileString write a string to a file, create it if needed (truncate)
 func WriteFileString(filename string, data string) error {
 	var err error
@@ -130,12 +187,7 @@ func WriteFileLines(filename string, data []string) error {
 	}
 	defer file.Close()
 
-	var builder strings.Builder
-	for i := 0; i < len(data); i++ {
-		builder.WriteString(data[i])
-		builder.WriteString("\n")
-	}
-	_, err = file.WriteString(builder.String())
+	_, err = file.WriteString(joinLines(data))
 	if err != nil {
 		return err
 	}
@@ -157,36 +209,9 @@ func WriteFileBytes(filename string, data []byte) error {
 	return nil
 }
 
+// GetDirTree return every file under rootDirPath, sorted, tig system files excepted
 func GetDirTree(rootDirPath string) ([]string, error) {
-	var fileList []string
-
-	dirToWalk := []string{rootDirPath}
-	for {
-		lenDirToWalk := len(dirToWalk)
-		if lenDirToWalk == 0 {
-			break
-		}
-		currentDir := dirToWalk[lenDirToWalk-1]
-		dirToWalk = dirToWalk[:lenDirToWalk-1]
-		dirEntries, err := os.ReadDir(currentDir)
-		if err != nil {
-			return nil, err
-		}
-		for _, v := range dirEntries {
-			tmpFileName := v.Name()
-			// Skip tig system files
-			if tmpFileName == ".tig" || tmpFileName == ".tigignore" || tmpFileName == ".git" {
-				continue
-			}
-			if v.IsDir() {
-				dirToWalk = append(dirToWalk, path.Join(currentDir, tmpFileName))
-			} else {
-				fileList = append(fileList, path.Join(currentDir, tmpFileName))
-			}
-
-		}
-	}
-	return fileList, nil
+	return WalkDir(rootDirPath, 0, nil)
 }
 
 // HashFile return the sha1 of a file
@@ -0,0 +1,60 @@
+package tigfile
+
+import (
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadFileText(t *testing.T) {
+	tmpDirPath := t.TempDir()
+	textPath := path.Join(tmpDirPath, "text")
+	if err := WriteFileString(textPath, "a\nb\n"); err != nil {
+		t.Fatal(err)
+	}
+	data, binary, err := ReadFileText(textPath)
+	if err != nil || binary || string(data) != "a\nb\n" {
+		t.Fatalf("ReadFileText() must return the text content, not %q %v (%v)", data, binary, err)
+	}
+
+	binaryPath := path.Join(tmpDirPath, "binary")
+	if err := WriteFileBytes(binaryPath, []byte("a\x00b")); err != nil {
+		t.Fatal(err)
+	}
+	// Sparse, nothing is written to the disk
+	bigPath := path.Join(tmpDirPath, "big")
+	if err := WriteFileString(bigPath, "a\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(bigPath, MAX_FILE_SIZE+1); err != nil {
+		t.Fatal(err)
+	}
+	for _, filePath := range []string{binaryPath, bigPath} {
+		data, binary, err := ReadFileText(filePath)
+		if err != nil || !binary || data != nil {
+			t.Fatalf("ReadFileText(%s) must not load the content: %d bytes %v (%v)", filePath, len(data), binary, err)
+		}
+	}
+}
+
+func TestReadFdLines(t *testing.T) {
+	filePath := path.Join(t.TempDir(), "lines")
+	if err := WriteFileString(filePath, "a\n\nb"); err != nil {
+		t.Fatal(err)
+	}
+	lines, err := ReadFileLines(filePath, -1)
+	if err != nil || !slices.Equal(lines, []string{"a", "b"}) {
+		t.Fatalf("ReadFileLines() must return the non empty lines, not %q (%v)", lines, err)
+	}
+	if _, err := ReadFileLines(filePath, 2); err == nil {
+		t.Fatalf("ReadFileLines() must fail over the limit")
+	}
+	if err := WriteFileString(filePath, strings.Repeat("a", MAX_LINE_SIZE+1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFileLines(filePath, -1); err == nil {
+		t.Fatalf("ReadFileLines() must fail for a line longer than MAX_LINE_SIZE")
+	}
+}
@@ -0,0 +1,80 @@
+package tigfile
+
+/*
+How to store a lock file:
+- Created exclusively, it exists while a process holds the lock
+- A single line with the pid and the host name of the owner
+
+###FILE START
+4242 my-laptop
+###FILE END
+
+*/
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var ErrLocked = errors.New("Another tig process is running")
+var ErrStaleLock = errors.New("Stale lock")
+
+// LockFile is an exclusive lock held by this process
+type LockFile struct {
+	Path string
+}
+
+// Lock take the exclusive lock filename. If it is held, ErrLocked is returned,
+// or ErrStaleLock if its owner is a process of this host which is not running anymore.
+func Lock(filename string) (*LockFile, error) {
+	host, _ := os.Hostname()
+	fd, err := Open(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil, lockOwnerError(filename, host)
+		}
+		return nil, fmt.Errorf("Lock: %w", err)
+	}
+	_, err = fd.WriteString(fmt.Sprintf("%d %s\n", os.Getpid(), host))
+	if closeErr := fd.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filename)
+		return nil, fmt.Errorf("Lock: %w", err)
+	}
+	return &LockFile{Path: filename}, nil
+}
+
+// lockOwnerError return the error explaining why the existing lock filename can't be taken
+func lockOwnerError(filename string, host string) error {
+	b, err := ReadFileBytes(filename, -1)
+	if err != nil {
+		// Released in between, or unreadable: let the user retry
+		return fmt.Errorf("%w: %s exists", ErrLocked, filename)
+	}
+	data := strings.Fields(string(b))
+	pid := 0
+	if len(data) > 0 {
+		pid, _ = strconv.Atoi(data[0])
+	}
+	if pid > 0 && len(data) > 1 && data[1] == host && !processAlive(pid) {
+		return fmt.Errorf("%w: %s was left by process %d which is not running, remove it if no tig process is running",
+			ErrStaleLock, filename, pid)
+	}
+	if pid > 0 {
+		return fmt.Errorf("%w (pid %d): %s exists", ErrLocked, pid, filename)
+	}
+	return fmt.Errorf("%w: %s exists", ErrLocked, filename)
+}
+
+// Unlock release the lock
+func (lock *LockFile) Unlock() error {
+	if err := os.Remove(lock.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("Unlock: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package tigfile
+
+// processAlive return true if the process pid is running, always assumed here
+func processAlive(pid int) bool {
+	return true
+}
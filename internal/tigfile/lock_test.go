@@ -0,0 +1,41 @@
+package tigfile
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+)
+
+func TestLock(t *testing.T) {
+	lockPath := path.Join(t.TempDir(), "index.lock")
+	lock, err := Lock(lockPath)
+	if err != nil {
+		t.Fatalf("Lock(): %s", err)
+	}
+	if _, err := Lock(lockPath); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Lock() of a held lock must fail with ErrLocked, not %v", err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatalf("Unlock(): %s", err)
+	}
+	lock, err = Lock(lockPath)
+	if err != nil {
+		t.Fatalf("Lock() after Unlock(): %s", err)
+	}
+	lock.Unlock()
+
+	// A lock left by a process of this host which is not running anymore
+	deadPid := 1<<31 - 1
+	if processAlive(deadPid) {
+		t.Skip("Process liveness is unknown on this platform")
+	}
+	host, _ := os.Hostname()
+	if err := WriteFileString(lockPath, strconv.Itoa(deadPid)+" "+host+"\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Lock(lockPath); !errors.Is(err, ErrStaleLock) {
+		t.Fatalf("Lock() of a stale lock must fail with ErrStaleLock, not %v", err)
+	}
+}
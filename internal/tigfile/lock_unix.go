@@ -0,0 +1,14 @@
+//go:build unix
+
+package tigfile
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processAlive return true if the process pid is running
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
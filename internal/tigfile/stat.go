@@ -0,0 +1,41 @@
+package tigfile
+
+import (
+	"os"
+	"time"
+)
+
+// A file modified less than RACY_WINDOW before its stat is taken can be modified again
+// without any visible change of its mtime (coarse file system timestamps)
+const RACY_WINDOW = time.Second
+
+// FileStat is the part of a file stat which changes when the file content changes
+type FileStat struct {
+	Size  int64
+	Mtime int64 // Nanoseconds
+	Ctime int64 // Nanoseconds, 0 if unknown
+	Inode uint64
+	Mode  uint32
+}
+
+// Stat return the stat of filepath
+func Stat(filepath string) (FileStat, error) {
+	info, err := os.Stat(filepath)
+	if err != nil {
+		return FileStat{}, err
+	}
+	ctime, inode := statSys(info)
+	return FileStat{
+		Size:  info.Size(),
+		Mtime: info.ModTime().UnixNano(),
+		Ctime: ctime,
+		Inode: inode,
+		Mode:  uint32(info.Mode()),
+	}, nil
+}
+
+// IsRacy return true if the file was modified too close to now to trust its stat
+func (stat FileStat) IsRacy(now time.Time) bool {
+	limit := now.Add(-RACY_WINDOW).UnixNano()
+	return stat.Mtime >= limit || stat.Ctime >= limit
+}
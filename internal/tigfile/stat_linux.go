@@ -0,0 +1,17 @@
+//go:build linux
+
+package tigfile
+
+import (
+	"os"
+	"syscall"
+)
+
+// statSys return the ctime and the inode of a file
+func statSys(info os.FileInfo) (int64, uint64) {
+	sys, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return int64(sys.Ctim.Sec)*1e9 + int64(sys.Ctim.Nsec), uint64(sys.Ino)
+}
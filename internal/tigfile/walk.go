@@ -0,0 +1,151 @@
+package tigfile
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"slices"
+	"sync"
+)
+
+// Workers return the size of a worker pool, workers <= 0 means one worker per CPU
+func Workers(workers int) int {
+	if workers <= 0 {
+		return runtime.NumCPU()
+	}
+	return workers
+}
+
+// ForEachParallel call fn for every index from 0 to count-1, with at most workers calls at the same time.
+// It returns the error of the lowest failing index, so errors are deterministic too.
+func ForEachParallel(count int, workers int, fn func(i int) error) error {
+	workers = min(Workers(workers), count)
+	errs := make([]error, count)
+	indexes := make(chan int, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errs[i] = fn(i)
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HashFiles return the sha1 of every file of fileList, in the same order, hashed by a pool of workers
+func HashFiles(fileList []string, workers int) ([]string, error) {
+	hashes := make([]string, len(fileList))
+	err := ForEachParallel(len(fileList), workers, func(i int) error {
+		hash, err := HashFile(fileList[i])
+		if err != nil {
+			return fmt.Errorf("HashFiles: %s: %w", fileList[i], err)
+		}
+		hashes[i] = hash
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// isSystemFile return true for the tig files and the other VCS directories, never walked
+func isSystemFile(name string) bool {
+	return name == ".tig" || name == ".tigignore" || name == ".git"
+}
+
+// dirWalker is the state shared by the workers of [WalkDir]
+type dirWalker struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []string // Directories to read
+	pending int      // Directories queued or being read
+	files   []string
+	err     error
+	skip    func(filepath string, isDir bool) bool
+}
+
+// next wait for a directory to read, it returns false when the walk is over
+func (w *dirWalker) next() (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for len(w.queue) == 0 && w.pending > 0 && w.err == nil {
+		w.cond.Wait()
+	}
+	if w.pending == 0 || w.err != nil {
+		return "", false
+	}
+	dir := w.queue[len(w.queue)-1]
+	w.queue = w.queue[:len(w.queue)-1]
+	return dir, true
+}
+
+// read list a directory, then queue its sub directories
+func (w *dirWalker) read(dir string) {
+	var files, dirs []string
+	dirEntries, err := os.ReadDir(dir)
+	if err == nil {
+		for _, v := range dirEntries {
+			if isSystemFile(v.Name()) {
+				continue
+			}
+			filePath := path.Join(dir, v.Name())
+			if w.skip != nil && w.skip(filePath, v.IsDir()) {
+				continue
+			}
+			if v.IsDir() {
+				dirs = append(dirs, filePath)
+			} else {
+				files = append(files, filePath)
+			}
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err != nil && w.err == nil {
+		w.err = fmt.Errorf("WalkDir: reading %s: %w", dir, err)
+	}
+	w.files = append(w.files, files...)
+	w.queue = append(w.queue, dirs...)
+	w.pending += len(dirs) - 1
+	w.cond.Broadcast()
+}
+
+// WalkDir return every file under rootDirPath, sorted, tig system files excepted.
+// Directories are read by a pool of workers, files and directories for which skip
+// returns true are skipped. skip is called concurrently.
+func WalkDir(rootDirPath string, workers int, skip func(filepath string, isDir bool) bool) ([]string, error) {
+	w := &dirWalker{queue: []string{rootDirPath}, pending: 1, skip: skip}
+	w.cond = sync.NewCond(&w.mu)
+	var wg sync.WaitGroup
+	for i := 0; i < Workers(workers); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dir, ok := w.next(); ok; dir, ok = w.next() {
+				w.read(dir)
+			}
+		}()
+	}
+	wg.Wait()
+	if w.err != nil {
+		return nil, w.err
+	}
+	slices.Sort(w.files)
+	return w.files, nil
+}
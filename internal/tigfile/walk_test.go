@@ -0,0 +1,149 @@
+package tigfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Number of files of the synthetic trees of the benchmarks
+const benchFiles = 100_000
+
+// makeTree create files files in root, 100 per directory, on two levels of directories
+func makeTree(tb testing.TB, root string, files int) {
+	for i := 0; i < files; i++ {
+		dir := path.Join(root, fmt.Sprintf("d%03d", i/10000), fmt.Sprintf("s%03d", i/100%100))
+		if i%100 == 0 {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				tb.Fatal(err)
+			}
+		}
+		content := []byte(fmt.Sprintf("file %d\n%s", i, strings.Repeat("x", i%512)))
+		if err := os.WriteFile(path.Join(dir, fmt.Sprintf("f%05d.txt", i)), content, 0o644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+// walkSerial is the single goroutine walker WalkDir replaced, kept as a reference
+func walkSerial(rootDirPath string) ([]string, error) {
+	var fileList []string
+	dirToWalk := []string{rootDirPath}
+	for len(dirToWalk) > 0 {
+		currentDir := dirToWalk[len(dirToWalk)-1]
+		dirToWalk = dirToWalk[:len(dirToWalk)-1]
+		dirEntries, err := os.ReadDir(currentDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range dirEntries {
+			if isSystemFile(v.Name()) {
+				continue
+			}
+			if filePath := path.Join(currentDir, v.Name()); v.IsDir() {
+				dirToWalk = append(dirToWalk, filePath)
+			} else {
+				fileList = append(fileList, filePath)
+			}
+		}
+	}
+	return fileList, nil
+}
+
+func TestWalkDir(t *testing.T) {
+	root := t.TempDir()
+	makeTree(t, root, 1000)
+	os.Mkdir(path.Join(root, ".tig"), 0o755)
+	os.WriteFile(path.Join(root, ".tig", "HEAD"), nil, 0o644)
+
+	expected, err := walkSerial(root)
+	if err != nil {
+		t.Fatalf("walkSerial(): %s", err)
+	}
+	slices.Sort(expected)
+	for _, workers := range []int{1, 4, 32} {
+		files, err := WalkDir(root, workers, nil)
+		if err != nil {
+			t.Fatalf("WalkDir(%d): %s", workers, err)
+		}
+		if !slices.Equal(files, expected) {
+			t.Fatalf("WalkDir(%d) found %d files, expected %d", workers, len(files), len(expected))
+		}
+	}
+
+	// Skipped directories are not walked
+	files, _ := WalkDir(root, 4, func(filepath string, isDir bool) bool {
+		return isDir && path.Base(filepath) == "s001"
+	})
+	if len(files) != 900 {
+		t.Fatalf("WalkDir with skip found %d files, expected 900", len(files))
+	}
+
+	if _, err := WalkDir(path.Join(root, "missing"), 4, nil); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("WalkDir on a missing directory: %v", err)
+	}
+}
+
+func TestHashFiles(t *testing.T) {
+	root := t.TempDir()
+	makeTree(t, root, 300)
+	files, _ := WalkDir(root, 0, nil)
+	hashes, err := HashFiles(files, 8)
+	if err != nil {
+		t.Fatalf("HashFiles(): %s", err)
+	}
+	for i, file := range files {
+		if hash, _ := HashFile(file); hash != hashes[i] {
+			t.Fatalf("Bad hash for %s", file)
+		}
+	}
+	_, err = HashFiles(append(files, path.Join(root, "missing")), 8)
+	if err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("HashFiles error must name the missing file: %v", err)
+	}
+}
+
+func BenchmarkWalkSerial(b *testing.B) {
+	root := b.TempDir()
+	makeTree(b, root, benchFiles)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		files, _ := walkSerial(root)
+		slices.Sort(files) // Same output as WalkDir
+	}
+}
+
+func BenchmarkWalkDir(b *testing.B) {
+	root := b.TempDir()
+	makeTree(b, root, benchFiles)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		WalkDir(root, 0, nil)
+	}
+}
+
+func BenchmarkHashSerial(b *testing.B) {
+	root := b.TempDir()
+	makeTree(b, root, benchFiles)
+	files, _ := WalkDir(root, 0, nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, file := range files {
+			HashFile(file)
+		}
+	}
+}
+
+func BenchmarkHashFiles(b *testing.B) {
+	root := b.TempDir()
+	makeTree(b, root, benchFiles)
+	files, _ := WalkDir(root, 0, nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		HashFiles(files, 0)
+	}
+}
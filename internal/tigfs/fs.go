@@ -3,6 +3,7 @@ package tigfs
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"slices"
@@ -11,7 +12,17 @@ import (
 )
 
 /*
-How to store file snapshot history:
+How to store file snapshot history (binary, see tigfile.BinaryWriter):
+- Magic "TIGF", version
+- Number of files (uint32), then the files sorted by path:
+	- path of the file in the client project (string)
+	- number of snapshots (uint32), then the snapshots in chronological order (first = oldest):
+	  hash (string), path relative to .tig/blobs (string)
+- Since version 2, number of moves (uint32), then the moves sorted by old path:
+  old path of the file (string), path it was moved to (string)
+- sha1 of the whole file
+
+Legacy text format, migrated to the binary format when loaded:
 - Line oriented, order matters
 - File snapshots in chronological order (first = oldest, last = latest)
 	- Line starts with "#xxx" = start the "xxx" file declaration
@@ -34,6 +45,11 @@ const SEPARATOR string = ";"
 const tigFSIndexFileName string = "_index" // File (_file so it's first in filetree list)
 const tigFSPath string = "fs"              // Directory
 
+const (
+	FS_INDEX_MAGIC   = "TIGF"
+	FS_INDEX_VERSION = 2
+)
+
 type TigFileSnapshot struct {
 	Hash     string   // Content hash of the file at snapshot
 	Path     string   // Path of the snapshot in Tig (based on hash)
@@ -54,6 +70,9 @@ type TigFS struct {
 	Files     TigFileMap
 	IndexPath string
 	DirPath   string
+	LFS       *TigLFS           // Store of the contents of the pointer files
+	moves     map[string]string // Old path -> new path of the moved files, see [TigFS.Move]
+	dirty     bool              // Files changed since the index file was written
 }
 
 // New initialise a new/existing FS in directory rootDir.
@@ -63,8 +82,10 @@ func New(rootDir string) (*TigFS, error) {
 	cleanFSPath := path.Join(cleanRootDir, tigFSPath)
 	fs := &TigFS{
 		Files:     make(TigFileMap, 32),
+		moves:     make(map[string]string, 8),
 		IndexPath: path.Join(cleanFSPath, tigFSIndexFileName),
 		DirPath:   cleanFSPath,
+		LFS:       NewLFS(cleanRootDir),
 	}
 	if err := os.Mkdir(fs.DirPath, tigfile.FILE_PERM); err != nil {
 		if !os.IsExist(err) {
@@ -84,10 +105,55 @@ func New(rootDir string) (*TigFS, error) {
 // Load read the index file to popuplate the FS.
 // Load is idempotent.
 func (fs *TigFS) Load() error {
-	lines, err := tigfile.ReadFileLines(fs.IndexPath, tigfile.MAX_FILE_SIZE)
+	data, err := tigfile.ReadFileBytes(fs.IndexPath, -1)
 	if err != nil {
 		return err
 	}
+	if len(data) == 0 {
+		return nil
+	}
+	if tigfile.HasMagic(data, FS_INDEX_MAGIC) {
+		return fs.loadBinary(data)
+	}
+	if err := fs.loadLegacy(strings.Split(string(data), "\n")); err != nil {
+		return err
+	}
+	return fs.write() // Migrate to the binary format
+}
+
+// loadBinary populate the FS from a binary index file
+func (fs *TigFS) loadBinary(data []byte) error {
+	r, version, err := tigfile.NewBinaryReader(data, FS_INDEX_MAGIC)
+	if err != nil {
+		return fmt.Errorf("FS index: %w", err)
+	}
+	if version < 1 || version > FS_INDEX_VERSION {
+		return fmt.Errorf("Unsupported FS index version %d", version)
+	}
+	count := r.ReadUint32()
+	for i := uint32(0); i < count && r.Err() == nil; i++ {
+		tigFile := &TigFile{Path: r.ReadString(), FS: fs, Head: nil}
+		snapshots := r.ReadUint32()
+		for j := uint32(0); j < snapshots && r.Err() == nil; j++ {
+			tigFile.pushSnapshot(r.ReadString(), r.ReadString())
+		}
+		fs.Files[tigFile.Path] = tigFile
+	}
+	if version >= 2 {
+		moves := r.ReadUint32()
+		for i := uint32(0); i < moves && r.Err() == nil; i++ {
+			oldPath := r.ReadString()
+			fs.moves[oldPath] = r.ReadString()
+		}
+	}
+	if err := r.Done(); err != nil {
+		return fmt.Errorf("FS index: %w", err)
+	}
+	return nil
+}
+
+// loadLegacy populate the FS from the lines of a legacy text index file
+func (fs *TigFS) loadLegacy(lines []string) error {
 	var currentFile string
 	for _, line := range lines {
 		if len(line) == 0 {
@@ -111,38 +177,82 @@ func (fs *TigFS) Load() error {
 			if !ok {
 				return errors.New("File snapshot list must be preceeded by file declaration")
 			}
-			tigFile.Head = &TigFileSnapshot{
-				Hash: data[0], Path: data[1], File: tigFile, Previous: tigFile.Head,
-			}
-			if tigFile.Head.Previous != nil {
-				tigFile.Head.Previous.Next = tigFile.Head
-			}
+			tigFile.pushSnapshot(data[0], data[1])
 		}
 	}
 	return nil
 }
 
-// Save write the FS to the index file.
-// Any Add/Delete action on File or Snapshot must end with a TigFile.Save() call
-func (fs *TigFS) save() error {
-	var fileLines []string
+// pushSnapshot append a loaded snapshot to the file history
+func (file *TigFile) pushSnapshot(hash string, snapshotPath string) {
+	file.Head = &TigFileSnapshot{Hash: hash, Path: snapshotPath, File: file, Previous: file.Head}
+	if file.Head.Previous != nil {
+		file.Head.Previous.Next = file.Head
+	}
+}
+
+// importSnapshot add a snapshot imported from another repository as the oldest one of the file
+// history, the head stays the last snapshot made in this repository
+func (file *TigFile) importSnapshot(hash string, snapshotPath string) {
+	if file.Head == nil {
+		file.pushSnapshot(hash, snapshotPath)
+		return
+	}
+	first := file.Head
+	for first.Previous != nil {
+		first = first.Previous
+	}
+	first.Previous = &TigFileSnapshot{Hash: hash, Path: snapshotPath, File: file, Next: first}
+}
+
+// Save write the FS to the index file if it changed. Add/Delete/Move actions only change the FS in memory,
+// Save must be called once they are done, and before saving anything that references their snapshots.
+func (fs *TigFS) Save() error {
+	if !fs.dirty {
+		return nil
+	}
+	return fs.write()
+}
+
+// write write the whole FS to the index file, atomically
+func (fs *TigFS) write() error {
+	var files []*TigFile
 	for _, v := range fs.Files {
-		if v.Head == nil {
-			continue // No snapshot, dont save it
+		if v.Head != nil { // No snapshot, dont save it
+			files = append(files, v)
 		}
-		fileLines = append(fileLines, fmt.Sprintf("#%s", v.Path))
-		ptr := v.Head
-		var snapLines []string
-		for ; ptr != nil; ptr = ptr.Previous {
-			snapLines = append(snapLines, fmt.Sprintf("%s;%s", ptr.Hash, ptr.Path))
+	}
+	slices.SortFunc(files, func(a, b *TigFile) int { return strings.Compare(a.Path, b.Path) })
+
+	w := tigfile.NewBinaryWriter(FS_INDEX_MAGIC, FS_INDEX_VERSION)
+	w.WriteUint32(uint32(len(files)))
+	for _, file := range files {
+		var snapshots []*TigFileSnapshot
+		for ptr := file.Head; ptr != nil; ptr = ptr.Previous {
+			snapshots = append(snapshots, ptr)
+		}
+		slices.Reverse(snapshots)
+		w.WriteString(file.Path)
+		w.WriteUint32(uint32(len(snapshots)))
+		for _, snapshot := range snapshots {
+			w.WriteString(snapshot.Hash)
+			w.WriteString(snapshot.Path)
 		}
-		slices.Reverse(snapLines)
-		fileLines = append(fileLines, snapLines...)
 	}
-	err := tigfile.WriteFileLines(fs.IndexPath, fileLines)
-	if err != nil {
+	oldPaths := make([]string, 0, len(fs.moves))
+	for oldPath := range fs.moves {
+		oldPaths = append(oldPaths, oldPath)
+	}
+	slices.Sort(oldPaths)
+	w.WriteUint32(uint32(len(oldPaths)))
+	for _, oldPath := range oldPaths {
+		w.WriteString(oldPath)
+		w.WriteString(fs.moves[oldPath])
+	}
+	if err := w.WriteFile(fs.IndexPath); err != nil {
 		return err
 	}
+	fs.dirty = false
 	return nil
 }
 
@@ -152,21 +262,66 @@ func (fs *TigFS) Get(filepath string) (*TigFile, bool) {
 	return file, ok
 }
 
-// FileIsModified check if the file filepath has been modified/added since the last snapshot
-func (fs *TigFS) HasChanged(filepath string) (bool, error) {
-	file, ok := fs.Get(filepath)
-	if !ok {
+// Lookup return the snapshot hash of the file filepath, nil if it does not exist. The moves of the file
+// are followed: the snapshots made under a path before a move are found under its new path.
+func (fs *TigFS) Lookup(filepath string, hash string) *TigFileSnapshot {
+	filepath = path.Clean(filepath)
+	for i := 0; i <= len(fs.moves); i++ {
+		if file, ok := fs.Files[filepath]; ok {
+			if snapshot := file.Search(hash); snapshot != nil {
+				return snapshot
+			}
+		}
+		newPath, ok := fs.moves[filepath]
+		if !ok {
+			break
+		}
+		filepath = newPath
+	}
+	return nil
+}
+
+// HasChanged check if the file filepath differs from snapshot, a pointer file is compared to the content it names.
+// A nil snapshot means the file is unknown, so it has changed.
+func (fs *TigFS) HasChanged(filepath string, snapshot *TigFileSnapshot) (bool, error) {
+	if snapshot == nil {
 		return true, nil
 	}
-	newHash, err := tigfile.HashFile(filepath)
+	content, err := Pointer(filepath)
 	if err != nil {
 		return false, err
 	}
-	return newHash != file.Head.Hash, nil
+	same, err := snapshot.SameContent(content.Hash, content.Size)
+	return !same, err
+}
+
+// HashFile return the hash of the snapshot the working file filepath would get:
+// the hash of its pointer file if it is tracked by the LFS, of its content otherwise.
+// Use [TigFS.HasChanged] to compare a working file, it does not depend on the tracked patterns.
+func (fs *TigFS) HashFile(filepath string) (string, error) {
+	if !fs.LFS.Tracks(filepath) {
+		return tigfile.HashFile(filepath)
+	}
+	pointer, err := Pointer(filepath)
+	if err != nil {
+		return "", err
+	}
+	return tigfile.HashBytes(pointer.Bytes()), nil
 }
 
 // Add add a file to the FS. It also create a snapshot of the file in the FS objects directory
 func (fs *TigFS) Add(filepath string) (*TigFile, error) {
+	return fs.addFile(filepath, (*TigFile).Add)
+}
+
+// AddBytes add a file to the FS, with a first snapshot of content data
+func (fs *TigFS) AddBytes(filepath string, data []byte) (*TigFile, error) {
+	return fs.addFile(filepath, func(file *TigFile) (*TigFileSnapshot, error) {
+		return file.AddBytes(data)
+	})
+}
+
+func (fs *TigFS) addFile(filepath string, addSnapshot func(*TigFile) (*TigFileSnapshot, error)) (*TigFile, error) {
 	cleanPath := path.Clean(filepath)
 	var newTigFile *TigFile
 	if _, ok := fs.Files[cleanPath]; ok {
@@ -174,63 +329,217 @@ func (fs *TigFS) Add(filepath string) (*TigFile, error) {
 		return nil, errors.New("FS.ADD(): File " + cleanPath + " already exists in FS")
 	} else {
 		newTigFile = &TigFile{FS: fs, Path: cleanPath, Head: nil}
-		_, err := newTigFile.Add()
+		_, err := addSnapshot(newTigFile)
 		if err != nil {
 			return nil, fmt.Errorf("Add adding snapshot: %w", err)
 		}
 		fs.Files[cleanPath] = newTigFile
 	}
-	return newTigFile, fs.save()
+	return newTigFile, nil
 }
 
-// Add add a snapshot to a [TigFile]
+// Add add a snapshot to a [TigFile]. The file is streamed to its blob, whatever its size.
+// A file tracked by the LFS is streamed to the LFS store, its blob is the pointer file.
 func (file *TigFile) Add() (*TigFileSnapshot, error) {
-	hash, err := tigfile.HashFile(file.Path)
+	if file.FS.LFS.Tracks(file.Path) {
+		pointer, err := file.FS.LFS.Store(file.Path)
+		if err != nil {
+			return nil, fmt.Errorf("Add: %w", err)
+		}
+		return file.AddBytes(pointer.Bytes())
+	}
+	hash, err := tigfile.StoreFileByHash(file.Path, file.FS.DirPath)
 	if err != nil {
-		return nil, fmt.Errorf("Add getting hash: %w", err)
+		return nil, fmt.Errorf("Add create copy: %w", err)
+	}
+	return file.addSnapshot(hash), nil
+}
+
+// AddBytes add a snapshot of content data to a [TigFile], the file on disk is not read
+func (file *TigFile) AddBytes(data []byte) (*TigFileSnapshot, error) {
+	hash := tigfile.HashBytes(data)
+	if err := tigfile.WriteFileAtomic(path.Join(file.FS.DirPath, hash), data); err != nil {
+		return nil, fmt.Errorf("Add create copy: %w", err)
 	}
+	return file.addSnapshot(hash), nil
+}
+
+// addSnapshot make the snapshot of the blob hash, already written, the head
+func (file *TigFile) addSnapshot(hash string) *TigFileSnapshot {
 	newFileSnap := &TigFileSnapshot{
 		Hash:     hash,
 		Path:     hash, // Path = hash for now
 		File:     file,
 		Previous: file.Head,
 	}
-	err = tigfile.CopyFile(file.Path, path.Join(file.FS.DirPath, hash))
-	if err != nil {
-		return nil, fmt.Errorf("Add create copy: %w", err)
-	}
 	// Last so GC can clean if any error
 	file.Head = newFileSnap
 	if newFileSnap.Previous != nil {
 		newFileSnap.Previous.Next = newFileSnap
 	}
-	return newFileSnap, file.FS.save()
+	file.FS.dirty = true
+	return newFileSnap
 }
 
-// Delete delete a snapshot of a [File]
+// Delete delete a snapshot of a [File] and its blob
 func (file *TigFile) Delete(hash string) error {
 	snapshot := file.Search(hash)
 	if snapshot == nil {
 		return errors.New("The snapshot does not exist for deletion")
 	}
-	if snapshot.Previous != nil {
-		snapshot.Previous.Next = snapshot.Next
-	} else {
-		if snapshot.Next != nil {
-			snapshot.Next.Previous = nil
-		}
+	file.FS.Forget(snapshot)
+	err := os.Remove(path.Join(file.FS.DirPath, snapshot.Path))
+	if err != nil {
+		return fmt.Errorf("Cannot delete snapshot: %w", err)
 	}
-	if snapshot.Next == nil {
+	return nil
+}
+
+// Forget remove snapshot from the history of its file, its blob is kept.
+// The file is dropped from the FS once it has no snapshot.
+func (fs *TigFS) Forget(snapshot *TigFileSnapshot) {
+	file := snapshot.File
+	if snapshot.Next != nil {
+		snapshot.Next.Previous = snapshot.Previous
+	} else {
 		file.Head = snapshot.Previous
 	}
+	if snapshot.Previous != nil {
+		snapshot.Previous.Next = snapshot.Next
+	}
 	snapshot.Next = nil
 	snapshot.Previous = nil
-	err := os.Remove(path.Join(file.FS.DirPath, snapshot.Path))
-	if err != nil {
-		return fmt.Errorf("Cannot delete snapshot: %w", err)
+	if file.Head == nil {
+		delete(fs.Files, file.Path)
+	}
+	fs.dirty = true
+}
+
+// Compact rewrite the index file, even if the FS did not change
+func (fs *TigFS) Compact() error {
+	return fs.write()
+}
+
+// Import copy the snapshots of src for which keep returns true (all if nil) and that fs does not have yet.
+// Blobs are hardlinked when link is true and both FS share a filesystem, copied otherwise.
+// Return the number of imported snapshots.
+func (fs *TigFS) Import(src *TigFS, keep func(*TigFileSnapshot) bool, link bool) (int, error) {
+	paths := make([]string, 0, len(src.Files))
+	for filepath := range src.Files {
+		paths = append(paths, filepath)
+	}
+	slices.Sort(paths)
+	imported := 0
+	for _, filepath := range paths {
+		srcFile := src.Files[filepath]
+		first := srcFile.Head
+		for first != nil && first.Previous != nil {
+			first = first.Previous
+		}
+		for snapshot := first; snapshot != nil; snapshot = snapshot.Next {
+			if keep != nil && !keep(snapshot) {
+				continue
+			}
+			file, ok := fs.Files[filepath]
+			if ok && file.Search(snapshot.Hash) != nil {
+				continue
+			}
+			if err := importBlob(snapshot.BlobPath(), path.Join(fs.DirPath, snapshot.Path), link); err != nil {
+				return imported, fmt.Errorf("Import %s: %w", filepath, err)
+			}
+			if !ok {
+				file = &TigFile{FS: fs, Path: filepath, Head: nil}
+				fs.Files[filepath] = file
+			}
+			file.importSnapshot(snapshot.Hash, snapshot.Path)
+			imported++
+		}
+	}
+	for oldPath, newPath := range src.moves {
+		if _, ok := fs.moves[oldPath]; !ok {
+			fs.moves[oldPath] = newPath
+			fs.dirty = true
+		}
+	}
+	if imported > 0 {
+		fs.dirty = true
+	}
+	return imported, nil
+}
+
+// ImportReader add the snapshot hash of the file filepath with its content streamed from r, received from
+// another FS. Nothing is done and r is not read if the file already has this snapshot, or if its blob
+// exists. Return true if it was imported.
+func (fs *TigFS) ImportReader(filepath string, hash string, snapshotPath string, r io.Reader) (bool, error) {
+	filepath = path.Clean(filepath)
+	file, ok := fs.Files[filepath]
+	if ok && file.Search(hash) != nil {
+		return false, nil
+	}
+	if snapshotPath != path.Base(snapshotPath) || strings.HasPrefix(snapshotPath, ".") {
+		return false, fmt.Errorf("Import %s: bad snapshot path %s", filepath, snapshotPath)
+	}
+	blobPath := path.Join(fs.DirPath, snapshotPath)
+	if _, err := os.Stat(blobPath); err != nil {
+		if err := tigfile.WriteFileAtomicHash(blobPath, r, hash); err != nil {
+			return false, fmt.Errorf("Import %s of snapshot %s: %w", filepath, hash, err)
+		}
+	}
+	if !ok {
+		file = &TigFile{FS: fs, Path: filepath, Head: nil}
+		fs.Files[filepath] = file
+	}
+	file.importSnapshot(hash, snapshotPath)
+	fs.dirty = true
+	return true, nil
+}
+
+// importBlob hardlink or copy the blob srcPath to destPath, an existing destPath is kept
+func importBlob(srcPath string, destPath string, link bool) error {
+	if _, err := os.Stat(destPath); err == nil {
+		return nil
+	}
+	if link && os.Link(srcPath, destPath) == nil {
+		return nil
+	}
+	return tigfile.CopyFileAtomic(srcPath, destPath)
+}
+
+// Move move the file oldPath to the key newPath, keeping its snapshots. The move is recorded so that
+// the snapshots stay found under oldPath, see [TigFS.Lookup].
+// If newPath already exists in the FS, the snapshots of oldPath are appended to its history.
+func (fs *TigFS) Move(oldPath string, newPath string) error {
+	oldPath, newPath = path.Clean(oldPath), path.Clean(newPath)
+	file, ok := fs.Files[oldPath]
+	if !ok {
+		return errors.New("FS.Move(): File " + oldPath + " does not exist in FS")
+	}
+	delete(fs.Files, oldPath)
+	fs.moves[oldPath] = newPath
+	delete(fs.moves, newPath) // Its snapshots are found under newPath again
+	fs.dirty = true
+	dest, ok := fs.Files[newPath]
+	if !ok {
+		file.Path = newPath
+		fs.Files[newPath] = file
+		return nil
+	}
+	// Find the first snapshot, then link the whole chain after the destination head
+	first := file.Head
+	for first != nil && first.Previous != nil {
+		first = first.Previous
 	}
-	// Dont delete file object if no snapshot remain, we delete in on save
-	return file.FS.save()
+	for ptr := first; ptr != nil; ptr = ptr.Next {
+		ptr.File = dest
+	}
+	if first != nil {
+		first.Previous = dest.Head
+		if dest.Head != nil {
+			dest.Head.Next = first
+		}
+		dest.Head = file.Head
+	}
+	return nil
 }
 
 // Search search for a specifi snapshot
@@ -242,3 +551,92 @@ func (file *TigFile) Search(hash string) *TigFileSnapshot {
 	}
 	return nil
 }
+
+// BlobPath return the path of the snapshot content in the FS objects directory
+func (snap *TigFileSnapshot) BlobPath() string {
+	return path.Join(snap.File.FS.DirPath, snap.Path)
+}
+
+// Read return the content of the snapshot, an error if it is bigger than [tigfile.MAX_FILE_SIZE]
+func (snap *TigFileSnapshot) Read() ([]byte, error) {
+	return tigfile.ReadFileBytes(snap.BlobPath(), tigfile.MAX_FILE_SIZE)
+}
+
+// ReadText return the content of the snapshot, or nil and true if it is binary or too big, see [tigfile.ReadFileText].
+// A pointer file is binary, its content is.
+func (snap *TigFileSnapshot) ReadText() ([]byte, bool, error) {
+	data, binary, err := tigfile.ReadFileText(snap.BlobPath())
+	if err != nil || binary {
+		return nil, binary, err
+	}
+	if _, ok := ParseLFSPointer(data); ok {
+		return nil, true, nil
+	}
+	return data, false, nil
+}
+
+// SameContent return true if the snapshot has the content of hash and size, directly or through its pointer file
+func (snap *TigFileSnapshot) SameContent(hash string, size int64) (bool, error) {
+	if snap.Hash == hash {
+		return true, nil
+	}
+	pointer, ok, err := snap.LFSPointer()
+	if err != nil || !ok {
+		return false, err
+	}
+	return pointer.Hash == hash && pointer.Size == size, nil
+}
+
+// LFSPointer return the pointer of the snapshot, false if it is not a pointer file
+func (snap *TigFileSnapshot) LFSPointer() (LFSPointer, bool, error) {
+	info, err := os.Stat(snap.BlobPath())
+	if err != nil {
+		return LFSPointer{}, false, err
+	}
+	if info.Size() > LFS_POINTER_MAX_SIZE {
+		return LFSPointer{}, false, nil
+	}
+	data, err := tigfile.ReadFileBytes(snap.BlobPath(), -1)
+	if err != nil {
+		return LFSPointer{}, false, err
+	}
+	pointer, ok := ParseLFSPointer(data)
+	return pointer, ok, nil
+}
+
+// WriteTo stream the content of the snapshot to w, whatever its size
+func (snap *TigFileSnapshot) WriteTo(w io.Writer) (int64, error) {
+	f, err := tigfile.Open(snap.BlobPath(), os.O_RDONLY)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return io.Copy(w, f)
+}
+
+// Restore write the content of the snapshot to filepath in the client project.
+// The content of a pointer file is restored from the LFS store.
+func (snap *TigFileSnapshot) Restore(filepath string) error {
+	if dir := path.Dir(filepath); dir != "." {
+		if err := os.MkdirAll(dir, tigfile.DIR_PERM); err != nil {
+			return fmt.Errorf("Restore: %w", err)
+		}
+	}
+	blobPath := snap.BlobPath()
+	pointer, ok, err := snap.LFSPointer()
+	if err != nil {
+		return fmt.Errorf("Restore: %w", err)
+	}
+	if ok {
+		object, err := snap.File.FS.LFS.Open(pointer.Hash)
+		if err != nil {
+			return fmt.Errorf("Restore %s: %w", filepath, err)
+		}
+		object.Close()
+		blobPath = snap.File.FS.LFS.ObjectPath(pointer.Hash)
+	}
+	if err := tigfile.CopyFile(blobPath, filepath); err != nil {
+		return fmt.Errorf("Restore: %w", err)
+	}
+	return nil
+}
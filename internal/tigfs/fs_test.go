@@ -2,6 +2,7 @@ package tigfs
 
 import (
 	"bytes"
+	"errors"
 	"path"
 	"strconv"
 	"testing"
@@ -114,6 +115,9 @@ func TestFSAdd(t *testing.T) {
 	if len(fs_to_test.Files) != 1 {
 		t.Fatalf("FS must be of size 1, not %d (1)", len(fs_to_test.Files))
 	}
+	if err := fs_to_test.Save(); err != nil {
+		t.Fatalf("Error Save: %s", err)
+	}
 
 	// Reset FS
 	for k := range fs_to_test.Files {
@@ -137,3 +141,112 @@ func TestFSAdd(t *testing.T) {
 	}
 
 }
+
+func TestFSImportReader(t *testing.T) {
+	root := t.TempDir()
+	fs, err := New(root)
+	if err != nil {
+		t.Fatalf("New(): %s", err)
+	}
+	filePath := path.Join(t.TempDir(), "hello.txt")
+	if err := tigfile.WriteFileString(filePath, "local"); err != nil {
+		t.Fatal(err)
+	}
+	file, err := fs.Add(filePath)
+	if err != nil {
+		t.Fatalf("Add(): %s", err)
+	}
+	local := file.Head
+
+	// An imported snapshot must not replace the local head
+	data := []byte("imported")
+	hash := tigfile.HashBytes(data)
+	if imported, err := fs.ImportReader(filePath, hash, hash, bytes.NewReader(data)); err != nil || !imported {
+		t.Fatalf("ImportReader() must import the snapshot: %v", err)
+	}
+	if head := fs.Files[filePath].Head; head.Hash != local.Hash || head.Previous == nil || head.Previous.Hash != hash {
+		t.Fatalf("ImportReader() must add the snapshot before the head %s, not %s", local.Hash, head.Hash)
+	}
+	other := []byte("other")
+	if _, err := fs.ImportReader("other.txt", tigfile.HashBytes(other), tigfile.HashBytes(other), bytes.NewReader(data)); !errors.Is(err, tigfile.ErrHashMismatch) {
+		t.Fatalf("ImportReader() of a content not matching its hash must fail with ErrHashMismatch, not %v", err)
+	}
+	if _, ok := fs.Files["other.txt"]; ok {
+		t.Fatalf("ImportReader() of a bad content must not add the file")
+	}
+	if err := fs.Save(); err != nil {
+		t.Fatalf("Save(): %s", err)
+	}
+	loaded, err := New(root)
+	if err != nil {
+		t.Fatalf("New(): %s", err)
+	}
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("Load(): %s", err)
+	}
+	if file := loaded.Files[filePath]; file == nil || file.Head.Hash != local.Hash || file.Search(hash) == nil {
+		t.Fatalf("Load() must read back the imported snapshot behind the head %s", local.Hash)
+	}
+}
+
+func TestFSMove(t *testing.T) {
+	root := t.TempDir()
+	fs, err := New(root)
+	if err != nil {
+		t.Fatalf("New(): %s", err)
+	}
+	file, err := fs.AddBytes("f.txt", []byte("one"))
+	if err != nil {
+		t.Fatalf("AddBytes(): %s", err)
+	}
+	if _, err := file.AddBytes([]byte("two")); err != nil {
+		t.Fatalf("AddBytes(): %s", err)
+	}
+	one := tigfile.HashBytes([]byte("one"))
+	if err := fs.Move("f.txt", "g.txt"); err != nil {
+		t.Fatalf("Move(): %s", err)
+	}
+	if err := fs.Move("missing.txt", "h.txt"); err == nil {
+		t.Fatalf("Move() of a missing file must fail")
+	}
+	if err := fs.Save(); err != nil {
+		t.Fatalf("Save(): %s", err)
+	}
+
+	// Reloaded, the file keeps its snapshots under its new path, found under the old one too
+	fs, err = New(root)
+	if err != nil {
+		t.Fatalf("New(): %s", err)
+	}
+	if err := fs.Load(); err != nil {
+		t.Fatalf("Load(): %s", err)
+	}
+	if _, ok := fs.Get("f.txt"); ok {
+		t.Fatalf("Move() must remove f.txt from the FS")
+	}
+	file, ok := fs.Get("g.txt")
+	if !ok || file.Head.Previous == nil || file.Head.Previous.Hash != one {
+		t.Fatalf("Move() must keep the snapshots of f.txt under g.txt: %v", file)
+	}
+	for _, filePath := range []string{"f.txt", "g.txt"} {
+		if snapshot := fs.Lookup(filePath, one); snapshot == nil || snapshot.File != file {
+			t.Fatalf("Lookup(%s) must find the snapshot %s of g.txt: %v", filePath, one, snapshot)
+		}
+	}
+
+	// Moved back, then to a new path
+	if err := fs.Move("g.txt", "f.txt"); err != nil {
+		t.Fatalf("Move(): %s", err)
+	}
+	if err := fs.Move("f.txt", "h.txt"); err != nil {
+		t.Fatalf("Move(): %s", err)
+	}
+	for _, filePath := range []string{"f.txt", "g.txt", "h.txt"} {
+		if snapshot := fs.Lookup(filePath, one); snapshot == nil || snapshot.File.Path != "h.txt" {
+			t.Fatalf("Lookup(%s) must find the snapshot %s of h.txt: %v", filePath, one, snapshot)
+		}
+	}
+	if snapshot := fs.Lookup("h.txt", tigfile.HashBytes([]byte("missing"))); snapshot != nil {
+		t.Fatalf("Lookup() of a missing snapshot must return nil, not %v", snapshot)
+	}
+}
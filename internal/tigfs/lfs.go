@@ -0,0 +1,200 @@
+package tigfs
+
+/*
+How to store a large file (LFS):
+- The files matching the patterns of the .tiglfs file of the project (.tigignore syntax) are
+  snapshotted as a small pointer file, the commits, the diffs and the packs only see the pointer
+- The content is stored in .tig/lfs/objects/<hash>, and in the cache directory if configured
+- The content replaces the pointer when the snapshot is restored in the working tree
+- A pointer is line oriented, order matters: the version, the sha1 of the content, its size in bytes
+
+###FILE START
+version tig-lfs/1
+oid sha1:3f786850e387550fdab836ed7e6dc881de23001b
+size 734003200
+###FILE END
+
+*/
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"tig/internal/tigfile"
+	"tig/internal/tigignore"
+)
+
+// TigLFSPath path of the LFS objects directory, relative to the tig directory
+const TigLFSPath = "lfs/objects"
+
+// TigLFSFileName path of the tracked patterns file, relative to the project root
+const TigLFSFileName = ".tiglfs"
+
+// First line of a pointer file
+const LFS_POINTER_VERSION = "version tig-lfs/1"
+
+// Maximum size of a pointer file, a bigger file is never a pointer
+const LFS_POINTER_MAX_SIZE = 256
+
+var ErrLFSMissing = errors.New("LFS object not found")
+
+// LFSPointer is the content of a pointer file
+type LFSPointer struct {
+	Hash string // sha1 of the content
+	Size int64
+}
+
+// Bytes return the pointer file
+func (pointer LFSPointer) Bytes() []byte {
+	return []byte(fmt.Sprintf("%s\noid sha1:%s\nsize %d\n", LFS_POINTER_VERSION, pointer.Hash, pointer.Size))
+}
+
+// ParseLFSPointer return the pointer of a pointer file, false if data is not a pointer file
+func ParseLFSPointer(data []byte) (LFSPointer, bool) {
+	if len(data) > LFS_POINTER_MAX_SIZE || !bytes.HasPrefix(data, []byte(LFS_POINTER_VERSION+"\n")) {
+		return LFSPointer{}, false
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 3 {
+		return LFSPointer{}, false
+	}
+	hash, ok := strings.CutPrefix(lines[1], "oid sha1:")
+	if !ok || !IsLFSHash(hash) {
+		return LFSPointer{}, false
+	}
+	sizeStr, ok := strings.CutPrefix(lines[2], "size ")
+	size, err := strconv.ParseInt(sizeStr, 10, 64)
+	if !ok || err != nil || size < 0 {
+		return LFSPointer{}, false
+	}
+	return LFSPointer{Hash: hash, Size: size}, true
+}
+
+// TigLFS is the store of the large files contents
+type TigLFS struct {
+	DirPath   string // Objects directory
+	CachePath string // Directory shared by several repositories, empty if none
+	track     *tigignore.TigIgnore
+}
+
+// NewLFS return the LFS store of the tig directory rootDir, no file is tracked
+func NewLFS(rootDir string) *TigLFS {
+	return &TigLFS{DirPath: path.Join(rootDir, TigLFSPath)}
+}
+
+// Track set the patterns of the tracked files, the .tigignore syntax is used
+func (lfs *TigLFS) Track(patterns []string) error {
+	track, err := tigignore.Parse(patterns)
+	if err != nil {
+		return fmt.Errorf("LFS track: %w", err)
+	}
+	lfs.track = track
+	return nil
+}
+
+// LoadTrack read the tracked patterns of the project, no file means no tracked file
+func (lfs *TigLFS) LoadTrack(projectPath string) error {
+	lines, err := tigfile.ReadFileLines(path.Join(projectPath, TigLFSFileName), tigfile.MAX_FILE_SIZE)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("LFS track: %w", err)
+	}
+	return lfs.Track(lines)
+}
+
+// Tracks return true if filepath is stored as a pointer file
+func (lfs *TigLFS) Tracks(filepath string) bool {
+	return lfs.track != nil && lfs.track.Match(filepath, false)
+}
+
+// ObjectPath return the path of the object hash in the store
+func (lfs *TigLFS) ObjectPath(hash string) string {
+	return path.Join(lfs.DirPath, hash)
+}
+
+// IsLFSHash return true if hash can name an object, it is a sha1 in hexadecimal
+func IsLFSHash(hash string) bool {
+	return len(hash) == 40 && strings.Trim(hash, "0123456789abcdef") == ""
+}
+
+// Store copy the content of filepath in the store and in the cache, return its pointer
+func (lfs *TigLFS) Store(filepath string) (LFSPointer, error) {
+	f, err := tigfile.Open(filepath, os.O_RDONLY)
+	if err != nil {
+		return LFSPointer{}, err
+	}
+	defer f.Close()
+	pointer, err := lfs.Import(f)
+	if err != nil {
+		return LFSPointer{}, err
+	}
+	if len(lfs.CachePath) > 0 {
+		if err := os.MkdirAll(lfs.CachePath, tigfile.DIR_PERM); err != nil {
+			return LFSPointer{}, fmt.Errorf("LFS cache: %w", err)
+		}
+		if _, err := tigfile.StoreFileByHash(lfs.ObjectPath(pointer.Hash), lfs.CachePath); err != nil {
+			return LFSPointer{}, fmt.Errorf("LFS cache: %w", err)
+		}
+	}
+	return pointer, nil
+}
+
+// Import store the content read from r, return its pointer
+func (lfs *TigLFS) Import(r io.Reader) (LFSPointer, error) {
+	if err := os.MkdirAll(lfs.DirPath, tigfile.DIR_PERM); err != nil {
+		return LFSPointer{}, err
+	}
+	hash, size, err := tigfile.StoreByHash(r, lfs.DirPath)
+	if err != nil {
+		return LFSPointer{}, err
+	}
+	return LFSPointer{Hash: hash, Size: size}, nil
+}
+
+// Open return the object hash for reading. An object missing from the store is copied from the cache.
+func (lfs *TigLFS) Open(hash string) (*os.File, error) {
+	f, err := tigfile.Open(lfs.ObjectPath(hash), os.O_RDONLY)
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return f, err
+	}
+	if len(lfs.CachePath) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrLFSMissing, hash)
+	}
+	cached, err := tigfile.Open(path.Join(lfs.CachePath, hash), os.O_RDONLY)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrLFSMissing, hash)
+	}
+	defer cached.Close()
+	pointer, err := lfs.Import(cached)
+	if err != nil {
+		return nil, err
+	}
+	if pointer.Hash != hash {
+		return nil, fmt.Errorf("Bad LFS object %s in the cache: content hash is %s", hash, pointer.Hash)
+	}
+	return tigfile.Open(lfs.ObjectPath(hash), os.O_RDONLY)
+}
+
+// Pointer return the pointer of the working file filepath, it is not stored
+func Pointer(filepath string) (LFSPointer, error) {
+	f, err := tigfile.Open(filepath, os.O_RDONLY)
+	if err != nil {
+		return LFSPointer{}, err
+	}
+	defer f.Close()
+	h := sha1.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return LFSPointer{}, err
+	}
+	return LFSPointer{Hash: hex.EncodeToString(h.Sum(nil)), Size: size}, nil
+}
@@ -0,0 +1,96 @@
+package tigfs
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"tig/internal/tigfile"
+)
+
+func TestParseLFSPointer(t *testing.T) {
+	pointer := LFSPointer{Hash: tigfile.HashBytes([]byte("big")), Size: 3}
+	parsed, ok := ParseLFSPointer(pointer.Bytes())
+	if !ok || parsed != pointer {
+		t.Fatalf("ParseLFSPointer(%q) must return %+v, not %+v %v", pointer.Bytes(), pointer, parsed, ok)
+	}
+	for _, data := range []string{
+		"",
+		"hello\n",
+		LFS_POINTER_VERSION + "\noid sha1:abc\nsize 3\n",
+		LFS_POINTER_VERSION + "\noid sha1:" + pointer.Hash + "\nsize -3\n",
+		LFS_POINTER_VERSION + "\noid sha1:" + pointer.Hash + "\nsize 3\nmore\n",
+		string(pointer.Bytes()) + strings.Repeat(" ", LFS_POINTER_MAX_SIZE),
+	} {
+		if _, ok := ParseLFSPointer([]byte(data)); ok {
+			t.Fatalf("ParseLFSPointer(%q) must not be a pointer", data)
+		}
+	}
+}
+
+func TestLFSAdd(t *testing.T) {
+	tmpDirPath := t.TempDir()
+	fs, err := New(tmpDirPath)
+	if err != nil {
+		t.Fatalf("Error New: %s", err)
+	}
+	if err := fs.LFS.Track([]string{"*.bin"}); err != nil {
+		t.Fatalf("Error Track: %s", err)
+	}
+	fs.LFS.CachePath = path.Join(tmpDirPath, "cache")
+	content := strings.Repeat("large content\n", 100)
+	fullFilePath := path.Join(tmpDirPath, "big.bin")
+	if err := tigfile.WriteFileString(fullFilePath, content); err != nil {
+		t.Fatalf("Error file WriteString: %s", err)
+	}
+	file, err := fs.Add(fullFilePath)
+	if err != nil {
+		t.Fatalf("Error Add: %s", err)
+	}
+
+	// The blob is the pointer, the content is in the store and in the cache
+	pointer, ok, err := file.Head.LFSPointer()
+	if err != nil || !ok {
+		t.Fatalf("The snapshot of a tracked file must be a pointer file (%v)", err)
+	}
+	if expected := (LFSPointer{Hash: tigfile.HashBytes([]byte(content)), Size: int64(len(content))}); pointer != expected {
+		t.Fatalf("The pointer must be %+v, not %+v", expected, pointer)
+	}
+	for _, objectPath := range []string{fs.LFS.ObjectPath(pointer.Hash), path.Join(fs.LFS.CachePath, pointer.Hash)} {
+		if data, err := tigfile.ReadFileBytes(objectPath, -1); err != nil || string(data) != content {
+			t.Fatalf("%s must contain the content of the file (%v)", objectPath, err)
+		}
+	}
+	if _, binary, err := file.Head.ReadText(); err != nil || !binary {
+		t.Fatalf("A pointer file must be read as binary (%v)", err)
+	}
+
+	// The working file is compared to the content named by the pointer
+	if changed, err := fs.HasChanged(fullFilePath, file.Head); err != nil || changed {
+		t.Fatalf("HasChanged() must be false for the stored content (%v)", err)
+	}
+	if err := tigfile.WriteFileString(fullFilePath, content+"more\n"); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := fs.HasChanged(fullFilePath, file.Head); err != nil || !changed {
+		t.Fatalf("HasChanged() must be true for a new content (%v)", err)
+	}
+
+	// A missing object is restored from the cache, then from nowhere
+	if err := os.Remove(fs.LFS.ObjectPath(pointer.Hash)); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Head.Restore(fullFilePath); err != nil {
+		t.Fatalf("Error Restore: %s", err)
+	}
+	if data, err := tigfile.ReadFileBytes(fullFilePath, -1); err != nil || string(data) != content {
+		t.Fatalf("Restore() must write the content of the pointer (%v)", err)
+	}
+	fs.LFS.CachePath = ""
+	if err := os.Remove(fs.LFS.ObjectPath(pointer.Hash)); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Head.Restore(fullFilePath); err == nil {
+		t.Fatalf("Restore() of a missing object must fail")
+	}
+}
@@ -0,0 +1,149 @@
+package tighistory
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"tig/internal/tigconfig"
+	"tig/internal/tigdiff"
+	"tig/internal/tigfile"
+	"time"
+)
+
+// Id used in blame for lines not committed yet
+const NOT_COMMITTED_ID = "0000000000000000000000000000000000000000"
+
+// BlameLine is a line of a file with the commit which last changed it
+type BlameLine struct {
+	Commit   *TigCommit // nil if not committed yet
+	OrigLine int        // 1-based line number in the commit version
+	Text     string
+}
+
+// blameStep compute the attribution of newLines from the previous attribution
+func blameStep(prev []BlameLine, newLines []string, commit *TigCommit) []BlameLine {
+	prevLines := make([]string, len(prev))
+	for i, line := range prev {
+		prevLines[i] = line.Text
+	}
+	result := make([]BlameLine, len(newLines))
+	for _, edit := range tigdiff.Diff(prevLines, newLines) {
+		if edit.Op == tigdiff.EQUAL {
+			result[edit.NewLine] = prev[edit.OldLine]
+		} else if edit.Op == tigdiff.INSERT {
+			result[edit.NewLine] = BlameLine{Commit: commit, OrigLine: edit.NewLine + 1, Text: edit.Text}
+		}
+	}
+	return result
+}
+
+// Blame attribute each line of filepath in the working tree to the commit which last changed it
+func (t *TigCommitTree) Blame(filepath string) ([]BlameLine, error) {
+	data, binary, err := tigfile.ReadFileText(filepath)
+	if err != nil {
+		return nil, err
+	}
+	if binary {
+		return nil, errors.New("Cannot blame a binary file")
+	}
+	// Path of the file after each commit, following renames
+	ancestors := t.Head.Ancestors()
+	paths := make([]string, len(ancestors))
+	currentPath := filepath
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		paths[i] = currentPath
+		if ancestors[i].Value == nil {
+			continue
+		}
+		if change := ancestors[i].Value.GetChange(currentPath); change != nil && change.Action == RENAME {
+			currentPath = change.OldPath
+		}
+	}
+
+	var lines []BlameLine
+	for i, node := range ancestors {
+		if node.Value == nil {
+			continue
+		}
+		for _, change := range node.Value.Changes {
+			if change.Path != paths[i] {
+				continue
+			}
+			if change.Action == DELETE {
+				lines = nil
+				continue
+			}
+			data, binary, err := change.FileSnapshot.ReadText()
+			if err != nil {
+				return nil, err
+			}
+			if binary {
+				// Nothing to match, the next text version is blamed from scratch
+				lines = nil
+				continue
+			}
+			lines = blameStep(lines, tigdiff.Lines(data), node.Value)
+		}
+	}
+	return blameStep(lines, tigdiff.Lines(data), nil), nil
+}
+
+// RunBlame write the blame of filepath to w, from line start to end (1-based, included, 0 = no limit).
+// porcelain write a machine readable format.
+func RunBlame(ctx tigconfig.TigCtx, tree *TigCommitTree, filepath string, start, end int, porcelain bool, w io.Writer) error {
+	filepath = path.Clean(filepath)
+	lines, err := tree.Blame(filepath)
+	if err != nil {
+		return fmt.Errorf("Blame: %w", err)
+	}
+	if start < 1 {
+		start = 1
+	}
+	if end <= 0 || end > len(lines) {
+		end = len(lines)
+	}
+	if start > len(lines) && len(lines) > 0 {
+		return fmt.Errorf("Blame: file %s has only %d lines", filepath, len(lines))
+	}
+	seen := make(map[string]bool, 16)
+	for i := start - 1; i < end; i++ {
+		line := lines[i]
+		id, author, date, summary := NOT_COMMITTED_ID, "Not Committed Yet", time.Now().Unix(), ""
+		if line.Commit != nil {
+			id, author, date = line.Commit.Id, line.Commit.AuthorName(), line.Commit.Date
+			summary, _, _ = strings.Cut(line.Commit.Message(), "\n")
+		}
+		if porcelain {
+			fmt.Fprintf(w, "%s %d %d\n", id, line.OrigLine, i+1)
+			if !seen[id] {
+				seen[id] = true
+				fmt.Fprintf(w, "author %s\nauthor-time %d\nsummary %s\nfilename %s\n", author, date, summary, filepath)
+			}
+			fmt.Fprintf(w, "\t%s\n", line.Text)
+		} else {
+			fmt.Fprintf(w, "%s (%s %s %4d) %s\n", id[:8], author,
+				time.Unix(date, 0).Format("2006-01-02 15:04:05"), i+1, line.Text)
+		}
+	}
+	return nil
+}
+
+// ParseLineRange parse a "start,end" blame range, end can be empty
+func ParseLineRange(lineRange string) (int, int, error) {
+	var start, end int
+	startStr, endStr, _ := strings.Cut(lineRange, ",")
+	if _, err := fmt.Sscanf(startStr, "%d", &start); err != nil {
+		return 0, 0, fmt.Errorf("Bad line range %s: %w", lineRange, err)
+	}
+	if len(endStr) > 0 {
+		if _, err := fmt.Sscanf(endStr, "%d", &end); err != nil {
+			return 0, 0, fmt.Errorf("Bad line range %s: %w", lineRange, err)
+		}
+		if end < start {
+			return 0, 0, fmt.Errorf("Bad line range %s: end before start", lineRange)
+		}
+	}
+	return start, end, nil
+}
@@ -0,0 +1,90 @@
+package tighistory
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"tig/internal/tigfile"
+)
+
+func TestBlame(t *testing.T) {
+	ctx, tree := newTestRepo(t)
+	one := commitFiles(t, ctx, tree, "one", map[string]string{"a.txt": "1\n2\n3\n"})
+	two := commitFiles(t, ctx, tree, "two", map[string]string{"a.txt": "1\nb\n3\n"})
+	three := commitFiles(t, ctx, tree, "three", map[string]string{"c.txt": "1\nb\n3\n4\n"}, "a.txt")
+	if change := three.GetChange("c.txt"); change == nil || change.Action != RENAME {
+		t.Fatalf("c.txt must be a rename of a.txt: %+v", three.Changes)
+	}
+	if err := tigfile.WriteFileString("c.txt", "1\nb\n3\n4\n5\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Lines are followed across the rename, the last one is not committed
+	lines, err := tree.Blame("c.txt")
+	if err != nil {
+		t.Fatalf("Blame(): %s", err)
+	}
+	expected := []struct {
+		commit   *TigCommit
+		origLine int
+	}{{one, 1}, {two, 2}, {one, 3}, {three, 4}, {nil, 5}}
+	if len(lines) != len(expected) {
+		t.Fatalf("Blame() must return %d lines, not %d", len(expected), len(lines))
+	}
+	for i, line := range lines {
+		if line.Commit != expected[i].commit || line.OrigLine != expected[i].origLine {
+			t.Fatalf("Line %d %q must come from line %d of %v, not line %d of %v", i+1, line.Text,
+				expected[i].origLine, expected[i].commit, line.OrigLine, line.Commit)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := RunBlame(ctx, tree, "c.txt", 2, 3, false, &out); err != nil {
+		t.Fatalf("RunBlame(-L 2,3): %s", err)
+	}
+	outLines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(outLines) != 2 || !strings.HasPrefix(outLines[0], two.Id[:8]+" (") || !strings.HasSuffix(outLines[0], "   2) b") ||
+		!strings.HasPrefix(outLines[1], one.Id[:8]+" (") || !strings.HasSuffix(outLines[1], "   3) 3") {
+		t.Fatalf("RunBlame(-L 2,3) must write lines 2 and 3:\n%s", out.String())
+	}
+
+	// The header of a commit is written once, with its first line
+	out.Reset()
+	if err := RunBlame(ctx, tree, "c.txt", 0, 0, true, &out); err != nil {
+		t.Fatalf("RunBlame(--porcelain): %s", err)
+	}
+	header := func(commit *TigCommit) string {
+		return fmt.Sprintf("author %s\nauthor-time %d\nsummary %s\nfilename c.txt\n",
+			commit.AuthorName(), commit.Date, commit.Message())
+	}
+	porcelain := one.Id + " 1 1\n" + header(one) + "\t1\n" +
+		two.Id + " 2 2\n" + header(two) + "\tb\n" +
+		one.Id + " 3 3\n\t3\n" +
+		three.Id + " 4 4\n" + header(three) + "\t4\n" +
+		NOT_COMMITTED_ID + " 5 5\n"
+	if !strings.HasPrefix(out.String(), porcelain) || !strings.HasSuffix(out.String(), "summary \nfilename c.txt\n\t5\n") {
+		t.Fatalf("RunBlame(--porcelain) must write:\n%s\nnot:\n%s", porcelain, out.String())
+	}
+
+	if err := RunBlame(ctx, tree, "c.txt", 6, 0, false, &out); err == nil {
+		t.Fatalf("RunBlame() must fail for a range after the last line")
+	}
+	if err := RunBlame(ctx, tree, "missing.txt", 0, 0, false, &out); err == nil {
+		t.Fatalf("RunBlame() must fail for a missing file")
+	}
+}
+
+func TestParseLineRange(t *testing.T) {
+	for lineRange, expected := range map[string][2]int{"2,5": {2, 5}, "3": {3, 0}, "4,": {4, 0}} {
+		start, end, err := ParseLineRange(lineRange)
+		if err != nil || start != expected[0] || end != expected[1] {
+			t.Fatalf("ParseLineRange(%s) must return %v, not %d %d (%v)", lineRange, expected, start, end, err)
+		}
+	}
+	for _, lineRange := range []string{"", "a,2", "5,2", "1,b"} {
+		if _, _, err := ParseLineRange(lineRange); err == nil {
+			t.Fatalf("ParseLineRange(%s) must fail", lineRange)
+		}
+	}
+}
@@ -1,30 +1,18 @@
 package tighistory
 
-/*
-How to store the current commit (not the final one):
-- Line oriented, order does not matter
-- List of file staged : action;filepath;hash
-
-###FILE END
-
-*/
-
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
 	"path"
-	"strconv"
 	"strings"
 	"tig/internal/tigconfig"
 	"tig/internal/tigfile"
 	"tig/internal/tigfs"
+	"tig/internal/tigindexfile"
 	"time"
 )
 
-// TigCommitFileName Path relative to TigRootPath
-const TigCommitFileName = "commit"
-
 // TigConfigFileName Path relative to TigRootPath
 const TigTreeFileName = "tree"
 
@@ -35,25 +23,82 @@ const (
 	ADD    ChangeAction = 1
 	MODIFY              = 2
 	DELETE              = 3
+	RENAME              = 4
 )
 
 type TigChange struct {
 	Action       ChangeAction           `json:"action"`
+	Path         string                 `json:"path"`          // path of the file in the client project at this change
 	FileSnapshot *tigfs.TigFileSnapshot `json:"file_snapshot"` // contains last snapshot if DELETE
+	OldPath      string                 `json:"old_path"`      // path before the change if RENAME
+}
+
+// tigChangeJSON is the stored form of a [TigChange], snapshots are referenced by path and hash
+type tigChangeJSON struct {
+	Action  ChangeAction `json:"action"`
+	Path    string       `json:"path"`
+	Hash    string       `json:"hash"`
+	OldPath string       `json:"old_path,omitempty"`
+}
+
+func (change TigChange) MarshalJSON() ([]byte, error) {
+	return json.Marshal(tigChangeJSON{
+		Action:  change.Action,
+		Path:    change.Path,
+		Hash:    change.FileSnapshot.Hash,
+		OldPath: change.OldPath,
+	})
+}
+
+// UnmarshalJSON create a detached snapshot, it must be linked to the FS with [TigChange.Resolve]
+func (change *TigChange) UnmarshalJSON(b []byte) error {
+	var data tigChangeJSON
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+	change.Action = data.Action
+	change.Path = data.Path
+	change.OldPath = data.OldPath
+	change.FileSnapshot = &tigfs.TigFileSnapshot{
+		Hash: data.Hash,
+		Path: data.Hash,
+		File: &tigfs.TigFile{Path: data.Path},
+	}
+	return nil
+}
+
+// Resolve replace a detached snapshot by the one stored in the FS under the path of the change,
+// or under its old path for a rename: a file received renamed without change keeps the snapshot of
+// its old path. The files moved since are followed, see [tigfs.TigFS.Lookup].
+func (change *TigChange) Resolve(fs *tigfs.TigFS) error {
+	paths := []string{change.Path}
+	if change.Action == RENAME {
+		paths = append(paths, change.OldPath)
+	}
+	for _, filePath := range paths {
+		if snapshot := fs.Lookup(filePath, change.FileSnapshot.Hash); snapshot != nil {
+			change.FileSnapshot = snapshot
+			return nil
+		}
+	}
+	return fmt.Errorf("Bad snapshot declared for file %s", change.Path)
 }
 
 type TigCommit struct {
 	Author   string      `json:"author"`
 	Msg      string      `json:"msg"`
 	Date     int64       `json:"date"`
+	Written  int64       `json:"written,omitempty"` // Unix time the commit was added to this repository, not in its id
 	Id       string      `json:"id"`
 	ParentId string      `json:"parent_id"` // '-' on first commit, no parent
 	Changes  []TigChange `json:"changes"`   // contains always at least 1 Change
 }
 
 type TigCommitTree struct {
-	Head *NTree[*TigCommit]
-	Tree NTree[*TigCommit]
+	Head   *NTree[*TigCommit]
+	Tree   NTree[*TigCommit]
+	Branch string            // Checked out branch, empty if HEAD is detached
+	Refs   map[string]string // Ref name -> commit id
 }
 
 func ChangeActionToStr(action ChangeAction) string {
@@ -63,75 +108,86 @@ func ChangeActionToStr(action ChangeAction) string {
 		return "modified"
 	} else if action == DELETE {
 		return "deleted"
+	} else if action == RENAME {
+		return "renamed"
 	} else {
 		return ""
 	}
 }
 
-// GetCurrentCommit read the commit file, or create it if it does not exists
+// GetCurrentCommit read the staged changes of the index
 func GetCurrentCommit(ctx tigconfig.TigCtx) (*TigCommit, error) {
-	fd, err := tigfile.Create(path.Join(ctx.TigPath, TigCommitFileName), os.O_RDONLY)
-	if err != nil {
-		return nil, err
-	}
-	defer fd.Close()
-
-	fileLines, err := tigfile.ReadFdLines(fd, tigfile.MAX_FILE_SIZE)
+	index, err := tigindexfile.Load(ctx.TigPath)
 	if err != nil {
 		return nil, err
 	}
 
 	commit := TigCommit{}
-	for _, line := range fileLines {
-		lineSplit := strings.Split(line, ";")
-		if len(lineSplit) != 3 {
-			return nil, errors.New("Commit file bad format, must contains 3 elem on each line")
-		}
-		fileAction, filePath, fileHash := lineSplit[0], lineSplit[1], lineSplit[2]
-		action, err := strconv.Atoi(fileAction)
-		if err != nil {
-			return nil, fmt.Errorf("Commit file bad format: %w", err)
+	for _, entry := range index.Entries {
+		if !entry.Has(tigindexfile.FLAG_STAGED) {
+			continue
 		}
-		file, ok := ctx.FS.Get(filePath)
-		if !ok {
-			return nil, fmt.Errorf("File %s in current commit does not exist in FS", filePath)
+		change := TigChange{
+			Action:       ChangeAction(entry.Action),
+			Path:         entry.Path,
+			FileSnapshot: &tigfs.TigFileSnapshot{Hash: entry.StagedHash},
+			OldPath:      entry.OldPath,
 		}
-		snapshot := file.Search(fileHash)
-		if snapshot == nil {
-			return nil, fmt.Errorf("Bad snapshot declared for file %s", filePath)
+		if err := change.Resolve(ctx.FS); err != nil {
+			return nil, fmt.Errorf("Current commit: %w", err)
 		}
-		commit.Changes = append(commit.Changes,
-			TigChange{Action: ChangeAction(action), FileSnapshot: snapshot})
+		commit.Changes = append(commit.Changes, change)
 	}
 
 	return &commit, nil
 }
 
 // Commit get the current commit and commit it
-func Commit(ctx tigconfig.TigCtx, msg string) error {
+func Commit(ctx tigconfig.TigCtx, tree *TigCommitTree, msg string) error {
 	commit, err := GetCurrentCommit(ctx)
 	if err != nil {
 		return err
 	}
-	return commit.Commit(ctx, msg)
+	if len(commit.Changes) == 0 {
+		return errors.New("Nothing to commit")
+	}
+	return commit.Commit(ctx, tree, msg)
 }
 
+// SaveFS save the FS, before any file referencing its snapshots: the staged changes, the commits
+// or the stashes. Saved in this order, an interrupted save never references a missing snapshot.
+func SaveFS(ctx tigconfig.TigCtx) error {
+	if err := ctx.FS.Save(); err != nil {
+		return fmt.Errorf("Cannot save FS: %w", err)
+	}
+	return nil
+}
+
+// Save write the changes to the index as the staged changes
 func (c *TigCommit) Save(ctx tigconfig.TigCtx) error {
-	var fileLines []string
-	for _, change := range c.Changes {
-		fileLines = append(fileLines, fmt.Sprintf("%d;%s;%s",
-			change.Action, change.FileSnapshot.File.Path, change.FileSnapshot.Hash))
+	if err := SaveFS(ctx); err != nil {
+		return err
 	}
-	err := tigfile.WriteFileLines(
-		path.Join(ctx.TigPath, TigCommitFileName), fileLines)
+	err := tigindexfile.Update(ctx.TigPath, func(index *tigindexfile.Index) error {
+		index.ClearFlags(tigindexfile.FLAG_STAGED)
+		for _, change := range c.Changes {
+			entry := index.Get(change.Path)
+			entry.Flags |= tigindexfile.FLAG_STAGED
+			entry.Action, entry.StagedHash = uint32(change.Action), change.FileSnapshot.Hash
+			if change.Action == RENAME {
+				entry.OldPath = change.OldPath
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return fmt.Errorf("Cannot save commit: %w", err)
 	}
 	return nil
 }
 
-func (c *TigCommit) Stage(ctx tigconfig.TigCtx, filepath string) error {
-	var action ChangeAction
+// Stage snapshot filepath and add it to the commit. state is the content of the project at HEAD.
+func (c *TigCommit) Stage(ctx tigconfig.TigCtx, state TigTreeState, filepath string) error {
 	var err error
 	var snapshot *tigfs.TigFileSnapshot
 
@@ -143,27 +199,79 @@ func (c *TigCommit) Stage(ctx tigconfig.TigCtx, filepath string) error {
 			return err
 		}
 		snapshot = file.Head
-		action = ADD
 	} else {
-		action = MODIFY
 		snapshot, err = file.Add()
 		if err != nil {
 			return err
 		}
 	}
-	// In case of Stage a file already Stage (but an older version)
-	// Replace it in current commit
-	if c.HasFile(filepathClean) {
-		c.Unstage(filepathClean)
+	c.stageSnapshot(state, filepathClean, snapshot)
+	return nil
+}
+
+// StageContent stage data as the content of filepath, the working file is left untouched.
+// state is the content of the project at HEAD.
+func (c *TigCommit) StageContent(ctx tigconfig.TigCtx, state TigTreeState, filepath string, data []byte) error {
+	var err error
+	var snapshot *tigfs.TigFileSnapshot
+
+	filepathClean := path.Clean(filepath)
+	file, ok := ctx.FS.Get(filepathClean)
+	if !ok {
+		file, err := ctx.FS.AddBytes(filepathClean, data)
+		if err != nil {
+			return err
+		}
+		snapshot = file.Head
+	} else {
+		snapshot, err = file.AddBytes(data)
+		if err != nil {
+			return err
+		}
 	}
-	c.Changes = append(c.Changes, TigChange{Action: action, FileSnapshot: snapshot})
+	c.stageSnapshot(state, filepathClean, snapshot)
 	return nil
 }
 
+// stageSnapshot add the change of filepath to snapshot, replacing its staged change if any
+func (c *TigCommit) stageSnapshot(state TigTreeState, filepath string, snapshot *tigfs.TigFileSnapshot) {
+	var action ChangeAction = MODIFY
+	if _, ok := state[filepath]; !ok {
+		action = ADD
+	}
+	// In case of Stage a file already Stage (but an older version)
+	// Replace it in current commit, a new or renamed file stays so
+	var oldPath string
+	if staged := c.GetChange(filepath); staged != nil {
+		if staged.Action == ADD || staged.Action == RENAME {
+			action = staged.Action
+		}
+		oldPath = staged.OldPath
+		c.Unstage(filepath)
+	}
+	c.Changes = append(c.Changes, TigChange{
+		Action: action, Path: filepath, FileSnapshot: snapshot, OldPath: oldPath})
+}
+
+// StageDelete stage the deletion of filepath. A file which is not in HEAD is just unstaged.
+// state is the content of the project at HEAD.
+func (c *TigCommit) StageDelete(state TigTreeState, filepath string) {
+	if staged := c.GetChange(filepath); staged != nil {
+		if staged.Action == RENAME {
+			// The file to delete is the one before the rename
+			filepath = staged.OldPath
+		}
+		c.Unstage(staged.Path)
+	}
+	if snapshot, ok := state[filepath]; ok {
+		c.Changes = append(c.Changes, TigChange{Action: DELETE, Path: filepath, FileSnapshot: snapshot})
+	}
+}
+
 func (c *TigCommit) Unstage(filepath string) error {
 	var i int = -1
 	for k, v := range c.Changes {
-		if v.FileSnapshot.File.Path == filepath {
+		if v.Path == filepath {
 			i = k
 			break
 		}
@@ -171,53 +279,110 @@ func (c *TigCommit) Unstage(filepath string) error {
 	if i == -1 {
 		return errors.New("Unknown file to unstage: " + filepath)
 	}
-	// Should we really delete it now?
-	c.Changes[i].FileSnapshot.File.Delete(c.Changes[i].FileSnapshot.Hash)
+	// The snapshot is kept, its blob may be shared by another snapshot: tig gc removes it once unreferenced
 	c.Changes[i] = c.Changes[len(c.Changes)-1]
 	c.Changes = c.Changes[:len(c.Changes)-1]
 	return nil
 }
 
 func (c *TigCommit) HasFile(filepath string) bool {
-	for _, v := range c.Changes {
-		if v.FileSnapshot.File.Path == filepath {
-			return true
+	return c.GetChange(filepath) != nil
+}
+
+// GetChange return the change of filepath in the commit, or nil
+func (c *TigCommit) GetChange(filepath string) *TigChange {
+	for i := range c.Changes {
+		if c.Changes[i].Path == filepath {
+			return &c.Changes[i]
 		}
 	}
-	return false
+	return nil
 }
 
+// Reset remove the staged changes from the index
 func (c *TigCommit) Reset(ctx tigconfig.TigCtx) error {
-	fd, err := tigfile.Open(path.Join(ctx.TigPath, TigCommitFileName), os.O_TRUNC)
+	err := tigindexfile.Update(ctx.TigPath, func(index *tigindexfile.Index) error {
+		index.ClearFlags(tigindexfile.FLAG_STAGED)
+		return nil
+	})
 	if err != nil {
-		return err
+		return fmt.Errorf("Reset commit: %w", err)
 	}
-	fd.Close()
 	return nil
 }
 
-func (c *TigCommit) Commit(ctx tigconfig.TigCtx, msg string) error {
-	// X 1- Remplir les infos du commit
-	// 2- Ecrire dans le fichiers des commits
-	// X 3- Reset le fichier de commit en cours
-	c.Author = tigfile.B64Str(ctx.AuthorName)
-	c.Date = time.Now().Unix()
-	c.Msg = tigfile.B64Str(msg)
-	c.ParentId = "-"
-	c.Id = tigfile.HashBytes(tigfile.StrToBytes(fmt.Sprintf("%s;%d", c.Author, c.Date)))
+func (c *TigCommit) Commit(ctx tigconfig.TigCtx, tree *TigCommitTree, msg string) error {
+	c.DetectRenames(tree.HeadState(), ctx.RenameThreshold())
+	c.SetMetadata(ctx, tree.HeadId(), msg)
 
-	err := c.Reset(ctx)
+	err := tree.Add(ctx, c)
+	if err != nil {
+		return fmt.Errorf("Commit: %w", err)
+	}
+	err = c.Reset(ctx)
 	if err != nil {
 		return fmt.Errorf("Commit: cannont reset commit : %w", err)
 	}
 	return nil
 }
 
+// SetMetadata fill the commit author, date, message, parent and id. Changes must be set before.
+func (c *TigCommit) SetMetadata(ctx tigconfig.TigCtx, parentId string, msg string) {
+	c.Author = tigfile.B64Str(ctx.AuthorName)
+	c.Date = time.Now().Unix()
+	c.Written = c.Date
+	c.Msg = tigfile.B64Str(msg)
+	c.ParentId = parentId
+	c.Id = c.hash()
+}
+
+// WrittenTime return the time the commit was added to this repository, its date for the commits
+// written before this time was recorded
+func (c *TigCommit) WrittenTime() time.Time {
+	if c.Written == 0 {
+		return time.Unix(c.Date, 0)
+	}
+	return time.Unix(c.Written, 0)
+}
+
+// hash compute the commit id from its metadata and changes
+func (c *TigCommit) hash() string {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%s;%d;%s;%s", c.Author, c.Date, c.ParentId, c.Msg))
+	for _, change := range c.Changes {
+		builder.WriteString(fmt.Sprintf(";%d;%s;%s;%s",
+			change.Action, change.Path, change.FileSnapshot.Hash, change.OldPath))
+	}
+	return tigfile.HashBytes(tigfile.StrToBytes(builder.String()))
+}
+
 func LoadCommits(ctx tigconfig.TigCtx) (*TigCommitTree, error) {
+	tree, err := LoadCommitsUnresolved(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = tree.resolve(ctx.FS)
+	if err != nil {
+		return nil, fmt.Errorf("LoadCommits: %w", err)
+	}
+	err = tree.loadHead(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("LoadCommits: %w", err)
+	}
+	return tree, nil
+}
+
+// LoadCommitsUnresolved load the commits and the refs, the changes keep detached snapshots and HEAD
+// is not loaded. For the commands checking a repository which [LoadCommits] may refuse.
+func LoadCommitsUnresolved(ctx tigconfig.TigCtx) (*TigCommitTree, error) {
 	tree := TigCommitTree{}
 	err := tree.Tree.Load(path.Join(ctx.TigPath, TigTreeFileName))
 	if err != nil {
 		return nil, fmt.Errorf("LoadCommits: %w", err)
 	}
+	err = tree.loadRefs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("LoadCommits: %w", err)
+	}
 	return &tree, nil
 }
@@ -0,0 +1,159 @@
+package tighistory
+
+/*
+How to store the HEAD:
+- A single line "ref: refs/heads/<branch>" with the checked out branch
+- Or the id of the checked out commit when HEAD is detached (no branch)
+- Missing file = default branch, a branch without ref has no commit yet, HEAD is the tree root
+
+###FILE START
+ref: refs/heads/main
+###FILE END
+
+*/
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfile"
+	"tig/internal/tigfs"
+)
+
+// TigHeadFileName Path relative to TigRootPath
+const TigHeadFileName = "HEAD"
+
+// Prefix of a HEAD pointing to a branch
+const HEAD_REF_PREFIX = "ref: "
+
+// TigTreeState is the content of the project at a commit: file path -> snapshot
+type TigTreeState = map[string]*tigfs.TigFileSnapshot
+
+// HeadId return the id of the HEAD commit, "-" if there is no commit yet
+func (t *TigCommitTree) HeadId() string {
+	if t.Head == nil || t.Head.Value == nil {
+		return "-"
+	}
+	return t.Head.Value.Id
+}
+
+// Get return the node of the commit id, or nil
+func (t *TigCommitTree) Get(id string) *NTree[*TigCommit] {
+	if id == "-" {
+		return &t.Tree
+	}
+	return t.Tree.Find(func(c *TigCommit) bool {
+		return c != nil && c.Id == id
+	})
+}
+
+// Add add commit as a child of HEAD, move HEAD to it and save the tree
+func (t *TigCommitTree) Add(ctx tigconfig.TigCtx, commit *TigCommit) error {
+	if t.Head == nil {
+		t.Head = &t.Tree
+	}
+	t.Head = t.Head.Add(commit)
+	return t.Save(ctx)
+}
+
+// Save write the tree and the HEAD files
+func (t *TigCommitTree) Save(ctx tigconfig.TigCtx) error {
+	if err := SaveFS(ctx); err != nil {
+		return err
+	}
+	err := t.Tree.Save(path.Join(ctx.TigPath, TigTreeFileName))
+	if err != nil {
+		return err
+	}
+	head := t.HeadId()
+	if len(t.Branch) > 0 {
+		if t.Head != nil && t.Head.Value != nil {
+			if err := t.SetRef(ctx, REF_HEADS+t.Branch, head); err != nil {
+				return fmt.Errorf("Cannot save branch: %w", err)
+			}
+		}
+		head = HEAD_REF_PREFIX + REF_HEADS + t.Branch
+	}
+	err = tigfile.WriteFileAtomicString(path.Join(ctx.TigPath, TigHeadFileName), head+"\n")
+	if err != nil {
+		return fmt.Errorf("Cannot save HEAD: %w", err)
+	}
+	return nil
+}
+
+// State return the content of the project at commit node
+func (t *TigCommitTree) State(node *NTree[*TigCommit]) TigTreeState {
+	state := make(TigTreeState, 32)
+	if node == nil {
+		return state
+	}
+	for _, ancestor := range node.Ancestors() {
+		if ancestor.Value == nil {
+			continue
+		}
+		for _, change := range ancestor.Value.Changes {
+			if change.Action == DELETE {
+				delete(state, change.Path)
+			} else {
+				if change.Action == RENAME {
+					delete(state, change.OldPath)
+				}
+				state[change.Path] = change.FileSnapshot
+			}
+		}
+	}
+	return state
+}
+
+// HeadState return the content of the project at HEAD
+func (t *TigCommitTree) HeadState() TigTreeState {
+	return t.State(t.Head)
+}
+
+func (t *TigCommitTree) loadHead(ctx tigconfig.TigCtx) error {
+	t.Head = &t.Tree
+	t.Branch = ""
+	b, err := tigfile.ReadFileBytes(path.Join(ctx.TigPath, TigHeadFileName), -1)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	head := strings.TrimSpace(string(b))
+	if strings.HasPrefix(head, HEAD_REF_PREFIX) {
+		t.Branch = strings.TrimPrefix(strings.TrimPrefix(head, HEAD_REF_PREFIX), REF_HEADS)
+		headId, ok := t.Refs[REF_HEADS+t.Branch]
+		if !ok {
+			return nil // No commit on the branch yet
+		}
+		head = headId
+	} else if _, ok := t.Refs[REF_HEADS+ctx.DefaultBranch()]; !ok {
+		// HEAD written before branches existed, it becomes the default branch
+		t.Branch = ctx.DefaultBranch()
+	}
+	if len(head) == 0 || head == "-" {
+		return nil
+	}
+	t.Head = t.Get(head)
+	if t.Head == nil {
+		return fmt.Errorf("HEAD commit %s does not exist", head)
+	}
+	return nil
+}
+
+// resolve link every change of the tree to the FS snapshots
+func (t *TigCommitTree) resolve(fs *tigfs.TigFS) error {
+	var err error
+	t.Tree.Walk(func(node *NTree[*TigCommit]) {
+		if err != nil || node.Value == nil {
+			return
+		}
+		for i := range node.Value.Changes {
+			if err = node.Value.Changes[i].Resolve(fs); err != nil {
+				return
+			}
+		}
+	})
+	return err
+}
@@ -0,0 +1,69 @@
+package tighistory
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"tig/internal/tigconfig"
+)
+
+// pathChange return the change of the commit to filepath, or the rename of filepath to another path
+func (c *TigCommit) pathChange(filepath string) *TigChange {
+	if change := c.GetChange(filepath); change != nil {
+		return change
+	}
+	for i := range c.Changes {
+		if c.Changes[i].Action == RENAME && c.Changes[i].OldPath == filepath {
+			return &c.Changes[i]
+		}
+	}
+	return nil
+}
+
+// Log write the commits from HEAD to the first one to w.
+// With a filepath, only the commits which changed it are written, and follow continues across renames.
+// patch write the diff introduced by each commit.
+func Log(ctx tigconfig.TigCtx, tree *TigCommitTree, filepath string, follow bool, patch bool, w io.Writer) error {
+	if len(filepath) > 0 {
+		filepath = path.Clean(filepath)
+		known := false
+		for node := tree.Head; node != nil && node.Value != nil && !known; node = node.Parent {
+			known = node.Value.pathChange(filepath) != nil
+		}
+		if !known {
+			return fmt.Errorf("Log: unknown path %s", filepath)
+		}
+	}
+	first := true
+	for node := tree.Head; node != nil && node.Value != nil; node = node.Parent {
+		commit := node.Value
+		var change *TigChange
+		if len(filepath) > 0 {
+			if change = commit.pathChange(filepath); change == nil {
+				continue
+			}
+		}
+		if !first {
+			fmt.Fprintln(w)
+		}
+		first = false
+		commit.WriteHeader(w)
+		if patch {
+			fmt.Fprintln(w)
+			var err error
+			if change == nil {
+				err = tree.WriteCommitDiff(ctx, w, node)
+			} else {
+				parentState := tree.State(node.Parent)
+				err = writeChange(w, *change, parentState, detectCopies(ctx, commit, parentState))
+			}
+			if err != nil {
+				return fmt.Errorf("Log: %w", err)
+			}
+		}
+		if change != nil && change.Action == RENAME && change.Path == filepath && follow {
+			filepath = change.OldPath
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package tighistory
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// loggedCommits return the ids of the commits written by [Log]
+func loggedCommits(out string) []string {
+	var ids []string
+	for _, line := range strings.Split(out, "\n") {
+		if id, ok := strings.CutPrefix(line, "commit "); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func TestLog(t *testing.T) {
+	ctx, tree := newTestRepo(t)
+	one := commitFiles(t, ctx, tree, "one", map[string]string{"a.txt": "1\n2\n3\n", "b.txt": "b\n"})
+	two := commitFiles(t, ctx, tree, "two", map[string]string{"b.txt": "b2\n"})
+	three := commitFiles(t, ctx, tree, "three", map[string]string{"c.txt": "1\n2\n3\n4\n"}, "a.txt")
+	four := commitFiles(t, ctx, tree, "four", map[string]string{"c.txt": "1\n2\n3\n4\n5\n"})
+
+	for _, test := range []struct {
+		filepath string
+		follow   bool
+		expected []*TigCommit
+	}{
+		{"", false, []*TigCommit{four, three, two, one}},
+		{"b.txt", false, []*TigCommit{two, one}},
+		{"c.txt", false, []*TigCommit{four, three}},
+		{"c.txt", true, []*TigCommit{four, three, one}},
+		{"./c.txt", true, []*TigCommit{four, three, one}},
+	} {
+		var out bytes.Buffer
+		if err := Log(ctx, tree, test.filepath, test.follow, false, &out); err != nil {
+			t.Fatalf("Log(%s, follow %v): %s", test.filepath, test.follow, err)
+		}
+		var expected []string
+		for _, commit := range test.expected {
+			expected = append(expected, commit.Id)
+		}
+		if ids := loggedCommits(out.String()); !slices.Equal(ids, expected) {
+			t.Fatalf("Log(%s, follow %v) must write the commits %v, not %v", test.filepath, test.follow, expected, ids)
+		}
+		if strings.Contains(out.String(), "diff --tig") {
+			t.Fatalf("Log() without patch must not write diffs:\n%s", out.String())
+		}
+	}
+
+	// With a path, the patch is limited to the file
+	var out bytes.Buffer
+	if err := Log(ctx, tree, "b.txt", false, true, &out); err != nil {
+		t.Fatalf("Log(b.txt, patch): %s", err)
+	}
+	for _, expected := range []string{"diff --tig a/b.txt b/b.txt\n", "-b\n", "+b2\n", "+b\n"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("Log(b.txt, patch) must contain %q:\n%s", expected, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "a.txt") {
+		t.Fatalf("Log(b.txt, patch) must only write the diffs of b.txt:\n%s", out.String())
+	}
+	out.Reset()
+	if err := Log(ctx, tree, "c.txt", true, true, &out); err != nil {
+		t.Fatalf("Log(c.txt, follow, patch): %s", err)
+	}
+	if !strings.Contains(out.String(), "renamed: a.txt -> c.txt\n") || !strings.Contains(out.String(), "+5\n") {
+		t.Fatalf("Log(c.txt, follow, patch) must write the rename and the diffs:\n%s", out.String())
+	}
+
+	if err := Log(ctx, tree, "missing.txt", false, false, &out); err == nil {
+		t.Fatalf("Log() of an unknown path must fail")
+	}
+}
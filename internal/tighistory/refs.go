@@ -0,0 +1,150 @@
+package tighistory
+
+/*
+How to store the refs:
+- One file per ref, its path relative to TigRootPath is the ref name
+- A single line with the commit id
+- Branches are refs/heads/<branch>, remote branches refs/remotes/<remote>/<branch>
+
+###FILE START
+2b1f0f3c8a0e9720b207ea98bd8be9ae8da8db98
+###FILE END
+
+*/
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfile"
+)
+
+// TigRefsDirName directory relative to TigRootPath
+const TigRefsDirName = "refs"
+
+// Prefixes of the ref names
+const (
+	REF_HEADS   = "refs/heads/"
+	REF_REMOTES = "refs/remotes/"
+)
+
+// ErrBadRefName is returned for a ref name which does not name a file under the refs directory
+var ErrBadRefName = errors.New("Bad ref name")
+
+// CheckRefName return ErrBadRefName unless name is a branch: refs/heads/<branch>, the segments of
+// branch are neither empty, nor "." or ".."
+func CheckRefName(name string) error {
+	branch, ok := strings.CutPrefix(name, REF_HEADS)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrBadRefName, name)
+	}
+	for _, segment := range strings.Split(branch, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("%w: %q", ErrBadRefName, name)
+		}
+	}
+	return nil
+}
+
+// loadRefs read the branches and the remote branches
+func (t *TigCommitTree) loadRefs(ctx tigconfig.TigCtx) error {
+	t.Refs = make(map[string]string, 8)
+	for _, prefix := range []string{REF_HEADS, REF_REMOTES} {
+		root := path.Join(ctx.TigPath, prefix)
+		err := filepath.WalkDir(root, func(filePath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			b, err := tigfile.ReadFileBytes(filePath, -1)
+			if err != nil {
+				return err
+			}
+			if id := strings.TrimSpace(string(b)); len(id) > 0 {
+				t.Refs[prefix+filepath.ToSlash(filePath[len(root)+1:])] = id
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("Cannot read refs: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetRef point the ref name, a branch or a remote branch, to the commit id and save it
+func (t *TigCommitTree) SetRef(ctx tigconfig.TigCtx, name string, id string) error {
+	if short := ShortRefName(name); short == name || CheckRefName(REF_HEADS+short) != nil {
+		return fmt.Errorf("SetRef: %w: %q", ErrBadRefName, name)
+	}
+	refPath := path.Join(ctx.TigPath, name)
+	if err := os.MkdirAll(path.Dir(refPath), tigfile.DIR_PERM); err != nil {
+		return fmt.Errorf("SetRef: %w", err)
+	}
+	if err := tigfile.WriteFileAtomicString(refPath, id+"\n"); err != nil {
+		return fmt.Errorf("SetRef: %w", err)
+	}
+	if t.Refs == nil {
+		t.Refs = make(map[string]string, 8)
+	}
+	t.Refs[name] = id
+	return nil
+}
+
+// RefNode return the commit node of a ref, a branch name or a remote branch name ("origin/main")
+func (t *TigCommitTree) RefNode(name string) *NTree[*TigCommit] {
+	for _, refName := range []string{name, REF_HEADS + name, REF_REMOTES + name} {
+		if id, ok := t.Refs[refName]; ok {
+			return t.Get(id)
+		}
+	}
+	return nil
+}
+
+// ShortRefName return the name of a ref without its refs/heads/ or refs/remotes/ prefix
+func ShortRefName(name string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(name, REF_HEADS), REF_REMOTES)
+}
+
+// Upstream return the ref tracked by the current branch, or false if none is configured
+func (t *TigCommitTree) Upstream(ctx tigconfig.TigCtx) (string, bool) {
+	if len(t.Branch) == 0 {
+		return "", false
+	}
+	remote, branch, ok := ctx.BranchUpstream(t.Branch)
+	if !ok {
+		return "", false
+	}
+	if remote == "." {
+		return REF_HEADS + branch, true
+	}
+	return REF_REMOTES + remote + "/" + branch, true
+}
+
+// AheadBehind return the number of commits of a not in b, and of b not in a
+func AheadBehind(a, b *NTree[*TigCommit]) (int, int) {
+	inB := make(map[*NTree[*TigCommit]]int, 32) // Node -> distance from b
+	distance := 0
+	for ptr := b; ptr != nil; ptr = ptr.Parent {
+		inB[ptr] = distance
+		distance++
+	}
+	ahead := 0
+	for ptr := a; ptr != nil; ptr = ptr.Parent {
+		if behind, ok := inB[ptr]; ok {
+			return ahead, behind
+		}
+		ahead++
+	}
+	return ahead, distance
+}
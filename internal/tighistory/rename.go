@@ -0,0 +1,165 @@
+package tighistory
+
+import (
+	"slices"
+	"tig/internal/tigdiff"
+	"tig/internal/tigfile"
+	"tig/internal/tigfs"
+)
+
+// Over this number of (source, target) pairs, only exact renames are detected
+const RENAME_LIMIT = 10000
+
+// RenameCandidate is a file which can be the source or the target of a rename or a copy
+type RenameCandidate struct {
+	Path    string
+	Hash    string
+	Content func() ([]byte, error)
+}
+
+// RenamePair is a detected rename (or copy) with its similarity in percent
+type RenamePair struct {
+	From  string
+	To    string
+	Score int
+}
+
+// SnapshotCandidate return a candidate for a snapshot stored in the FS
+func SnapshotCandidate(filepath string, snapshot *tigfs.TigFileSnapshot) RenameCandidate {
+	return RenameCandidate{Path: filepath, Hash: snapshot.Hash, Content: func() ([]byte, error) {
+		return textContent(snapshot.ReadText())
+	}}
+}
+
+// WorktreeCandidate return a candidate for a file of the working tree, hashed like its snapshot would be
+func WorktreeCandidate(fs *tigfs.TigFS, filepath string) (RenameCandidate, error) {
+	hash, err := fs.HashFile(filepath)
+	if err != nil {
+		return RenameCandidate{}, err
+	}
+	return RenameCandidate{Path: filepath, Hash: hash, Content: func() ([]byte, error) {
+		return textContent(tigfile.ReadFileText(filepath))
+	}}, nil
+}
+
+// textContent return the content of a text file for a similarity match, nil for a binary or too big file:
+// such a file only matches its exact copies, which are already found by hash
+func textContent(data []byte, binary bool, err error) ([]byte, error) {
+	if err != nil || binary {
+		return nil, err
+	}
+	return data, nil
+}
+
+// matchCandidates pair targets with sources. Exact hash matches come first,
+// then content similarity above threshold. With consume, a source is used only once.
+func matchCandidates(sources, targets []RenameCandidate, threshold int, consume bool) []RenamePair {
+	var pairs []RenamePair
+	usedSources := make([]bool, len(sources))
+	usedTargets := make([]bool, len(targets))
+
+	for t, target := range targets {
+		for s, source := range sources {
+			if (consume && usedSources[s]) || source.Hash != target.Hash {
+				continue
+			}
+			pairs = append(pairs, RenamePair{From: source.Path, To: target.Path, Score: 100})
+			usedSources[s], usedTargets[t] = true, true
+			break
+		}
+	}
+	if len(sources)*len(targets) > RENAME_LIMIT {
+		return pairs
+	}
+
+	contents := func(candidates []RenameCandidate, used []bool) [][]byte {
+		data := make([][]byte, len(candidates))
+		for i, candidate := range candidates {
+			if used[i] && consume {
+				continue
+			}
+			data[i], _ = candidate.Content() // Unreadable files can't match
+		}
+		return data
+	}
+	sourcesData := contents(sources, usedSources)
+	targetsData := contents(targets, usedTargets)
+	type scoredPair struct {
+		pair           RenamePair
+		source, target int
+	}
+	var inexact []scoredPair
+	for t := range targets {
+		if usedTargets[t] || targetsData[t] == nil {
+			continue
+		}
+		for s := range sources {
+			if (consume && usedSources[s]) || sourcesData[s] == nil {
+				continue
+			}
+			score := tigdiff.Similarity(sourcesData[s], targetsData[t])
+			if score >= threshold {
+				pair := RenamePair{From: sources[s].Path, To: targets[t].Path, Score: score}
+				inexact = append(inexact, scoredPair{pair: pair, source: s, target: t})
+			}
+		}
+	}
+	// Best scores first
+	slices.SortStableFunc(inexact, func(a, b scoredPair) int { return b.pair.Score - a.pair.Score })
+	for _, scored := range inexact {
+		if usedTargets[scored.target] || (consume && usedSources[scored.source]) {
+			continue
+		}
+		usedSources[scored.source], usedTargets[scored.target] = true, true
+		pairs = append(pairs, scored.pair)
+	}
+	return pairs
+}
+
+// MatchRenames pair deleted files with added files, each file is used at most once
+func MatchRenames(deleted, added []RenameCandidate, threshold int) []RenamePair {
+	return matchCandidates(deleted, added, threshold, true)
+}
+
+// MatchCopies pair added files with the existing files they were copied from
+func MatchCopies(existing, added []RenameCandidate, threshold int) []RenamePair {
+	return matchCandidates(existing, added, threshold, false)
+}
+
+// DetectRenames replace the DELETE and ADD changes of the commit which are renames
+// by RENAME changes. state is the content of the project before the commit.
+func (c *TigCommit) DetectRenames(state TigTreeState, threshold int) {
+	var deleted, added []RenameCandidate
+	for _, change := range c.Changes {
+		filePath := change.Path
+		if change.Action == DELETE {
+			if snapshot, ok := state[filePath]; ok {
+				deleted = append(deleted, SnapshotCandidate(filePath, snapshot))
+			}
+		} else if change.Action == ADD {
+			added = append(added, SnapshotCandidate(filePath, change.FileSnapshot))
+		}
+	}
+	if len(deleted) == 0 || len(added) == 0 {
+		return
+	}
+	renamedFrom := make(map[string]string, 8)
+	isRenamed := make(map[string]bool, 8)
+	for _, pair := range MatchRenames(deleted, added, threshold) {
+		renamedFrom[pair.To] = pair.From
+		isRenamed[pair.From] = true
+	}
+	changes := c.Changes[:0]
+	for _, change := range c.Changes {
+		filePath := change.Path
+		if change.Action == DELETE && isRenamed[filePath] {
+			continue
+		}
+		if oldPath, ok := renamedFrom[filePath]; ok && change.Action == ADD {
+			change.Action = RENAME
+			change.OldPath = oldPath
+		}
+		changes = append(changes, change)
+	}
+	c.Changes = changes
+}
@@ -0,0 +1,48 @@
+package tighistory
+
+import (
+	"strings"
+	"testing"
+	"tig/internal/tigfile"
+)
+
+func fakeCandidate(path string, content string) RenameCandidate {
+	return RenameCandidate{
+		Path:    path,
+		Hash:    tigfile.HashBytes([]byte(content)),
+		Content: func() ([]byte, error) { return []byte(content), nil },
+	}
+}
+
+func TestMatchRenames(t *testing.T) {
+	base := strings.Repeat("line\n", 3) + "a\nb\nc\nd\n"
+	deleted := []RenameCandidate{
+		fakeCandidate("old_exact.go", "exact\ncontent\n"),
+		fakeCandidate("old_similar.go", base),
+		fakeCandidate("old_gone.go", "nothing\nalike\n"),
+	}
+	added := []RenameCandidate{
+		fakeCandidate("new_similar.go", base+"e\n"),
+		fakeCandidate("new_exact.go", "exact\ncontent\n"),
+		fakeCandidate("new_other.go", "x\ny\nz\n"),
+	}
+	pairs := MatchRenames(deleted, added, 50)
+	if len(pairs) != 2 {
+		t.Fatalf("Must find 2 renames, not %d: %v", len(pairs), pairs)
+	}
+	if pairs[0].From != "old_exact.go" || pairs[0].To != "new_exact.go" || pairs[0].Score != 100 {
+		t.Fatalf("Exact rename must come first: %v", pairs[0])
+	}
+	if pairs[1].From != "old_similar.go" || pairs[1].To != "new_similar.go" {
+		t.Fatalf("Bad similar rename: %v", pairs[1])
+	}
+	if pairs = MatchRenames(deleted, added, 95); len(pairs) != 1 {
+		t.Fatalf("Threshold must filter similar renames: %v", pairs)
+	}
+
+	// A source can be copied many times
+	copies := MatchCopies(deleted[:1], []RenameCandidate{added[1], fakeCandidate("copy2.go", "exact\ncontent\n")}, 50)
+	if len(copies) != 2 {
+		t.Fatalf("Must find 2 copies, not %d: %v", len(copies), copies)
+	}
+}
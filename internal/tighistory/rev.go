@@ -0,0 +1,86 @@
+package tighistory
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Minimum length of an abbreviated commit id
+const MIN_ID_PREFIX = 4
+
+var ErrUnknownRev = errors.New("Unknown revision")
+
+// FindById return the commit node whose id starts with prefix.
+// The prefix must be at least MIN_ID_PREFIX long and match only one commit.
+func (t *TigCommitTree) FindById(prefix string) (*NTree[*TigCommit], error) {
+	if len(prefix) < MIN_ID_PREFIX {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownRev, prefix)
+	}
+	var found *NTree[*TigCommit]
+	ambiguous := false
+	t.Tree.Walk(func(node *NTree[*TigCommit]) {
+		if node.Value != nil && strings.HasPrefix(node.Value.Id, prefix) {
+			if found != nil {
+				ambiguous = true
+			}
+			found = node
+		}
+	})
+	if ambiguous {
+		return nil, fmt.Errorf("Ambiguous revision: %s", prefix)
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownRev, prefix)
+	}
+	return found, nil
+}
+
+// Resolve return the commit node of a revision: "HEAD", a branch, a remote branch ("origin/main"),
+// a commit id (or prefix), optionally followed by "~n" or "^" to go up the parents
+func (t *TigCommitTree) Resolve(rev string) (*NTree[*TigCommit], error) {
+	base, suffix := rev, ""
+	if i := strings.IndexAny(rev, "~^"); i != -1 {
+		base, suffix = rev[:i], rev[i:]
+	}
+	var node *NTree[*TigCommit]
+	if base == "HEAD" || base == "" {
+		node = t.Head
+	} else if refNode := t.RefNode(base); refNode != nil {
+		node = refNode
+	} else {
+		var err error
+		node, err = t.FindById(base)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for len(suffix) > 0 {
+		n := 1
+		op := suffix[0]
+		suffix = suffix[1:]
+		if op == '~' {
+			end := 0
+			for end < len(suffix) && suffix[end] >= '0' && suffix[end] <= '9' {
+				end++
+			}
+			if end > 0 {
+				n, _ = strconv.Atoi(suffix[:end])
+			}
+			suffix = suffix[end:]
+		} else if op != '^' {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownRev, rev)
+		}
+		for ; n > 0; n-- {
+			if node == nil || node.Value == nil {
+				return nil, fmt.Errorf("%w: %s", ErrUnknownRev, rev)
+			}
+			node = node.Parent
+		}
+	}
+	if node == nil || node.Value == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownRev, rev)
+	}
+	return node, nil
+}
@@ -0,0 +1,83 @@
+package tighistory
+
+/*
+How to store the shallow commits:
+- Line oriented, a commit id per line
+- Commits of a shallow clone whose parents were not copied, their changes add every file of their state
+
+###FILE START
+2b1f0f3c8a0e9720b207ea98bd8be9ae8da8db98
+###FILE END
+
+*/
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfile"
+)
+
+// TigShallowFileName Path relative to TigRootPath
+const TigShallowFileName = "shallow"
+
+// ShallowHistory return a tree holding the depth last commits up to tip, and the node of tip in it.
+// The oldest kept commit is grafted on the root, see [TigCommitTree.Graft]. t is not modified.
+func (t *TigCommitTree) ShallowHistory(tip *NTree[*TigCommit], depth int) (*NTree[*TigCommit], *NTree[*TigCommit]) {
+	root := &NTree[*TigCommit]{}
+	var ancestors []*NTree[*TigCommit]
+	for ptr := tip; ptr != nil && ptr.Value != nil && len(ancestors) < depth; ptr = ptr.Parent {
+		ancestors = append(ancestors, ptr)
+	}
+	slices.Reverse(ancestors)
+	node := root
+	for i, ancestor := range ancestors {
+		commit := ancestor.Value
+		if i == 0 {
+			commit = t.Graft(ancestor)
+		}
+		node = node.Add(commit)
+	}
+	return root, node
+}
+
+// Graft return a copy of the commit of node whose changes add every file of its state, so it can
+// be the first commit of a shallow history. Its id and parent id are kept.
+func (t *TigCommitTree) Graft(node *NTree[*TigCommit]) *TigCommit {
+	commit := *node.Value
+	state := t.State(node)
+	paths := make([]string, 0, len(state))
+	for filepath := range state {
+		paths = append(paths, filepath)
+	}
+	slices.Sort(paths)
+	commit.Changes = make([]TigChange, 0, len(paths))
+	for _, filepath := range paths {
+		commit.Changes = append(commit.Changes, TigChange{Action: ADD, Path: filepath, FileSnapshot: state[filepath]})
+	}
+	return &commit
+}
+
+// LoadShallow return the grafted commits of a shallow clone, none for a full one
+func LoadShallow(ctx tigconfig.TigCtx) (map[string]bool, error) {
+	lines, err := tigfile.ReadFileLines(path.Join(ctx.TigPath, TigShallowFileName), tigfile.MAX_FILE_SIZE)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("LoadShallow: %w", err)
+	}
+	shallow := make(map[string]bool, len(lines))
+	for _, id := range lines {
+		shallow[id] = true
+	}
+	return shallow, nil
+}
+
+// SaveShallow write the grafted commits of a shallow clone
+func SaveShallow(ctx tigconfig.TigCtx, ids []string) error {
+	if err := tigfile.WriteFileAtomicLines(path.Join(ctx.TigPath, TigShallowFileName), ids); err != nil {
+		return fmt.Errorf("SaveShallow: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package tighistory
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"tig/internal/tigconfig"
+	"tig/internal/tigdiff"
+	"tig/internal/tigfile"
+	"tig/internal/tigfs"
+	"time"
+)
+
+// Date format used when printing commits
+const DATE_FORMAT = "Mon Jan 2 15:04:05 2006 -0700"
+
+// Object types of [CatFile]
+const (
+	OBJECT_COMMIT = "commit"
+	OBJECT_BLOB   = "blob"
+)
+
+// AuthorName return the decoded author of the commit
+func (c *TigCommit) AuthorName() string {
+	author, err := tigfile.B64DecodeStr(c.Author)
+	if err != nil {
+		return c.Author
+	}
+	return author
+}
+
+// Message return the decoded message of the commit
+func (c *TigCommit) Message() string {
+	msg, err := tigfile.B64DecodeStr(c.Msg)
+	if err != nil {
+		return c.Msg
+	}
+	return msg
+}
+
+// DateStr return the commit date in DATE_FORMAT
+func (c *TigCommit) DateStr() string {
+	return time.Unix(c.Date, 0).Format(DATE_FORMAT)
+}
+
+// WriteHeader write the commit metadata, like git log does
+func (c *TigCommit) WriteHeader(w io.Writer) {
+	fmt.Fprintf(w, "commit %s\n", c.Id)
+	fmt.Fprintf(w, "Author: %s\n", c.AuthorName())
+	fmt.Fprintf(w, "Date:   %s\n\n", c.DateStr())
+	for _, line := range strings.Split(c.Message(), "\n") {
+		fmt.Fprintf(w, "    %s\n", line)
+	}
+}
+
+// readSnapshot return the content of a text snapshot, nil snapshot is an empty file.
+// A binary or too big snapshot is not loaded, see [tigfs.TigFileSnapshot.ReadText].
+func readSnapshot(snapshot *tigfs.TigFileSnapshot) ([]byte, bool, error) {
+	if snapshot == nil {
+		return []byte{}, false, nil
+	}
+	return snapshot.ReadText()
+}
+
+// WriteChangeDiff write the diff of a file from oldSnap to newSnap, nil is a missing file.
+// oldPath and newPath differ for a rename or a copy.
+func WriteChangeDiff(w io.Writer, oldPath, newPath string, oldSnap, newSnap *tigfs.TigFileSnapshot) error {
+	if oldSnap != nil && newSnap != nil && oldSnap.Hash == newSnap.Hash {
+		return nil // Pure rename or copy, nothing to show
+	}
+	oldData, oldBinary, err := readSnapshot(oldSnap)
+	if err != nil {
+		return err
+	}
+	newData, newBinary, err := readSnapshot(newSnap)
+	if err != nil {
+		return err
+	}
+	oldName, newName := "a/"+oldPath, "b/"+newPath
+	if oldSnap == nil {
+		oldName = "/dev/null"
+	}
+	if newSnap == nil {
+		newName = "/dev/null"
+	}
+	fmt.Fprintf(w, "diff --tig a/%s b/%s\n", oldPath, newPath)
+	if oldBinary || newBinary {
+		return tigdiff.WriteBinary(w, oldName, newName)
+	}
+	return tigdiff.WriteUnified(w, oldName, newName, oldData, newData, tigdiff.DEFAULT_CONTEXT)
+}
+
+// detectCopies return the added files of the commit which are copies: path -> source path.
+// Nothing is detected unless [tigconfig.TigCtx.DetectCopies].
+func detectCopies(ctx tigconfig.TigCtx, commit *TigCommit, parentState TigTreeState) map[string]string {
+	if !ctx.DetectCopies() {
+		return nil
+	}
+	var existing, added []RenameCandidate
+	for _, change := range commit.Changes {
+		if change.Action == ADD {
+			added = append(added, SnapshotCandidate(change.Path, change.FileSnapshot))
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	for filePath, snapshot := range parentState {
+		existing = append(existing, SnapshotCandidate(filePath, snapshot))
+	}
+	copies := make(map[string]string, len(added))
+	for _, pair := range MatchCopies(existing, added, ctx.RenameThreshold()) {
+		copies[pair.To] = pair.From
+	}
+	return copies
+}
+
+// writeChange write the diff of a change, parentState is the content before the change
+func writeChange(w io.Writer, change TigChange, parentState TigTreeState, copies map[string]string) error {
+	filePath := change.Path
+	oldPath, newSnap := filePath, change.FileSnapshot
+	switch change.Action {
+	case DELETE:
+		newSnap = nil
+	case RENAME:
+		oldPath = change.OldPath
+		fmt.Fprintf(w, "renamed: %s -> %s\n", oldPath, filePath)
+	case ADD:
+		if source, ok := copies[filePath]; ok {
+			fmt.Fprintf(w, "copied: %s -> %s\n", source, filePath)
+			return WriteChangeDiff(w, source, filePath, parentState[source], newSnap)
+		}
+	}
+	return WriteChangeDiff(w, oldPath, filePath, parentState[oldPath], newSnap)
+}
+
+// WriteCommitDiff write the diff introduced by the commit node, compared to its parent
+func (t *TigCommitTree) WriteCommitDiff(ctx tigconfig.TigCtx, w io.Writer, node *NTree[*TigCommit]) error {
+	parentState := t.State(node.Parent)
+	copies := detectCopies(ctx, node.Value, parentState)
+	for _, change := range node.Value.Changes {
+		if err := writeChange(w, change, parentState, copies); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Show write a commit and its diff to w, or a file content with "rev:path"
+func Show(ctx tigconfig.TigCtx, tree *TigCommitTree, rev string, w io.Writer) error {
+	if rev, filePath, ok := strings.Cut(rev, ":"); ok {
+		node, err := tree.Resolve(rev)
+		if err != nil {
+			return fmt.Errorf("Show: %w", err)
+		}
+		snapshot, ok := tree.State(node)[filePath]
+		if !ok {
+			return fmt.Errorf("Show: path %s does not exist in %s", filePath, rev)
+		}
+		if _, err := snapshot.WriteTo(w); err != nil {
+			return fmt.Errorf("Show: %w", err)
+		}
+		return nil
+	}
+	node, err := tree.Resolve(rev)
+	if err != nil {
+		return fmt.Errorf("Show: %w", err)
+	}
+	node.Value.WriteHeader(w)
+	fmt.Fprintln(w)
+	if err = tree.WriteCommitDiff(ctx, w, node); err != nil {
+		return fmt.Errorf("Show: %w", err)
+	}
+	return nil
+}
+
+// raw return the content of the commit object, as printed by cat-file -p
+func (c *TigCommit) raw() string {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("parent %s\n", c.ParentId))
+	builder.WriteString(fmt.Sprintf("author %s %d\n", c.AuthorName(), c.Date))
+	for _, change := range c.Changes {
+		builder.WriteString(fmt.Sprintf("change %s %s %s", ChangeActionToStr(change.Action),
+			change.Path, change.FileSnapshot.Hash))
+		if change.Action == RENAME {
+			builder.WriteString(" " + change.OldPath)
+		}
+		builder.WriteString("\n")
+	}
+	builder.WriteString("\n" + c.Message() + "\n")
+	return builder.String()
+}
+
+// findBlob return the path of the blob whose hash starts with prefix, or an empty string
+func findBlob(ctx tigconfig.TigCtx, prefix string) (string, error) {
+	entries, err := os.ReadDir(ctx.FS.DirPath)
+	if err != nil {
+		return "", err
+	}
+	var found string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name[0] == '_' || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if len(found) > 0 {
+			return "", fmt.Errorf("Ambiguous object: %s", prefix)
+		}
+		found = name
+	}
+	return found, nil
+}
+
+// CatFile write the type (-t), size (-s) or content (-p) of a commit or a blob to w
+func CatFile(ctx tigconfig.TigCtx, tree *TigCommitTree, flag string, id string, w io.Writer) error {
+	if flag != "-t" && flag != "-s" && flag != "-p" {
+		return fmt.Errorf("CatFile: unknown flag %s", flag)
+	}
+	node, err := tree.FindById(id)
+	if err != nil && !errors.Is(err, ErrUnknownRev) {
+		return fmt.Errorf("CatFile: %w", err)
+	}
+	blobName := ""
+	if len(id) >= MIN_ID_PREFIX {
+		blobName, err = findBlob(ctx, id)
+		if err != nil {
+			return fmt.Errorf("CatFile: %w", err)
+		}
+	}
+	if node != nil && len(blobName) > 0 {
+		return fmt.Errorf("CatFile: ambiguous object: %s", id)
+	}
+
+	if node != nil {
+		raw := node.Value.raw()
+		if flag == "-t" {
+			fmt.Fprintln(w, OBJECT_COMMIT)
+		} else if flag == "-s" {
+			fmt.Fprintln(w, len(raw))
+		} else {
+			fmt.Fprint(w, raw)
+		}
+		return nil
+	}
+	if len(blobName) == 0 {
+		return fmt.Errorf("CatFile: object %s does not exist", id)
+	}
+	blobPath := path.Join(ctx.FS.DirPath, blobName)
+	if flag == "-t" {
+		fmt.Fprintln(w, OBJECT_BLOB)
+	} else if flag == "-s" {
+		info, err := os.Stat(blobPath)
+		if err != nil {
+			return fmt.Errorf("CatFile: %w", err)
+		}
+		fmt.Fprintln(w, info.Size())
+	} else {
+		f, err := tigfile.Open(blobPath, os.O_RDONLY)
+		if err != nil {
+			return fmt.Errorf("CatFile: %w", err)
+		}
+		defer f.Close()
+		if _, err := io.Copy(w, f); err != nil {
+			return fmt.Errorf("CatFile: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package tighistory
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfile"
+)
+
+// newTestRepo create a repository in a temporary directory, move to it and load it
+func newTestRepo(t *testing.T) (tigconfig.TigCtx, *TigCommitTree) {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+	ctx := tigconfig.TigCtx{ProjectPath: dir, TigPath: path.Join(dir, tigconfig.TigRootPath)}
+	if err := ctx.Init(); err != nil {
+		t.Fatalf("Init(): %s", err)
+	}
+	if err := ctx.LoadConfig(); err != nil {
+		t.Fatalf("LoadConfig(): %s", err)
+	}
+	if err := ctx.LoadFS(); err != nil {
+		t.Fatalf("LoadFS(): %s", err)
+	}
+	tree, err := LoadCommits(ctx)
+	if err != nil {
+		t.Fatalf("LoadCommits(): %s", err)
+	}
+	return ctx, tree
+}
+
+// commitFiles write the files, remove the deleted ones, and commit these changes
+func commitFiles(t *testing.T, ctx tigconfig.TigCtx, tree *TigCommitTree, msg string,
+	files map[string]string, deleted ...string) *TigCommit {
+	commit := &TigCommit{}
+	state := tree.HeadState()
+	for filePath, content := range files {
+		if err := tigfile.WriteFileString(filePath, content); err != nil {
+			t.Fatal(err)
+		}
+		if err := commit.Stage(ctx, state, filePath); err != nil {
+			t.Fatalf("Stage(): %s", err)
+		}
+	}
+	for _, filePath := range deleted {
+		if err := os.Remove(filePath); err != nil {
+			t.Fatal(err)
+		}
+		commit.StageDelete(state, filePath)
+	}
+	if err := commit.Commit(ctx, tree, msg); err != nil {
+		t.Fatalf("Commit(): %s", err)
+	}
+	return commit
+}
+
+func TestShow(t *testing.T) {
+	ctx, tree := newTestRepo(t)
+	commitFiles(t, ctx, tree, "one", map[string]string{"a.txt": "1\n2\n", "b.txt": "b\n"})
+	two := commitFiles(t, ctx, tree, "two", map[string]string{"a.txt": "1\n3\n"})
+
+	var out bytes.Buffer
+	if err := Show(ctx, tree, "HEAD", &out); err != nil {
+		t.Fatalf("Show(HEAD): %s", err)
+	}
+	for _, expected := range []string{"commit " + two.Id + "\n", "    two\n", "diff --tig a/a.txt b/a.txt\n", "-2\n", "+3\n"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("Show(HEAD) must contain %q:\n%s", expected, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "b.txt") {
+		t.Fatalf("Show(HEAD) must only show the files of the commit:\n%s", out.String())
+	}
+
+	for rev, content := range map[string]string{"HEAD:a.txt": "1\n3\n", "HEAD~1:a.txt": "1\n2\n", "HEAD^:b.txt": "b\n"} {
+		out.Reset()
+		if err := Show(ctx, tree, rev, &out); err != nil || out.String() != content {
+			t.Fatalf("Show(%s) must write %q, not %q (%v)", rev, content, out.String(), err)
+		}
+	}
+	for _, rev := range []string{"HEAD:missing.txt", "HEAD~2", "unknown"} {
+		if err := Show(ctx, tree, rev, &out); err == nil {
+			t.Fatalf("Show(%s) must fail", rev)
+		}
+	}
+}
+
+func TestCatFile(t *testing.T) {
+	ctx, tree := newTestRepo(t)
+	one := commitFiles(t, ctx, tree, "one", map[string]string{"a.txt": "1\n"})
+	two := commitFiles(t, ctx, tree, "two", map[string]string{"a.txt": "1\n2\n"})
+	blob := tigfile.HashBytes([]byte("1\n2\n"))
+
+	raw := fmt.Sprintf("parent %s\nauthor %s %d\nchange modified a.txt %s\n\ntwo\n", one.Id, two.AuthorName(), two.Date, blob)
+	for _, test := range []struct{ flag, id, expected string }{
+		{"-t", two.Id, "commit\n"},
+		{"-p", two.Id[:8], raw},
+		{"-s", two.Id, fmt.Sprintf("%d\n", len(raw))},
+		{"-t", blob, "blob\n"},
+		{"-s", blob[:8], "4\n"},
+		{"-p", blob, "1\n2\n"},
+	} {
+		var out bytes.Buffer
+		if err := CatFile(ctx, tree, test.flag, test.id, &out); err != nil || out.String() != test.expected {
+			t.Fatalf("CatFile(%s %s) must write %q, not %q (%v)", test.flag, test.id, test.expected, out.String(), err)
+		}
+	}
+	for _, args := range [][2]string{{"-x", two.Id}, {"-t", "0000000000"}, {"-t", "ab"}} {
+		if err := CatFile(ctx, tree, args[0], args[1], &bytes.Buffer{}); err == nil {
+			t.Fatalf("CatFile(%s %s) must fail", args[0], args[1])
+		}
+	}
+}
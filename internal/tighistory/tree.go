@@ -10,33 +10,89 @@ import (
 
 // NTree is an N-ary tree structure. First child is the main child (the root branch)
 type NTree[T any] struct {
-	Parent *NTree[T]  `json:"-"` // Can't store parent because of cyclic json marshalling
-	Childs []NTree[T] `json:"childs"`
-	Value  T          `json:"value"`
+	Parent *NTree[T]   `json:"-"` // Can't store parent because of cyclic json marshalling
+	Childs []*NTree[T] `json:"childs"`
+	Value  T           `json:"value"`
 }
 
 func New[T any]() NTree[T] {
 	return NTree[T]{}
 }
 
-func (tree *NTree[T]) Add(value T) {
-	tree.Childs = append(tree.Childs, NTree[T]{Parent: tree, Value: value})
+// Add append a new child holding value and return it
+func (tree *NTree[T]) Add(value T) *NTree[T] {
+	child := &NTree[T]{Parent: tree, Value: value}
+	tree.Childs = append(tree.Childs, child)
+	return child
+}
+
+// Remove detach child and its subtree from tree, return false if child is not a child of tree
+func (tree *NTree[T]) Remove(child *NTree[T]) bool {
+	for i, ptr := range tree.Childs {
+		if ptr == child {
+			tree.Childs = append(tree.Childs[:i], tree.Childs[i+1:]...)
+			child.Parent = nil
+			return true
+		}
+	}
+	return false
 }
 
 func (tree *NTree[T]) GetMainChild(value T) *NTree[T] {
 	if len(tree.Childs) > 0 {
-		return &tree.Childs[0]
+		return tree.Childs[0]
 	} else {
 		return nil
 	}
 }
 
+// Find return the first node (depth first) for which match returns true, or nil
+func (tree *NTree[T]) Find(match func(T) bool) *NTree[T] {
+	toVisit := []*NTree[T]{tree}
+	for len(toVisit) > 0 {
+		node := toVisit[len(toVisit)-1]
+		toVisit = toVisit[:len(toVisit)-1]
+		if match(node.Value) {
+			return node
+		}
+		for i := len(node.Childs) - 1; i >= 0; i-- {
+			toVisit = append(toVisit, node.Childs[i])
+		}
+	}
+	return nil
+}
+
+// Walk call fn on every node (depth first), including the root
+func (tree *NTree[T]) Walk(fn func(*NTree[T])) {
+	toVisit := []*NTree[T]{tree}
+	for len(toVisit) > 0 {
+		node := toVisit[len(toVisit)-1]
+		toVisit = toVisit[:len(toVisit)-1]
+		fn(node)
+		for i := len(node.Childs) - 1; i >= 0; i-- {
+			toVisit = append(toVisit, node.Childs[i])
+		}
+	}
+}
+
+// Ancestors return the path from the root to tree (both included)
+func (tree *NTree[T]) Ancestors() []*NTree[T] {
+	var nodes []*NTree[T]
+	for ptr := tree; ptr != nil; ptr = ptr.Parent {
+		nodes = append(nodes, ptr)
+	}
+	for i, j := 0, len(nodes)-1; i < j; i, j = i+1, j-1 {
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	}
+	return nodes
+}
+
 func (tree *NTree[T]) Save(filepath string) error {
 	b, err := json.Marshal(tree)
 	if err != nil {
 		return fmt.Errorf("tree:Save(): %w", err)
 	}
-	err = tigfile.WriteFileBytes(filepath, b)
+	err = tigfile.WriteFileAtomic(filepath, b)
 	if err != nil {
 		return fmt.Errorf("tree:Save(): %w", err)
 	}
@@ -51,17 +107,22 @@ func (tree *NTree[T]) Load(filepath string) error {
 		}
 		return fmt.Errorf("tree:Load(): %w", err)
 	}
+	if len(b) == 0 {
+		return nil
+	}
 	err = json.Unmarshal(b, tree)
 	if err != nil {
 		return fmt.Errorf("tree:Load(): %w", err)
 	}
 	// Restore parenting
-	ptr := tree
-	for {
-		for i := 0; i < len(ptr.Childs); i++ {
-			ptr.Childs[i].Parent = ptr
+	toVisit := []*NTree[T]{tree}
+	for len(toVisit) > 0 {
+		ptr := toVisit[len(toVisit)-1]
+		toVisit = toVisit[:len(toVisit)-1]
+		for _, child := range ptr.Childs {
+			child.Parent = ptr
+			toVisit = append(toVisit, child)
 		}
-		break
 	}
 	return nil
 }
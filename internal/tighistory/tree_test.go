@@ -34,3 +34,20 @@ func TestTree(t *testing.T) {
 	}
 
 }
+
+func TestAheadBehind(t *testing.T) {
+	tree := New[*TigCommit]()
+	base := tree.Add(&TigCommit{Id: "base"})
+	ours := base.Add(&TigCommit{Id: "ours1"}).Add(&TigCommit{Id: "ours2"})
+	theirs := base.Add(&TigCommit{Id: "theirs1"})
+
+	if ahead, behind := AheadBehind(ours, theirs); ahead != 2 || behind != 1 {
+		t.Fatalf("Diverged: ahead %d behind %d, expected 2 and 1", ahead, behind)
+	}
+	if ahead, behind := AheadBehind(ours, base); ahead != 2 || behind != 0 {
+		t.Fatalf("Ahead: ahead %d behind %d, expected 2 and 0", ahead, behind)
+	}
+	if ahead, behind := AheadBehind(base, ours); ahead != 0 || behind != 2 {
+		t.Fatalf("Behind: ahead %d behind %d, expected 0 and 2", ahead, behind)
+	}
+}
@@ -0,0 +1,159 @@
+// Package tigignore contains the .tigignore rules parsing and matching
+package tigignore
+
+/*
+How to write ignore rules, one pattern per line (like .gitignore):
+- Empty lines and lines starting with '#' are skipped
+- '!' at start negates the pattern, a later rule wins
+- '/' at end matches only directories
+- A pattern containing a '/' is relative to the project root, otherwise it matches at any depth
+- '*' matches anything but '/', '?' any character but '/', '**' matches across directories
+
+###FILE START
+# build outputs
+*.o
+/bin/
+!keep.o
+docs/**
+###FILE END
+
+*/
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"tig/internal/tigfile"
+)
+
+// TigIgnoreFileName path relative to the project root
+const TigIgnoreFileName = ".tigignore"
+
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+type TigIgnore struct {
+	rules []ignoreRule
+}
+
+// GlobToRegexp convert a glob to a regexp string (without anchors).
+// With pathname, '*' and '?' don't match '/' and '**' matches across directories.
+func GlobToRegexp(glob string, pathname bool) string {
+	var builder strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == '*' && pathname && strings.HasPrefix(glob[i:], "**/"):
+			builder.WriteString("(.*/)?")
+			i += 2
+		case c == '*' && pathname && strings.HasPrefix(glob[i:], "**"):
+			builder.WriteString(".*")
+			i++
+		case c == '*' && pathname:
+			builder.WriteString("[^/]*")
+		case c == '*':
+			builder.WriteString(".*")
+		case c == '?' && pathname:
+			builder.WriteString("[^/]")
+		case c == '?':
+			builder.WriteString(".")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end == -1 {
+				builder.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			builder.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			builder.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return builder.String()
+}
+
+// Parse create ignore rules from the lines of an ignore file
+func Parse(lines []string) (*TigIgnore, error) {
+	ignore := &TigIgnore{}
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		rule := ignoreRule{}
+		if line[0] == '!' {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if len(line) == 0 {
+			continue
+		}
+		prefix := "^(.*/)?"
+		if strings.Contains(line, "/") {
+			prefix = "^"
+			line = strings.TrimPrefix(line, "/")
+		}
+		re, err := regexp.Compile(prefix + GlobToRegexp(line, true) + "$")
+		if err != nil {
+			return nil, fmt.Errorf("Bad ignore pattern %s: %w", line, err)
+		}
+		rule.re = re
+		ignore.rules = append(ignore.rules, rule)
+	}
+	return ignore, nil
+}
+
+// Load read the ignore file of the project, no file means no rule
+func Load(projectPath string) (*TigIgnore, error) {
+	lines, err := tigfile.ReadFileLines(path.Join(projectPath, TigIgnoreFileName), tigfile.MAX_FILE_SIZE)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &TigIgnore{}, nil
+		}
+		return nil, fmt.Errorf("Load ignore: %w", err)
+	}
+	return Parse(lines)
+}
+
+// matchSelf apply the rules to filepath only, not to its parents
+func (ignore *TigIgnore) matchSelf(filepath string, isDir bool) bool {
+	ignored := false
+	for _, rule := range ignore.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(filepath) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// Match return true if filepath (relative to the project root) is ignored,
+// a file is also ignored when one of its parent directories is
+func (ignore *TigIgnore) Match(filepath string, isDir bool) bool {
+	filepath = path.Clean(filepath)
+	for dir := path.Dir(filepath); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if ignore.matchSelf(dir, true) {
+			return true
+		}
+	}
+	return ignore.matchSelf(filepath, isDir)
+}
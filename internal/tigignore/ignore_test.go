@@ -0,0 +1,33 @@
+package tigignore
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	ignore, err := Parse([]string{"# comment", "*.o", "!keep.o", "/bin/", "docs/**", "tmp/"})
+	if err != nil {
+		t.Fatalf("Parse(): %s", err)
+	}
+	cases := []struct {
+		filepath string
+		isDir    bool
+		ignored  bool
+	}{
+		{"main.o", false, true},
+		{"src/main.o", false, true},
+		{"keep.o", false, false},
+		{"src/keep.o", false, false},
+		{"main.go", false, false},
+		{"bin", true, true},
+		{"bin", false, false},
+		{"bin/tig", false, true},
+		{"src/bin", true, false},
+		{"docs/a/b.md", false, true},
+		{"src/tmp/x", false, true},
+		{"tmpfile", false, false},
+	}
+	for _, c := range cases {
+		if ignored := ignore.Match(c.filepath, c.isDir); ignored != c.ignored {
+			t.Errorf("Match(%q, %v) = %v, expected %v", c.filepath, c.isDir, ignored, c.ignored)
+		}
+	}
+}
@@ -1,57 +1,28 @@
 package tigindex
 
-/*
-How to store tracked files:
-- Line-oriented, orderless
-- A line equal to a tracked file -> "hash,path"
-- The hash from latest commit is cached, so we can check modification
-
-###FILE START
-ab42cd64ef01;main.go
-a0e9720b207e;internal/commit/tighistory.go
-###FILE END
-
-*/
-
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"os"
 	"path"
 	"tig/internal/tigconfig"
-	"tig/internal/tigfile"
+	"tig/internal/tigfs"
 	"tig/internal/tighistory"
 )
 
-// TigConfigFileName Path relative to TigRootPath
-const TigTrackFileName = "track"
-
-func getTrackedFiles(ctx tigconfig.TigCtx) ([]string, error) {
-	var fileList []string
-
-	fd, err := tigfile.Create(path.Join(ctx.TigPath, TigTrackFileName), os.O_RDONLY)
-	if err != nil {
-		return nil, err
+// IndexSnapshot return the snapshot filepath is compared to: the staged one, or the one at HEAD
+func IndexSnapshot(commit *tighistory.TigCommit, headState tighistory.TigTreeState, filepath string) *tigfs.TigFileSnapshot {
+	if change := commit.GetChange(filepath); change != nil {
+		return change.FileSnapshot
 	}
-	fileBytes, err := tigfile.ReadFdBytes(fd, tigfile.MAX_FILE_SIZE)
-	if err != nil {
-		return nil, err
-	}
-	for _, v := range bytes.Split(fileBytes, []byte("\n")) {
-		if len(v) > 0 {
-			fileList = append(fileList, string(v))
-		}
-	}
-
-	return fileList, nil
+	return headState[filepath]
 }
 
 func beforeAddRemoveFile(ctx tigconfig.TigCtx, fileList []string) (map[string]bool, *tighistory.TigCommit, error) {
 	if len(fileList) == 0 {
 		return nil, nil, errors.New("No file to process")
 	}
-	filesTracked, err := getTrackedFiles(ctx)
+	filesTracked, err := GetTrackedFiles(ctx)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -71,28 +42,43 @@ func afterAddRemoveFile(ctx tigconfig.TigCtx, commit *tighistory.TigCommit, file
 	if err != nil {
 		return err
 	}
-	var newTrackList []string
-	for k := range fileMap {
-		newTrackList = append(newTrackList, k)
-	}
-	if err := tigfile.WriteFileLines(
-		path.Join(ctx.TigPath, TigTrackFileName), newTrackList); err != nil {
-		return err
-	}
-	return nil
+	return SetTrackedFiles(ctx, fileMap)
 }
 
-func AddFile(ctx tigconfig.TigCtx, fileList []string) error {
-	filesMap, commit, err := beforeAddRemoveFile(ctx, fileList)
+type AddMode int
+
+const (
+	ADD_PATHS  AddMode = 0 // Files selected by the pathspecs
+	ADD_ALL    AddMode = 1 // Every change, new files included (-A)
+	ADD_UPDATE AddMode = 2 // Changes of tracked files only (-u)
+)
+
+// AddFile stage the files selected by the pathspecs args: new and modified files are staged,
+// deleted tracked files are staged for deletion. Without args, ADD_ALL and ADD_UPDATE select every file.
+func AddFile(ctx tigconfig.TigCtx, tree *tighistory.TigCommitTree, args []string, mode AddMode) error {
+	if len(args) == 0 && mode != ADD_PATHS {
+		args = []string{"."}
+	}
+	filesMap, commit, err := beforeAddRemoveFile(ctx, args)
 	if err != nil {
 		return fmt.Errorf("AddFile: %w", err)
 	}
+	fileList, err := expandPathspec(ctx, args, filesMap, mode != ADD_UPDATE)
+	if err != nil {
+		return fmt.Errorf("AddFile: %w", err)
+	}
+	headState := tree.HeadState()
 	for _, file := range fileList {
 		file = path.Clean(file)
 		_, err := os.Stat(file)
 
 		if errors.Is(err, os.ErrNotExist) {
-			return errors.New("File " + file + " does not exist")
+			if _, ok := filesMap[file]; !ok {
+				return errors.New("File " + file + " does not exist")
+			}
+			// Tracked file deleted from the working tree
+			commit.StageDelete(headState, file)
+			delete(filesMap, file)
 		} else {
 			mustStage := false
 			if _, ok := filesMap[file]; !ok {
@@ -100,7 +86,8 @@ func AddFile(ctx tigconfig.TigCtx, fileList []string) error {
 				filesMap[file] = true
 				mustStage = true
 			} else {
-				fileIsModified, err := ctx.FS.HasChanged(file)
+				fileIsModified, err := ctx.FS.HasChanged(
+					file, IndexSnapshot(commit, headState, file))
 				if err != nil {
 					return fmt.Errorf("AddFile: %w", err)
 				}
@@ -108,7 +95,7 @@ func AddFile(ctx tigconfig.TigCtx, fileList []string) error {
 			}
 			// Commit in both case, only if file has changed
 			if mustStage {
-				err = commit.Stage(ctx, file)
+				err = commit.Stage(ctx, headState, file)
 				if err != nil {
 					return fmt.Errorf("AddFile: Cannot stage file %s: %w", file, err)
 				}
@@ -122,29 +109,58 @@ func AddFile(ctx tigconfig.TigCtx, fileList []string) error {
 	return nil
 }
 
-func RemoveFile(ctx tigconfig.TigCtx, fileList []string) error {
-	filesMap, commit, err := beforeAddRemoveFile(ctx, fileList)
+// RemoveFile stage the deletion of the tracked files selected by the pathspecs args,
+// and remove them from the working tree.
+// With cached, files are kept on disk as untracked files.
+// Without force, files with local or staged modifications are not removed.
+func RemoveFile(ctx tigconfig.TigCtx, tree *tighistory.TigCommitTree, args []string, cached bool, force bool) error {
+	filesMap, commit, err := beforeAddRemoveFile(ctx, args)
 	if err != nil {
 		return fmt.Errorf("RemoveFile: %w", err)
 	}
-	for _, file := range fileList {
+	fileList, err := expandPathspec(ctx, args, filesMap, false)
+	if err != nil {
+		return fmt.Errorf("RemoveFile: %w", err)
+	}
+	headState := tree.HeadState()
+	// Every file is checked before anything is staged or removed
+	for i, file := range fileList {
 		file = path.Clean(file)
+		fileList[i] = file
 		if _, ok := filesMap[file]; !ok {
 			return errors.New("Tig don't know about " + file)
 		}
-		if commit.HasFile(file) {
-			err = commit.Unstage(file)
-			if err != nil {
-				continue
+		if !cached && !force {
+			if change := commit.GetChange(file); change != nil &&
+				(change.Action == tighistory.ADD || change.Action == tighistory.MODIFY) {
+				return fmt.Errorf("File %s has changes staged, use --cached to keep it or -f to force", file)
+			}
+			if _, err := os.Stat(file); err == nil {
+				hasChanged, err := ctx.FS.HasChanged(file, IndexSnapshot(commit, headState, file))
+				if err != nil {
+					return fmt.Errorf("RemoveFile: %w", err)
+				}
+				if hasChanged {
+					return fmt.Errorf("File %s has local modifications, use --cached to keep it or -f to force", file)
+				}
 			}
-		} else {
-			delete(filesMap, file)
 		}
-
+	}
+	for _, file := range fileList {
+		commit.StageDelete(headState, file)
+		delete(filesMap, file)
 	}
 	err = afterAddRemoveFile(ctx, commit, filesMap)
 	if err != nil {
 		return fmt.Errorf("RemoveFile: %w", err)
 	}
+	// Removed from the working tree once the index is saved
+	if !cached {
+		for _, file := range fileList {
+			if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("RemoveFile: %w", err)
+			}
+		}
+	}
 	return nil
 }
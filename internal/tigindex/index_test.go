@@ -0,0 +1,150 @@
+package tigindex
+
+import (
+	"os"
+	"slices"
+	"testing"
+	"tig/internal/tigconfig"
+	"tig/internal/tighistory"
+)
+
+// checkStaged check the action staged for each file, a missing action means nothing is staged
+func checkStaged(t *testing.T, commit *tighistory.TigCommit, expected map[string]string) {
+	for filePath, action := range expected {
+		change := commit.GetChange(filePath)
+		if len(action) == 0 && change != nil {
+			t.Fatalf("%s must not be staged: %+v", filePath, *change)
+		}
+		if len(action) > 0 && (change == nil || tighistory.ChangeActionToStr(change.Action) != action) {
+			t.Fatalf("%s must be staged as %s: %+v", filePath, action, commit.Changes)
+		}
+	}
+}
+
+// checkTracked check the tracked files
+func checkTracked(t *testing.T, ctx tigconfig.TigCtx, expected ...string) {
+	tracked, err := GetTrackedFiles(ctx)
+	if err != nil {
+		t.Fatalf("GetTrackedFiles(): %s", err)
+	}
+	slices.Sort(tracked)
+	if !slices.Equal(tracked, expected) {
+		t.Fatalf("Tracked files must be %v, not %v", expected, tracked)
+	}
+}
+
+// checkExists check if the working file exists
+func checkExists(t *testing.T, filePath string, exists bool) {
+	if _, err := os.Stat(filePath); (err == nil) != exists {
+		t.Fatalf("%s must exist: %v, %v", filePath, exists, err)
+	}
+}
+
+func TestRemoveFile(t *testing.T) {
+	ctx, tree := newTestRepo(t)
+	commitFiles(t, ctx, tree, "one", map[string]string{"a.txt": "a\n", "b.txt": "b\n", "c.txt": "c\n"})
+
+	if err := RemoveFile(ctx, tree, []string{"a.txt"}, false, false); err != nil {
+		t.Fatalf("RemoveFile(a.txt): %s", err)
+	}
+	checkExists(t, "a.txt", false)
+
+	// Local modifications are only removed with force
+	writeFiles(t, map[string]string{"b.txt": "b2\n"})
+	if err := RemoveFile(ctx, tree, []string{"b.txt"}, false, false); err == nil {
+		t.Fatalf("RemoveFile() of a modified file must fail")
+	}
+	checkExists(t, "b.txt", true)
+	// Nothing is removed or staged when one of the files is rejected
+	if err := RemoveFile(ctx, tree, []string{"c.txt", "b.txt"}, false, false); err == nil {
+		t.Fatalf("RemoveFile(c.txt b.txt) with a modified b.txt must fail")
+	}
+	checkExists(t, "c.txt", true)
+	if _, _, commit := reloadRepo(t, ctx); commit.HasFile("c.txt") {
+		t.Fatalf("RemoveFile() rejected must not stage c.txt: %+v", commit.Changes)
+	}
+	if err := RemoveFile(ctx, tree, []string{"b.txt"}, false, true); err != nil {
+		t.Fatalf("RemoveFile(-f b.txt): %s", err)
+	}
+	checkExists(t, "b.txt", false)
+
+	// Staged changes are only removed with force, or kept on disk with cached
+	writeFiles(t, map[string]string{"d.txt": "d\n"})
+	if err := AddFile(ctx, tree, []string{"d.txt"}, ADD_PATHS); err != nil {
+		t.Fatalf("AddFile(): %s", err)
+	}
+	if err := RemoveFile(ctx, tree, []string{"d.txt"}, false, false); err == nil {
+		t.Fatalf("RemoveFile() of a staged file must fail")
+	}
+	if err := RemoveFile(ctx, tree, []string{"d.txt", "c.txt"}, true, false); err != nil {
+		t.Fatalf("RemoveFile(--cached d.txt c.txt): %s", err)
+	}
+	checkExists(t, "c.txt", true)
+	checkExists(t, "d.txt", true)
+
+	_, _, commit := reloadRepo(t, ctx)
+	checkStaged(t, commit, map[string]string{"a.txt": "deleted", "b.txt": "deleted", "c.txt": "deleted", "d.txt": ""})
+	checkTracked(t, ctx)
+	status := getStatus(t, ctx, tree)
+	if len(status.Untracked) != 2 || status.Untracked[0].Path != "c.txt" || status.Untracked[1].Path != "d.txt" {
+		t.Fatalf("Files removed with cached must be untracked: %+v", status.Untracked)
+	}
+
+	for _, args := range [][]string{{"c.txt"}, {"missing.txt"}, {}} {
+		if err := RemoveFile(ctx, tree, args, false, false); err == nil {
+			t.Fatalf("RemoveFile(%v) of an untracked file must fail", args)
+		}
+	}
+}
+
+func TestAddFileModes(t *testing.T) {
+	ctx, tree := newTestRepo(t)
+	commitFiles(t, ctx, tree, "one", map[string]string{"a.txt": "a\n", "b.txt": "b\n", "dir/c.txt": "c\n"})
+	writeFiles(t, map[string]string{"a.txt": "a2\n", "new.txt": "n\n", "dir/new.txt": "n\n"})
+	if err := os.Remove("b.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tracked files only
+	if err := AddFile(ctx, tree, nil, ADD_UPDATE); err != nil {
+		t.Fatalf("AddFile(-u): %s", err)
+	}
+	_, _, commit := reloadRepo(t, ctx)
+	checkStaged(t, commit, map[string]string{"a.txt": "modified", "b.txt": "deleted", "dir/c.txt": "", "new.txt": ""})
+	checkTracked(t, ctx, "a.txt", "dir/c.txt")
+
+	// Every change, new files included
+	if err := AddFile(ctx, tree, []string{"dir"}, ADD_ALL); err != nil {
+		t.Fatalf("AddFile(-A dir): %s", err)
+	}
+	_, _, commit = reloadRepo(t, ctx)
+	checkStaged(t, commit, map[string]string{"dir/new.txt": "new", "new.txt": ""})
+	if err := AddFile(ctx, tree, nil, ADD_ALL); err != nil {
+		t.Fatalf("AddFile(-A): %s", err)
+	}
+	_, _, commit = reloadRepo(t, ctx)
+	checkStaged(t, commit, map[string]string{"a.txt": "modified", "b.txt": "deleted", "new.txt": "new", "dir/new.txt": "new"})
+	checkTracked(t, ctx, "a.txt", "dir/c.txt", "dir/new.txt", "new.txt")
+
+	// Without args, only -u and -A select every file
+	if err := AddFile(ctx, tree, nil, ADD_PATHS); err == nil {
+		t.Fatalf("AddFile() without path must fail")
+	}
+}
+
+func TestAddDeletedFile(t *testing.T) {
+	ctx, tree := newTestRepo(t)
+	commitFiles(t, ctx, tree, "one", map[string]string{"a.txt": "a\n"})
+	if err := os.Remove("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddFile(ctx, tree, []string{"a.txt"}, ADD_PATHS); err != nil {
+		t.Fatalf("AddFile() of a deleted tracked file: %s", err)
+	}
+	_, _, commit := reloadRepo(t, ctx)
+	checkStaged(t, commit, map[string]string{"a.txt": "deleted"})
+	checkTracked(t, ctx)
+	if err := AddFile(ctx, tree, []string{"missing.txt"}, ADD_PATHS); err == nil {
+		t.Fatalf("AddFile() of a missing file must fail")
+	}
+}
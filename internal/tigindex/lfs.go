@@ -0,0 +1,75 @@
+package tigindex
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfile"
+	"tig/internal/tigfs"
+	"tig/internal/tighistory"
+	"tig/internal/tigignore"
+)
+
+// LFSPatterns return the lines of the .tiglfs file of the project, the patterns of the files stored
+// as LFS pointer files
+func LFSPatterns(ctx tigconfig.TigCtx) ([]string, error) {
+	lines, err := tigfile.ReadFileLines(path.Join(ctx.ProjectPath, tigfs.TigLFSFileName), tigfile.MAX_FILE_SIZE)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("LFSPatterns: %w", err)
+	}
+	return lines, nil
+}
+
+// SetLFSTrack add (or remove with untrack) patterns to the .tiglfs file. The files already
+// committed keep their storage until they are added again.
+func SetLFSTrack(ctx tigconfig.TigCtx, patterns []string, untrack bool) error {
+	if _, err := tigignore.Parse(patterns); err != nil {
+		return err
+	}
+	lines, err := LFSPatterns(ctx)
+	if err != nil {
+		return err
+	}
+	for _, pattern := range patterns {
+		if untrack {
+			lines = slices.DeleteFunc(lines, func(line string) bool { return line == pattern })
+		} else if !slices.Contains(lines, pattern) {
+			lines = append(lines, pattern)
+		}
+	}
+	if err := tigfile.WriteFileAtomicLines(path.Join(ctx.ProjectPath, tigfs.TigLFSFileName), lines); err != nil {
+		return fmt.Errorf("SetLFSTrack: %w", err)
+	}
+	return nil
+}
+
+// WriteLFSFiles print the files of HEAD stored as LFS pointer files: short hash, "*" if the object is
+// in the store or "-" if it must be fetched, path
+func WriteLFSFiles(ctx tigconfig.TigCtx, tree *tighistory.TigCommitTree, w io.Writer) error {
+	state := tree.HeadState()
+	paths := make([]string, 0, len(state))
+	for filePath := range state {
+		paths = append(paths, filePath)
+	}
+	slices.Sort(paths)
+	for _, filePath := range paths {
+		pointer, ok, err := state[filePath].LFSPointer()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		present := "-"
+		if object, err := ctx.FS.LFS.Open(pointer.Hash); err == nil {
+			object.Close()
+			present = "*"
+		}
+		fmt.Fprintf(w, "%s %s %s\n", pointer.Hash[:10], present, filePath)
+	}
+	return nil
+}
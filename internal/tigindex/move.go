@@ -0,0 +1,129 @@
+package tigindex
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfile"
+	"tig/internal/tighistory"
+)
+
+// moveChange update the staged change of oldPath after a move to newPath, or stage a rename
+func moveChange(commit *tighistory.TigCommit, headState tighistory.TigTreeState, oldPath string, newPath string) {
+	staged := commit.GetChange(oldPath)
+	if staged == nil {
+		if headSnap, ok := headState[oldPath]; ok {
+			commit.Changes = append(commit.Changes, tighistory.TigChange{
+				Action: tighistory.RENAME, Path: newPath, FileSnapshot: headSnap, OldPath: oldPath})
+		}
+		return
+	}
+	staged.Path = newPath
+	switch staged.Action {
+	case tighistory.MODIFY:
+		staged.Action = tighistory.RENAME
+		staged.OldPath = oldPath
+	case tighistory.RENAME:
+		if newPath != staged.OldPath {
+			break
+		}
+		// Moved back to its original path
+		if headSnap := headState[newPath]; headSnap != nil && headSnap.Hash == staged.FileSnapshot.Hash {
+			commit.Unstage(newPath)
+			return
+		}
+		staged.Action = tighistory.MODIFY
+		staged.OldPath = ""
+	}
+}
+
+// MoveFile move src to dst on disk and in tig, keeping the file history: the files of the FS are moved
+// to their new path with their snapshots.
+// src can be a directory, if dst is an existing directory src is moved inside it.
+// With force, a tracked or existing destination is overwritten.
+func MoveFile(ctx tigconfig.TigCtx, tree *tighistory.TigCommitTree, src string, dst string, force bool) error {
+	src, dst = path.Clean(src), path.Clean(dst)
+	filesMap, commit, err := beforeAddRemoveFile(ctx, []string{src})
+	if err != nil {
+		return fmt.Errorf("MoveFile: %w", err)
+	}
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("MoveFile: %w", err)
+	}
+	if src == "." {
+		return errors.New("Cannot move the project root")
+	}
+	if dstInfo, err := os.Stat(dst); err == nil && dstInfo.IsDir() {
+		dst = path.Join(dst, path.Base(src))
+	}
+	if src == dst || strings.HasPrefix(dst, src+"/") {
+		return fmt.Errorf("Cannot move %s to %s", src, dst)
+	}
+
+	// Tracked files to move: old path -> new path
+	moves := make(map[string]string, 8)
+	if srcInfo.IsDir() {
+		for filePath := range filesMap {
+			if strings.HasPrefix(filePath, src+"/") {
+				moves[filePath] = dst + filePath[len(src):]
+			}
+		}
+		if len(moves) == 0 {
+			return fmt.Errorf("No tracked file in %s", src)
+		}
+	} else {
+		if !filesMap[src] {
+			return errors.New("Tig don't know about " + src)
+		}
+		moves[src] = dst
+	}
+	if !force {
+		if _, err := os.Stat(dst); err == nil {
+			return fmt.Errorf("Destination %s already exists, use -f to overwrite it", dst)
+		}
+		for _, newPath := range moves {
+			if filesMap[newPath] {
+				return fmt.Errorf("Destination %s is already tracked, use -f to overwrite it", newPath)
+			}
+		}
+	}
+
+	if dir := path.Dir(dst); dir != "." {
+		if err := os.MkdirAll(dir, tigfile.DIR_PERM); err != nil {
+			return fmt.Errorf("MoveFile: %w", err)
+		}
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return fmt.Errorf("MoveFile: %w", err)
+	}
+
+	headState := tree.HeadState()
+	oldPaths := make([]string, 0, len(moves))
+	for oldPath := range moves {
+		oldPaths = append(oldPaths, oldPath)
+	}
+	slices.Sort(oldPaths)
+	for _, oldPath := range oldPaths {
+		newPath := moves[oldPath]
+		if commit.HasFile(newPath) {
+			// Overwritten with force, its staged change is lost
+			commit.Unstage(newPath)
+		}
+		moveChange(commit, headState, oldPath, newPath)
+		if err := ctx.FS.Move(oldPath, newPath); err != nil {
+			return fmt.Errorf("MoveFile: %w", err)
+		}
+		delete(filesMap, oldPath)
+		filesMap[newPath] = true
+	}
+	err = afterAddRemoveFile(ctx, commit, filesMap)
+	if err != nil {
+		return fmt.Errorf("MoveFile: %w", err)
+	}
+	return nil
+}
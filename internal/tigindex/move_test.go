@@ -0,0 +1,212 @@
+package tigindex
+
+import (
+	"bytes"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfile"
+	"tig/internal/tighistory"
+)
+
+// reloadRepo load the repository again from disk, every change must resolve in the saved FS
+func reloadRepo(t *testing.T, ctx tigconfig.TigCtx) (tigconfig.TigCtx, *tighistory.TigCommitTree, *tighistory.TigCommit) {
+	if err := ctx.LoadFS(); err != nil {
+		t.Fatalf("LoadFS(): %s", err)
+	}
+	tree, err := tighistory.LoadCommits(ctx)
+	if err != nil {
+		t.Fatalf("LoadCommits(): %s", err)
+	}
+	commit, err := tighistory.GetCurrentCommit(ctx)
+	if err != nil {
+		t.Fatalf("GetCurrentCommit(): %s", err)
+	}
+	return ctx, tree, commit
+}
+
+// checkContent check the content of a working file
+func checkContent(t *testing.T, filePath string, expected string) {
+	content, err := tigfile.ReadFileBytes(filePath, -1)
+	if err != nil || string(content) != expected {
+		t.Fatalf("%s must contain %q, not %q (%v)", filePath, expected, content, err)
+	}
+}
+
+func TestMoveFile(t *testing.T) {
+	ctx, tree := newTestRepo(t)
+	commitFiles(t, ctx, tree, "one", map[string]string{"a.txt": "a\n", "b.txt": "b\n"})
+	one := tree.Head
+
+	if err := MoveFile(ctx, tree, "a.txt", "c.txt", false); err != nil {
+		t.Fatalf("MoveFile(a.txt, c.txt): %s", err)
+	}
+	if _, err := os.Stat("a.txt"); err == nil {
+		t.Fatalf("a.txt must be moved")
+	}
+	checkContent(t, "c.txt", "a\n")
+	ctx, tree, commit := reloadRepo(t, ctx)
+	if change := commit.GetChange("c.txt"); change == nil || change.Action != tighistory.RENAME || change.OldPath != "a.txt" {
+		t.Fatalf("MoveFile() must stage the rename of a.txt to c.txt: %+v", commit.Changes)
+	}
+	if tracked, _ := GetTrackedFiles(ctx); !slices.Contains(tracked, "c.txt") || slices.Contains(tracked, "a.txt") {
+		t.Fatalf("c.txt must be tracked instead of a.txt: %v", tracked)
+	}
+	if err := tighistory.Commit(ctx, tree, "two"); err != nil {
+		t.Fatalf("Commit(): %s", err)
+	}
+
+	// Moved again once modified, the older commits still resolve
+	writeFiles(t, map[string]string{"c.txt": "a\nc\n"})
+	if err := AddFile(ctx, tree, []string{"c.txt"}, ADD_PATHS); err != nil {
+		t.Fatalf("AddFile(): %s", err)
+	}
+	if err := MoveFile(ctx, tree, "c.txt", "d.txt", false); err != nil {
+		t.Fatalf("MoveFile(c.txt, d.txt): %s", err)
+	}
+	ctx, tree, commit = reloadRepo(t, ctx)
+	if change := commit.GetChange("d.txt"); change == nil || change.Action != tighistory.RENAME ||
+		change.OldPath != "c.txt" || change.FileSnapshot.Hash != tigfile.HashBytes([]byte("a\nc\n")) {
+		t.Fatalf("MoveFile() must stage the modified c.txt as renamed to d.txt: %+v", commit.Changes)
+	}
+	if snapshot := tree.State(tree.Get(one.Value.Id))["a.txt"]; snapshot == nil || snapshot.Hash != tigfile.HashBytes([]byte("a\n")) {
+		t.Fatalf("a.txt of the first commit must resolve to its own snapshot: %v", snapshot)
+	}
+
+	// The snapshot of d.txt is stored under c.txt, it is linked to the new path
+	if err := tighistory.Commit(ctx, tree, "three"); err != nil {
+		t.Fatalf("Commit(): %s", err)
+	}
+	if err := MoveFile(ctx, tree, "d.txt", "e.txt", false); err != nil {
+		t.Fatalf("MoveFile(d.txt, e.txt): %s", err)
+	}
+	ctx, tree, commit = reloadRepo(t, ctx)
+	if change := commit.GetChange("e.txt"); change == nil || change.OldPath != "d.txt" || change.FileSnapshot.File.Path != "e.txt" {
+		t.Fatalf("MoveFile() must stage the rename of d.txt to e.txt: %+v", commit.Changes)
+	}
+
+	// A new file is moved with its staged content
+	writeFiles(t, map[string]string{"n.txt": "n\n"})
+	if err := AddFile(ctx, tree, []string{"n.txt"}, ADD_PATHS); err != nil {
+		t.Fatalf("AddFile(): %s", err)
+	}
+	if err := MoveFile(ctx, tree, "n.txt", "m.txt", false); err != nil {
+		t.Fatalf("MoveFile(n.txt, m.txt): %s", err)
+	}
+	_, _, commit = reloadRepo(t, ctx)
+	if change := commit.GetChange("m.txt"); change == nil || change.Action != tighistory.ADD || commit.HasFile("n.txt") {
+		t.Fatalf("MoveFile() must stage m.txt as a new file: %+v", commit.Changes)
+	}
+}
+
+func TestMoveFileRename(t *testing.T) {
+	ctx, tree := newTestRepo(t)
+	commitFiles(t, ctx, tree, "one", map[string]string{"f.txt": "f\n"})
+	one := tree.HeadId()
+
+	// A pure rename moves the file of the FS with its snapshots
+	if err := MoveFile(ctx, tree, "f.txt", "g.txt", false); err != nil {
+		t.Fatalf("MoveFile(f.txt, g.txt): %s", err)
+	}
+	if err := tighistory.Commit(ctx, tree, "two"); err != nil {
+		t.Fatalf("Commit(): %s", err)
+	}
+	ctx, tree, _ = reloadRepo(t, ctx)
+	two := tree.HeadId()
+	if _, ok := ctx.FS.Get("f.txt"); ok {
+		t.Fatalf("MoveFile() must move f.txt in the FS")
+	}
+	hash := tigfile.HashBytes([]byte("f\n"))
+	if file, ok := ctx.FS.Get("g.txt"); !ok || file.Head.Hash != hash {
+		t.Fatalf("MoveFile() must keep the snapshot %s under g.txt: %v", hash, file)
+	}
+	if snapshot := tree.State(tree.Get(one))["f.txt"]; snapshot == nil || snapshot.Hash != hash {
+		t.Fatalf("f.txt of the first commit must resolve to its snapshot: %v", snapshot)
+	}
+
+	for _, test := range []struct {
+		filepath string
+		follow   bool
+		expected []string
+	}{
+		{"g.txt", false, []string{two}},
+		{"g.txt", true, []string{two, one}},
+		{"f.txt", false, []string{two, one}},
+	} {
+		var out bytes.Buffer
+		if err := tighistory.Log(ctx, tree, test.filepath, test.follow, false, &out); err != nil {
+			t.Fatalf("Log(%s, follow %v): %s", test.filepath, test.follow, err)
+		}
+		var ids []string
+		for _, line := range strings.Split(out.String(), "\n") {
+			if id, ok := strings.CutPrefix(line, "commit "); ok {
+				ids = append(ids, id)
+			}
+		}
+		if !slices.Equal(ids, test.expected) {
+			t.Fatalf("Log(%s, follow %v) must write the commits %v, not %v", test.filepath, test.follow, test.expected, ids)
+		}
+	}
+}
+
+func TestMoveFileErrors(t *testing.T) {
+	ctx, tree := newTestRepo(t)
+	commitFiles(t, ctx, tree, "one", map[string]string{"a.txt": "a\n", "b.txt": "b\n"})
+	writeFiles(t, map[string]string{"untracked.txt": "u\n"})
+
+	for _, args := range [][2]string{{"missing.txt", "c.txt"}, {"untracked.txt", "c.txt"}, {"a.txt", "a.txt"},
+		{"a.txt", "b.txt"}, {"a.txt", "untracked.txt"}, {".", "c"}} {
+		if err := MoveFile(ctx, tree, args[0], args[1], false); err == nil {
+			t.Fatalf("MoveFile(%s, %s) must fail", args[0], args[1])
+		}
+	}
+	checkContent(t, "a.txt", "a\n")
+	checkContent(t, "b.txt", "b\n")
+
+	// With force, the tracked destination is overwritten
+	if err := MoveFile(ctx, tree, "a.txt", "b.txt", true); err != nil {
+		t.Fatalf("MoveFile(-f a.txt, b.txt): %s", err)
+	}
+	checkContent(t, "b.txt", "a\n")
+	_, _, commit := reloadRepo(t, ctx)
+	if change := commit.GetChange("b.txt"); change == nil || change.Action != tighistory.RENAME || change.OldPath != "a.txt" {
+		t.Fatalf("MoveFile(-f) must stage the rename of a.txt to b.txt: %+v", commit.Changes)
+	}
+}
+
+func TestMoveDirectory(t *testing.T) {
+	ctx, tree := newTestRepo(t)
+	commitFiles(t, ctx, tree, "one", map[string]string{"dir/a.txt": "a\n", "dir/sub/b.txt": "b\n", "other/c.txt": "c\n"})
+	writeFiles(t, map[string]string{"dir/untracked.txt": "u\n"})
+
+	if err := MoveFile(ctx, tree, "dir", "new", false); err != nil {
+		t.Fatalf("MoveFile(dir, new): %s", err)
+	}
+	checkContent(t, "new/a.txt", "a\n")
+	checkContent(t, "new/sub/b.txt", "b\n")
+	checkContent(t, "new/untracked.txt", "u\n")
+	ctx, tree, commit := reloadRepo(t, ctx)
+	for oldPath, newPath := range map[string]string{"dir/a.txt": "new/a.txt", "dir/sub/b.txt": "new/sub/b.txt"} {
+		if change := commit.GetChange(newPath); change == nil || change.Action != tighistory.RENAME || change.OldPath != oldPath {
+			t.Fatalf("MoveFile(dir) must stage the rename of %s to %s: %+v", oldPath, newPath, commit.Changes)
+		}
+	}
+	if commit.HasFile("new/untracked.txt") {
+		t.Fatalf("MoveFile(dir) must not stage the untracked files: %+v", commit.Changes)
+	}
+
+	// An existing directory destination receives the source
+	if err := MoveFile(ctx, tree, "new", "other", false); err != nil {
+		t.Fatalf("MoveFile(new, other): %s", err)
+	}
+	checkContent(t, "other/new/a.txt", "a\n")
+	_, _, commit = reloadRepo(t, ctx)
+	if change := commit.GetChange("other/new/sub/b.txt"); change == nil || change.OldPath != "dir/sub/b.txt" {
+		t.Fatalf("MoveFile(new, other) must keep the rename from dir/sub/b.txt: %+v", commit.Changes)
+	}
+	if err := MoveFile(ctx, tree, "other", "other/new/inside", false); err == nil {
+		t.Fatalf("MoveFile() of a directory inside itself must fail")
+	}
+}
@@ -0,0 +1,313 @@
+package tigindex
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strings"
+	"tig/internal/tigconfig"
+	"tig/internal/tigdiff"
+	"tig/internal/tigfile"
+	"tig/internal/tighistory"
+)
+
+// TigHunkEditFileName path relative to TigRootPath, the hunk opened in the editor by "e"
+const TigHunkEditFileName = "addp-hunk-edit.diff"
+
+const patchHelp = `y - stage this hunk
+n - do not stage this hunk
+s - split the current hunk into smaller hunks
+e - manually edit the current hunk
+q - quit; do not stage this hunk or any of the remaining ones
+? - print help`
+
+const hunkEditHelp = `# Manual hunk edit mode, the hunk above will be staged as edited.
+# To remove '-' lines, make them ' ' lines (context).
+# To remove '+' lines, delete them.
+# Lines starting with # will be removed.`
+
+// ErrQuitPatch is returned when the user stops the hunk selection
+var ErrQuitPatch = errors.New("Quit")
+
+// patchHunk is a hunk of the working file with the lines to stage in its place
+type patchHunk struct {
+	hunk   tigdiff.Hunk
+	staged []string // nil if not staged, lines keep their line ending, see [tigdiff.RawLines]
+}
+
+// AddPatch interactively stage the hunks of the tracked files selected by the pathspecs args.
+// The content staged is built from the accepted hunks, the working files are left untouched.
+// Answers are read from in, hunks are written to out.
+func AddPatch(ctx tigconfig.TigCtx, tree *tighistory.TigCommitTree, args []string, in io.Reader, out io.Writer) error {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	filesMap, commit, err := beforeAddRemoveFile(ctx, args)
+	if err != nil {
+		return fmt.Errorf("AddPatch: %w", err)
+	}
+	fileList, err := expandPathspec(ctx, args, filesMap, false)
+	if err != nil {
+		return fmt.Errorf("AddPatch: %w", err)
+	}
+	headState := tree.HeadState()
+	reader := bufio.NewReader(in)
+	for _, file := range fileList {
+		if _, err = os.Stat(file); errors.Is(err, os.ErrNotExist) {
+			err = addDeletePatch(commit, headState, filesMap, file, reader, out)
+		} else {
+			err = addFilePatch(ctx, commit, headState, file, reader, out)
+		}
+		if errors.Is(err, ErrQuitPatch) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("AddPatch: %w", err)
+		}
+	}
+	err = afterAddRemoveFile(ctx, commit, filesMap)
+	if err != nil {
+		return fmt.Errorf("AddPatch: %w", err)
+	}
+	return nil
+}
+
+// prompt print question and return the first character of the answer, 'q' at end of input
+func prompt(reader *bufio.Reader, out io.Writer, question string) byte {
+	fmt.Fprint(out, question)
+	line, err := reader.ReadString('\n')
+	line = strings.TrimSpace(line)
+	if len(line) == 0 {
+		if err != nil {
+			fmt.Fprintln(out)
+			return 'q'
+		}
+		return 0
+	}
+	return line[0]
+}
+
+// addDeletePatch ask to stage the deletion of file, the whole file is a single hunk
+func addDeletePatch(commit *tighistory.TigCommit, headState tighistory.TigTreeState, filesMap map[string]bool,
+	file string, reader *bufio.Reader, out io.Writer) error {
+	fmt.Fprintf(out, "diff --tig a/%s b/%s\ndeleted file\n", file, file)
+	for {
+		switch prompt(reader, out, "Stage deletion [y,n,q,?]? ") {
+		case 'y':
+			commit.StageDelete(headState, file)
+			delete(filesMap, file)
+			return nil
+		case 'n':
+			return nil
+		case 'q':
+			return ErrQuitPatch
+		default:
+			fmt.Fprintln(out, "y - stage this deletion\nn - do not stage this deletion\nq - quit")
+		}
+	}
+}
+
+// addFilePatch ask for each hunk of file, then stage the content built from the accepted ones
+func addFilePatch(ctx tigconfig.TigCtx, commit *tighistory.TigCommit, headState tighistory.TigTreeState,
+	file string, reader *bufio.Reader, out io.Writer) error {
+	newData, newBinary, err := tigfile.ReadFileText(file)
+	if err != nil {
+		return err
+	}
+	var oldData []byte
+	var oldBinary bool
+	snapshot := IndexSnapshot(commit, headState, file)
+	if snapshot != nil {
+		if oldData, oldBinary, err = snapshot.ReadText(); err != nil {
+			return err
+		}
+	}
+	// The hunks of a file tracked by the LFS would be applied to its pointer file
+	if oldBinary || newBinary || ctx.FS.LFS.Tracks(file) {
+		changed, err := ctx.FS.HasChanged(file, snapshot)
+		if err != nil {
+			return err
+		}
+		if changed {
+			fmt.Fprintf(out, "Binary file %s not staged, use tig add\n", file)
+		}
+		return nil
+	}
+	oldLines, newLines := tigdiff.Lines(oldData), tigdiff.Lines(newData)
+	oldRaw, newRaw := tigdiff.RawLines(oldData), tigdiff.RawLines(newData)
+	var hunks []patchHunk
+	for _, hunk := range tigdiff.Hunks(tigdiff.Diff(oldLines, newLines), tigdiff.DEFAULT_CONTEXT) {
+		hunks = append(hunks, patchHunk{hunk: hunk})
+	}
+	if len(hunks) == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(out, "diff --tig a/%s b/%s\n--- a/%s\n+++ b/%s\n", file, file, file, file)
+	var quit bool
+	for i := 0; i < len(hunks) && !quit; {
+		tigdiff.WriteHunk(out, hunks[i].hunk)
+		choices := "y,n,e,q,?"
+		split := tigdiff.SplitHunk(hunks[i].hunk)
+		if len(split) > 1 {
+			choices = "y,n,s,e,q,?"
+		}
+		question := fmt.Sprintf("(%d/%d) Stage this hunk [%s]? ", i+1, len(hunks), choices)
+		switch prompt(reader, out, question) {
+		case 'y':
+			hunk := hunks[i].hunk
+			hunks[i].staged = newRaw[hunk.NewPos() : hunk.NewPos()+hunk.NewLines]
+			i++
+		case 'n':
+			i++
+		case 's':
+			if len(split) < 2 {
+				fmt.Fprintln(out, "Sorry, cannot split this hunk")
+				continue
+			}
+			fmt.Fprintf(out, "Split into %d hunks.\n", len(split))
+			parts := make([]patchHunk, len(split))
+			for k, hunk := range split {
+				parts[k] = patchHunk{hunk: hunk}
+			}
+			hunks = slices.Replace(hunks, i, i+1, parts...)
+		case 'e':
+			lines, err := editHunk(ctx, hunks[i].hunk)
+			if err != nil {
+				fmt.Fprintln(out, err)
+				continue
+			}
+			hunks[i].staged = editedLines(lines, hunks[i].hunk, newRaw)
+			i++
+		case 'q':
+			quit = true
+		default:
+			fmt.Fprintln(out, patchHelp)
+		}
+	}
+
+	// Rebuild the index content, with the staged hunks in place of their old lines
+	var data []byte
+	oldPos := 0
+	for _, h := range hunks {
+		end := h.hunk.OldPos() + h.hunk.OldLines
+		data = appendLines(data, oldRaw[oldPos:h.hunk.OldPos()])
+		if h.staged != nil {
+			data = appendLines(data, h.staged)
+		} else {
+			data = appendLines(data, oldRaw[h.hunk.OldPos():end])
+		}
+		oldPos = end
+	}
+	data = appendLines(data, oldRaw[oldPos:])
+	err = stageData(ctx, commit, headState, file, data, oldData)
+	if err == nil && quit {
+		err = ErrQuitPatch
+	}
+	return err
+}
+
+// lineEnding return the line ending of the first line, "\n" by default
+func lineEnding(rawLines []string) string {
+	if len(rawLines) > 0 && strings.HasSuffix(rawLines[0], "\r\n") {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// editedLines add the line endings of the working file to the lines of an edited hunk.
+// At the end of a working file without final line ending, the last line has none either.
+func editedLines(lines []string, hunk tigdiff.Hunk, newRaw []string) []string {
+	eol := lineEnding(newRaw)
+	raw := make([]string, len(lines))
+	for i, line := range lines {
+		raw[i] = line + eol
+	}
+	if last := len(newRaw) - 1; len(raw) > 0 && hunk.NewPos()+hunk.NewLines > last &&
+		!strings.HasSuffix(newRaw[last], "\n") {
+		raw[len(raw)-1] = lines[len(lines)-1]
+	}
+	return raw
+}
+
+// appendLines append lines with their line ending to data. A last line of data without
+// line ending gets one if lines follow it.
+func appendLines(data []byte, rawLines []string) []byte {
+	if len(rawLines) > 0 && len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, lineEnding(rawLines)...)
+	}
+	for _, line := range rawLines {
+		data = append(data, line...)
+	}
+	return data
+}
+
+// stageData stage data as the content of file, nothing is staged if it is the old content
+func stageData(ctx tigconfig.TigCtx, commit *tighistory.TigCommit, headState tighistory.TigTreeState,
+	file string, data []byte, oldData []byte) error {
+	if bytes.Equal(data, oldData) {
+		return nil // Nothing staged
+	}
+	hash := tigfile.HashBytes(data)
+	if headSnap := headState[file]; headSnap != nil && headSnap.Hash == hash {
+		// Back to the HEAD content
+		return commit.Unstage(file)
+	}
+	return commit.StageContent(ctx, headState, file, data)
+}
+
+// editHunk open the hunk in the editor and return the lines to stage in its place
+func editHunk(ctx tigconfig.TigCtx, hunk tigdiff.Hunk) ([]string, error) {
+	editPath := path.Join(ctx.TigPath, TigHunkEditFileName)
+	var builder strings.Builder
+	tigdiff.WriteHunk(&builder, hunk)
+	builder.WriteString(hunkEditHelp + "\n")
+	if err := tigfile.WriteFileString(editPath, builder.String()); err != nil {
+		return nil, err
+	}
+	defer os.Remove(editPath)
+
+	editor := ctx.GetConfig("core.editor", os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = "vi"
+	}
+	cmd := exec.Command("sh", "-c", editor+` "$@"`, editor, editPath)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("Cannot run editor %s: %w", editor, err)
+	}
+	edited, err := tigfile.ReadFileBytes(editPath, tigfile.MAX_FILE_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	oldLines, newLines := []string{}, []string{}
+	for _, line := range tigdiff.Lines(edited) {
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "@@") {
+			continue
+		}
+		if len(line) == 0 {
+			line = " " // Editors often strip the space of empty context lines
+		}
+		switch line[0] {
+		case ' ':
+			oldLines = append(oldLines, line[1:])
+			newLines = append(newLines, line[1:])
+		case '-':
+			oldLines = append(oldLines, line[1:])
+		case '+':
+			newLines = append(newLines, line[1:])
+		default:
+			return nil, fmt.Errorf("Your edited hunk does not apply: bad line %q", line)
+		}
+	}
+	if !slices.Equal(oldLines, hunk.OldText()) {
+		return nil, errors.New("Your edited hunk does not apply: the context and '-' lines were changed")
+	}
+	return newLines, nil
+}
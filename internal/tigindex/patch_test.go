@@ -0,0 +1,105 @@
+package tigindex
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"tig/internal/tigconfig"
+	"tig/internal/tighistory"
+)
+
+// numberLines return the lines from..to, each one ended by eol
+func numberLines(from int, to int, eol string) string {
+	var builder strings.Builder
+	for i := from; i <= to; i++ {
+		builder.WriteString(strings.Repeat("x", i) + eol)
+	}
+	return builder.String()
+}
+
+// addPatch run [AddPatch] with the answers, and return the content staged for each file
+func addPatch(t *testing.T, ctx tigconfig.TigCtx, tree *tighistory.TigCommitTree, answers string, files ...string) map[string]string {
+	var out bytes.Buffer
+	if err := AddPatch(ctx, tree, files, strings.NewReader(answers), &out); err != nil {
+		t.Fatalf("AddPatch(%q): %s\n%s", answers, err, out.String())
+	}
+	_, _, commit := reloadRepo(t, ctx)
+	staged := make(map[string]string, len(commit.Changes))
+	for _, change := range commit.Changes {
+		data, err := change.FileSnapshot.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		staged[change.Path] = string(data)
+	}
+	return staged
+}
+
+func TestAddPatch(t *testing.T) {
+	ctx, tree := newTestRepo(t)
+	commitFiles(t, ctx, tree, "one", map[string]string{"a.txt": numberLines(1, 10, "\n"), "b.txt": "b\n"})
+	writeFiles(t, map[string]string{"a.txt": "first\n" + numberLines(2, 9, "\n") + "last\n"})
+	if err := os.Remove("b.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the first hunk, and the deletion
+	staged := addPatch(t, ctx, tree, "y\nn\ny\n", "a.txt", "b.txt")
+	if expected := "first\n" + numberLines(2, 10, "\n"); staged["a.txt"] != expected {
+		t.Fatalf("AddPatch(y, n) must stage %q, not %q", expected, staged["a.txt"])
+	}
+	if _, ok := staged["b.txt"]; !ok {
+		t.Fatalf("AddPatch(y) must stage the deletion of b.txt: %v", staged)
+	}
+	// The second hunk is on top of the staged content, after the help
+	staged = addPatch(t, ctx, tree, "?\ny\n", "a.txt")
+	if expected := "first\n" + numberLines(2, 9, "\n") + "last\n"; staged["a.txt"] != expected {
+		t.Fatalf("AddPatch(y) must stage %q, not %q", expected, staged["a.txt"])
+	}
+	if staged = addPatch(t, ctx, tree, "", "a.txt"); len(staged["a.txt"]) == 0 {
+		t.Fatalf("AddPatch() without change must keep the staged content")
+	}
+}
+
+func TestAddPatchSplit(t *testing.T) {
+	ctx, tree := newTestRepo(t)
+	commitFiles(t, ctx, tree, "one", map[string]string{"a.txt": "1\n2\n3\n4\n5\n"})
+	writeFiles(t, map[string]string{"a.txt": "1\nb\n3\nd\n5\n"})
+	staged := addPatch(t, ctx, tree, "s\nn\ny\n", "a.txt")
+	if staged["a.txt"] != "1\n2\n3\nd\n5\n" {
+		t.Fatalf("AddPatch(s, n, y) must stage the second change only, not %q", staged["a.txt"])
+	}
+	if staged = addPatch(t, ctx, tree, "q\n", "a.txt"); staged["a.txt"] != "1\n2\n3\nd\n5\n" {
+		t.Fatalf("AddPatch(q) must not stage anything more, not %q", staged["a.txt"])
+	}
+}
+
+func TestAddPatchLineEndings(t *testing.T) {
+	ctx, tree := newTestRepo(t)
+	commitFiles(t, ctx, tree, "one", map[string]string{
+		"crlf.txt":  numberLines(1, 10, "\r\n"),
+		"noeol.txt": strings.TrimSuffix(numberLines(1, 10, "\n"), "\n"),
+	})
+	writeFiles(t, map[string]string{
+		"crlf.txt":  "first\r\n" + numberLines(2, 9, "\r\n") + "last\r\n",
+		"noeol.txt": "first\n" + numberLines(2, 10, "\n") + "end",
+	})
+
+	// CRLF are kept, and the missing final line ending
+	staged := addPatch(t, ctx, tree, "y\nn\nn\ny\n", "crlf.txt", "noeol.txt")
+	if expected := "first\r\n" + numberLines(2, 10, "\r\n"); staged["crlf.txt"] != expected {
+		t.Fatalf("AddPatch() must stage %q, not %q", expected, staged["crlf.txt"])
+	}
+	if expected := numberLines(1, 10, "\n") + "end"; staged["noeol.txt"] != expected {
+		t.Fatalf("AddPatch() must stage %q, not %q", expected, staged["noeol.txt"])
+	}
+
+	// Without the last hunk, the old content keeps no final line ending
+	commitFiles(t, ctx, tree, "two", map[string]string{"noeol.txt": strings.TrimSuffix(numberLines(1, 10, "\n"), "\n")})
+	writeFiles(t, map[string]string{"noeol.txt": "first\n" + numberLines(2, 10, "\n")})
+	staged = addPatch(t, ctx, tree, "y\nn\n", "noeol.txt")
+	if expected := "first\n" + strings.TrimSuffix(numberLines(2, 10, "\n"), "\n"); staged["noeol.txt"] != expected {
+		t.Fatalf("AddPatch() must stage %q, not %q", expected, staged["noeol.txt"])
+	}
+}
@@ -0,0 +1,174 @@
+package tigindex
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfile"
+	"tig/internal/tigignore"
+)
+
+// Prefixes of an exclude pathspec: ":(exclude)x", ":!x" or ":^x"
+var excludeMagics = []string{":(exclude)", ":!", ":^"}
+
+type pathspecItem struct {
+	raw     string
+	literal string         // Path or directory, if not a glob
+	re      *regexp.Regexp // Glob, '*' also matches '/'
+	matched bool
+}
+
+// Pathspec select files by path, directory or glob, with exclusions
+type Pathspec struct {
+	includes []*pathspecItem
+	excludes []*pathspecItem
+}
+
+func newPathspecItem(raw string, spec string) (*pathspecItem, error) {
+	item := &pathspecItem{raw: raw}
+	if !strings.ContainsAny(spec, "*?[") {
+		item.literal = path.Clean(spec)
+		return item, nil
+	}
+	re, err := regexp.Compile("^" + tigignore.GlobToRegexp(path.Clean(spec), false) + "$")
+	if err != nil {
+		return nil, fmt.Errorf("Bad pathspec %s: %w", raw, err)
+	}
+	item.re = re
+	return item, nil
+}
+
+// ParsePathspec parse the pathspec arguments. With only exclusions, every file is included.
+func ParsePathspec(args []string) (*Pathspec, error) {
+	pathspec := &Pathspec{}
+	for _, arg := range args {
+		spec, exclude := arg, false
+		for _, magic := range excludeMagics {
+			if strings.HasPrefix(arg, magic) {
+				spec, exclude = arg[len(magic):], true
+				break
+			}
+		}
+		item, err := newPathspecItem(arg, spec)
+		if err != nil {
+			return nil, err
+		}
+		if exclude {
+			pathspec.excludes = append(pathspec.excludes, item)
+		} else {
+			pathspec.includes = append(pathspec.includes, item)
+		}
+	}
+	if len(pathspec.includes) == 0 {
+		pathspec.includes = append(pathspec.includes, &pathspecItem{raw: ".", literal: "."})
+	}
+	return pathspec, nil
+}
+
+func (item *pathspecItem) match(filepath string) bool {
+	if item.re != nil {
+		return item.re.MatchString(filepath)
+	}
+	return item.literal == "." || filepath == item.literal || strings.HasPrefix(filepath, item.literal+"/")
+}
+
+// Match return true if filepath is selected by the pathspec
+func (pathspec *Pathspec) Match(filepath string) bool {
+	for _, item := range pathspec.excludes {
+		if item.match(filepath) {
+			return false
+		}
+	}
+	matched := false
+	for _, item := range pathspec.includes {
+		if item.match(filepath) {
+			item.matched = true
+			matched = true
+		}
+	}
+	return matched
+}
+
+// Filter return the files of fileList selected by the pathspec
+func (pathspec *Pathspec) Filter(fileList []string) []string {
+	var selected []string
+	for _, filePath := range fileList {
+		if pathspec.Match(filePath) {
+			selected = append(selected, filePath)
+		}
+	}
+	return selected
+}
+
+// Unmatched return the include pathspecs which did not match any file in [Pathspec.Match]
+func (pathspec *Pathspec) Unmatched() []string {
+	var unmatched []string
+	for _, item := range pathspec.includes {
+		if !item.matched {
+			unmatched = append(unmatched, item.raw)
+		}
+	}
+	return unmatched
+}
+
+// WorkingTreeFiles return the files of the working tree which are tracked or not ignored, sorted
+func WorkingTreeFiles(ctx tigconfig.TigCtx, ignore *tigignore.TigIgnore, tracked map[string]bool) ([]string, error) {
+	// Ignored directories are still walked if they contain tracked files
+	trackedDirs := make(map[string]bool, len(tracked))
+	for filePath := range tracked {
+		for dir := path.Dir(filePath); dir != "."; dir = path.Dir(dir) {
+			trackedDirs[dir] = true
+		}
+	}
+	return tigfile.WalkDir(".", ctx.Workers(), func(filepath string, isDir bool) bool {
+		if tracked[filepath] || (isDir && trackedDirs[filepath]) {
+			return false
+		}
+		return ignore.Match(filepath, isDir)
+	})
+}
+
+// expandPathspec return the files selected by args among the tracked files, and the
+// working tree files if withUntracked. Pathspecs which match nothing are an error.
+func expandPathspec(ctx tigconfig.TigCtx, args []string, tracked map[string]bool, withUntracked bool) ([]string, error) {
+	pathspec, err := ParsePathspec(args)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]string, 0, len(tracked))
+	for filePath := range tracked {
+		candidates = append(candidates, filePath)
+	}
+	var ignore *tigignore.TigIgnore
+	if withUntracked {
+		ignore, err = tigignore.Load(ctx.ProjectPath)
+		if err != nil {
+			return nil, err
+		}
+		cwdFileList, err := WorkingTreeFiles(ctx, ignore, tracked)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, cwdFileList...)
+	}
+	slices.Sort(candidates)
+	fileList := pathspec.Filter(slices.Compact(candidates))
+
+	for _, raw := range pathspec.Unmatched() {
+		if ignore != nil {
+			if info, err := os.Stat(raw); err == nil && ignore.Match(raw, info.IsDir()) {
+				return nil, fmt.Errorf("The path %s is ignored by %s", raw, tigignore.TigIgnoreFileName)
+			}
+		}
+		return nil, fmt.Errorf("Pathspec %s did not match any files", raw)
+	}
+	if len(fileList) == 0 {
+		return nil, errors.New("No file to process")
+	}
+	return fileList, nil
+}
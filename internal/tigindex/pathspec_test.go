@@ -0,0 +1,97 @@
+package tigindex
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPathspecFilter(t *testing.T) {
+	files := []string{"a.txt", "b.go", "dir/c.txt", "dir/sub/d.go", "dirx/e.txt"}
+	for _, test := range []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"."}, files},
+		{[]string{"a.txt"}, []string{"a.txt"}},
+		{[]string{"./dir/"}, []string{"dir/c.txt", "dir/sub/d.go"}},
+		{[]string{"dir/sub"}, []string{"dir/sub/d.go"}},
+		{[]string{"*.txt"}, []string{"a.txt", "dir/c.txt", "dirx/e.txt"}},
+		{[]string{"dir/*.go"}, []string{"dir/sub/d.go"}},
+		{[]string{"?.go"}, []string{"b.go"}},
+		{[]string{"[ab].*"}, []string{"a.txt", "b.go"}},
+		{[]string{"dir", "b.go"}, []string{"b.go", "dir/c.txt", "dir/sub/d.go"}},
+		{[]string{":(exclude)dir"}, []string{"a.txt", "b.go", "dirx/e.txt"}},
+		{[]string{"dir", ":!*.go"}, []string{"dir/c.txt"}},
+		{[]string{"*.txt", ":^dirx"}, []string{"a.txt", "dir/c.txt"}},
+	} {
+		pathspec, err := ParsePathspec(test.args)
+		if err != nil {
+			t.Fatalf("ParsePathspec(%v): %s", test.args, err)
+		}
+		if selected := pathspec.Filter(files); !slices.Equal(selected, test.expected) {
+			t.Fatalf("Pathspec %v must select %v, not %v", test.args, test.expected, selected)
+		}
+		if unmatched := pathspec.Unmatched(); len(unmatched) > 0 {
+			t.Fatalf("Pathspec %v must match every include, not %v", test.args, unmatched)
+		}
+	}
+
+	pathspec, err := ParsePathspec([]string{"a.txt", "missing", "*.c", ":!a.txt"})
+	if err != nil {
+		t.Fatalf("ParsePathspec(): %s", err)
+	}
+	if selected := pathspec.Filter(files); len(selected) != 0 {
+		t.Fatalf("Excluded files must not be selected: %v", selected)
+	}
+	if unmatched := pathspec.Unmatched(); !slices.Equal(unmatched, []string{"a.txt", "missing", "*.c"}) {
+		t.Fatalf("Unmatched() must return the includes matching no file, not %v", unmatched)
+	}
+}
+
+func TestExpandPathspec(t *testing.T) {
+	ctx, tree := newTestRepo(t)
+	commitFiles(t, ctx, tree, "one", map[string]string{"a.txt": "a\n", "dir/b.txt": "b\n", "dir/c.go": "c\n"})
+	writeFiles(t, map[string]string{"dir/new.txt": "n\n", "new.go": "n\n", "out.log": "l\n",
+		".tigignore": "*.log\n"})
+	tracked := map[string]bool{"a.txt": true, "dir/b.txt": true, "dir/c.go": true}
+
+	for _, test := range []struct {
+		args          []string
+		withUntracked bool
+		expected      []string
+	}{
+		{[]string{"dir"}, false, []string{"dir/b.txt", "dir/c.go"}},
+		{[]string{"dir"}, true, []string{"dir/b.txt", "dir/c.go", "dir/new.txt"}},
+		{[]string{"*.go"}, true, []string{"dir/c.go", "new.go"}},
+		{[]string{".", ":(exclude)dir"}, true, []string{"a.txt", "new.go"}},
+		{[]string{"*.txt", ":!dir/b.txt"}, false, []string{"a.txt"}},
+	} {
+		fileList, err := expandPathspec(ctx, test.args, tracked, test.withUntracked)
+		if err != nil || !slices.Equal(fileList, test.expected) {
+			t.Fatalf("expandPathspec(%v, untracked %v) must return %v, not %v (%v)",
+				test.args, test.withUntracked, test.expected, fileList, err)
+		}
+	}
+	for _, args := range [][]string{{"new.go"}, {"missing"}, {"a.txt", "*.c"}, {"a.txt", ":!a.txt"}} {
+		if _, err := expandPathspec(ctx, args, tracked, false); err == nil {
+			t.Fatalf("expandPathspec(%v) of tracked files must fail", args)
+		}
+	}
+	if _, err := expandPathspec(ctx, []string{"out.log"}, tracked, true); err == nil {
+		t.Fatalf("expandPathspec() of an ignored file must fail")
+	}
+
+	// -u only stages the tracked files of the pathspec, -A the new ones too
+	writeFiles(t, map[string]string{"a.txt": "a2\n", "dir/b.txt": "b2\n", "dir/c.go": "c2\n"})
+	if err := AddFile(ctx, tree, []string{"*.txt"}, ADD_UPDATE); err != nil {
+		t.Fatalf("AddFile(-u *.txt): %s", err)
+	}
+	_, _, commit := reloadRepo(t, ctx)
+	checkStaged(t, commit, map[string]string{"a.txt": "modified", "dir/b.txt": "modified", "dir/new.txt": "", "dir/c.go": ""})
+	if err := AddFile(ctx, tree, []string{"dir", ":!dir/b.txt"}, ADD_ALL); err != nil {
+		t.Fatalf("AddFile(-A dir :!dir/b.txt): %s", err)
+	}
+	_, _, commit = reloadRepo(t, ctx)
+	checkStaged(t, commit, map[string]string{"dir/new.txt": "new", "dir/c.go": "modified", "new.go": ""})
+	checkTracked(t, ctx, "a.txt", "dir/b.txt", "dir/c.go", "dir/new.txt")
+}
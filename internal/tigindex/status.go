@@ -1,28 +1,70 @@
 package tigindex
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"slices"
+	"strings"
 	"tig/internal/tigconfig"
+	"tig/internal/tigdiff"
 	"tig/internal/tigfile"
 	"tig/internal/tighistory"
+	"tig/internal/tigignore"
 )
 
-func GetStatus(ctx *tigconfig.TigCtx) error {
-	cwdFileList, err := tigfile.GetDirTree(".")
+// StatusEntry is a file in the status, OrigPath is the source of a rename or a copy
+type StatusEntry struct {
+	Path     string `json:"path"`
+	OrigPath string `json:"orig_path,omitempty"`
+	Action   string `json:"action,omitempty"` // Staged change: new, modified, deleted or renamed
+}
+
+// TigStatus is the state of the project: staged changes, changes of the working tree
+// not staged yet, and untracked files. Entries are sorted by path.
+type TigStatus struct {
+	Branch       string `json:"branch"`             // Empty if HEAD is detached
+	Head         string `json:"head"`               // Id of the HEAD commit, "-" if no commit yet
+	Upstream     string `json:"upstream,omitempty"` // Ref tracked by the branch
+	UpstreamGone bool   `json:"upstream_gone"`      // The upstream ref does not exist
+	Ahead        int    `json:"ahead"`              // Commits of HEAD not in the upstream
+	Behind       int    `json:"behind"`             // Commits of the upstream not in HEAD
+
+	Staged     []StatusEntry `json:"staged"`
+	Modified   []StatusEntry `json:"modified"`
+	Deleted    []StatusEntry `json:"deleted"`
+	Renamed    []StatusEntry `json:"renamed"`    // Deleted tracked files found as untracked files
+	Conflicted []StatusEntry `json:"conflicted"` // Modified files with conflict markers
+	Untracked  []StatusEntry `json:"untracked"`  // OrigPath is set for copies
+	Unmodified []StatusEntry `json:"unmodified"` // Tracked files without any change
+}
+
+// GetStatus compare HEAD, the staged changes and the working tree
+func GetStatus(ctx *tigconfig.TigCtx, tree *tighistory.TigCommitTree) (*TigStatus, error) {
+	trackIndex, err := LoadTrackIndex(*ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get track files: %w", err)
+	}
+	trackFileList := trackIndex.Paths()
+	ignore, err := tigignore.Load(ctx.ProjectPath)
 	if err != nil {
-		return fmt.Errorf("Cannot get file tree: %w", err)
+		return nil, fmt.Errorf("Cannot get ignore rules: %w", err)
 	}
-	trackFileList, err := getTrackedFiles(*ctx)
+	trackSet := make(map[string]bool, len(trackFileList))
+	for _, v := range trackFileList {
+		trackSet[v] = true
+	}
+	cwdFileList, err := WorkingTreeFiles(*ctx, ignore, trackSet)
 	if err != nil {
-		return fmt.Errorf("Cannot get track files: %w", err)
+		return nil, fmt.Errorf("Cannot get file tree: %w", err)
 	}
 	commit, err := tighistory.GetCurrentCommit(*ctx)
 	if err != nil {
-		return fmt.Errorf("Cannot get current commit: %w", err)
+		return nil, fmt.Errorf("Cannot get current commit: %w", err)
 	}
+	headState := tree.HeadState()
 	untrackFiles := make([]string, 0, 40)
 	trackFiles := make(map[string]bool, 32)
-	commitFiles := make(map[string]bool, 16)
 
 	for _, v := range trackFileList {
 		// By default assum they don't exists
@@ -38,42 +80,375 @@ func GetStatus(ctx *tigconfig.TigCtx) error {
 		}
 	}
 
-	fmt.Println("Commit:")
-	for _, v := range commit.Changes {
-		commitFiles[v.FileSnapshot.File.Path] = true
-		fmt.Println(fmt.Sprintf(
-			"\t%s:\t%s", tighistory.ChangeActionToStr(v.Action), v.FileSnapshot.File.Path))
+	var existing []string
+	for filePath, exists := range trackFiles {
+		if exists {
+			existing = append(existing, filePath)
+		}
+	}
+	if err := trackIndex.Refresh(existing, ctx.Workers()); err != nil {
+		return nil, err
+	}
+
+	renamedTo, copiedFrom, err := detectRenames(*ctx, commit, headState, trackFiles, untrackFiles)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot detect renames: %w", err)
+	}
+	renamed := make(map[string]bool, len(renamedTo))
+	for _, newPath := range renamedTo {
+		renamed[newPath] = true
 	}
 
-	fmt.Println("\nTrack files:")
+	// Empty lists, not null in JSON
+	status := &TigStatus{Staged: []StatusEntry{}, Modified: []StatusEntry{}, Deleted: []StatusEntry{},
+		Renamed: []StatusEntry{}, Conflicted: []StatusEntry{}, Untracked: []StatusEntry{}, Unmodified: []StatusEntry{}}
+	status.Branch, status.Head = tree.Branch, tree.HeadId()
+	if upstream, ok := tree.Upstream(*ctx); ok {
+		status.Upstream = upstream
+		if upstreamNode := tree.RefNode(upstream); upstreamNode != nil {
+			status.Ahead, status.Behind = tighistory.AheadBehind(tree.Head, upstreamNode)
+		} else {
+			status.UpstreamGone = true
+		}
+	}
+	// A staged deletion and a staged new file are shown as a rename, as the commit will record them
+	staged := tighistory.TigCommit{Changes: slices.Clone(commit.Changes)}
+	staged.DetectRenames(headState, ctx.RenameThreshold())
+	for _, v := range staged.Changes {
+		status.Staged = append(status.Staged, StatusEntry{
+			Path: v.Path, OrigPath: v.OldPath, Action: tighistory.ChangeActionToStr(v.Action)})
+	}
 	for k, v := range trackFiles {
-		var fileState string
-		if v {
-			hasChanged, err := ctx.FS.HasChanged(k)
-			if err != nil {
-				return err
-			}
-			if hasChanged {
-				fileState = "modified"
+		if !v {
+			if newPath, ok := renamedTo[k]; ok {
+				status.Renamed = append(status.Renamed, StatusEntry{Path: newPath, OrigPath: k})
 			} else {
-				if _, ok := commitFiles[k]; ok {
-					continue
-				}
+				status.Deleted = append(status.Deleted, StatusEntry{Path: k})
 			}
-
-		} else {
-			fileState = "delete"
+			continue
 		}
-		if len(fileState) > 0 {
-			fmt.Println(fmt.Sprintf("\t%s:\t%s", fileState, k))
+		hasChanged, err := trackIndex.HasChanged(k, IndexSnapshot(commit, headState, k))
+		if err != nil {
+			return nil, err
+		}
+		if !hasChanged {
+			if !commit.HasFile(k) {
+				status.Unmodified = append(status.Unmodified, StatusEntry{Path: k})
+			}
+			continue
+		}
+		conflicted, err := hasConflicts(k)
+		if err != nil {
+			return nil, err
+		}
+		if conflicted {
+			status.Conflicted = append(status.Conflicted, StatusEntry{Path: k})
 		} else {
-			fmt.Println(fmt.Sprintf("\t\t%s", k))
+			status.Modified = append(status.Modified, StatusEntry{Path: k})
+		}
+	}
+	if trackIndex.IsDirty() {
+		// Refreshed stat cache, the next status won't hash these files again.
+		// Only an optimization, skipped if another tig process holds the lock.
+		if lock, err := ctx.Lock(); err == nil {
+			err = trackIndex.SaveCache(*ctx)
+			lock.Unlock()
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
-	fmt.Println("\nUntrack files:")
 	for _, v := range untrackFiles {
-		fmt.Println("\t" + v)
+		if !renamed[v] {
+			status.Untracked = append(status.Untracked, StatusEntry{Path: v, OrigPath: copiedFrom[v]})
+		}
+	}
+	for _, entries := range [][]StatusEntry{status.Staged, status.Modified, status.Deleted,
+		status.Renamed, status.Conflicted, status.Untracked, status.Unmodified} {
+		slices.SortFunc(entries, func(a, b StatusEntry) int { return strings.Compare(a.Path, b.Path) })
+	}
+	return status, nil
+}
+
+// hasConflicts return true if the working file still contains conflict markers
+func hasConflicts(filepath string) (bool, error) {
+	data, binary, err := tigfile.ReadFileText(filepath)
+	if err != nil || binary {
+		return false, err
+	}
+	return tigdiff.HasConflictMarkers(tigdiff.Lines(data)), nil
+}
+
+// IsClean return true if there is no staged change, no unstaged change and no untracked file
+func (status *TigStatus) IsClean() bool {
+	return len(status.Staged)+len(status.Modified)+len(status.Deleted)+len(status.Renamed)+
+		len(status.Conflicted)+len(status.Untracked) == 0
+}
+
+// plural return word, with a "s" if n is not 1
+func plural(n int, word string) string {
+	if n == 1 {
+		return word
+	}
+	return word + "s"
+}
+
+// writeBranch write the branch and its state against the upstream
+func (status *TigStatus) writeBranch(w io.Writer) {
+	if len(status.Branch) > 0 {
+		fmt.Fprintf(w, "On branch %s\n", status.Branch)
+	} else {
+		fmt.Fprintf(w, "HEAD detached at %s\n", status.Head[:min(len(status.Head), 8)])
+	}
+	upstream := tighistory.ShortRefName(status.Upstream)
+	switch {
+	case len(status.Upstream) == 0:
+	case status.UpstreamGone:
+		fmt.Fprintf(w, "Your branch is based on '%s', but the upstream is gone.\n", upstream)
+	case status.Ahead > 0 && status.Behind > 0:
+		fmt.Fprintf(w, "Your branch and '%s' have diverged,\nand have %d and %d different commits each, respectively.\n",
+			upstream, status.Ahead, status.Behind)
+	case status.Ahead > 0:
+		fmt.Fprintf(w, "Your branch is ahead of '%s' by %d %s.\n", upstream, status.Ahead, plural(status.Ahead, "commit"))
+	case status.Behind > 0:
+		fmt.Fprintf(w, "Your branch is behind '%s' by %d %s.\n", upstream, status.Behind, plural(status.Behind, "commit"))
+	default:
+		fmt.Fprintf(w, "Your branch is up to date with '%s'.\n", upstream)
+	}
+}
+
+// branchHeader return the "## branch...upstream [ahead n, behind m]" line of the short format
+func (status *TigStatus) branchHeader() string {
+	header := "## "
+	if len(status.Branch) == 0 {
+		header += "HEAD (no branch)"
+	} else if status.Head == "-" {
+		header += "No commits yet on " + status.Branch
+	} else {
+		header += status.Branch
+	}
+	if len(status.Upstream) == 0 || len(status.Branch) == 0 {
+		return header
 	}
+	header += "..." + tighistory.ShortRefName(status.Upstream)
+	var counts []string
+	if status.UpstreamGone {
+		counts = append(counts, "gone")
+	}
+	if status.Ahead > 0 {
+		counts = append(counts, fmt.Sprintf("ahead %d", status.Ahead))
+	}
+	if status.Behind > 0 {
+		counts = append(counts, fmt.Sprintf("behind %d", status.Behind))
+	}
+	if len(counts) > 0 {
+		header += " [" + strings.Join(counts, ", ") + "]"
+	}
+	return header
+}
 
+// WriteHuman write the status for humans
+func (status *TigStatus) WriteHuman(w io.Writer) {
+	status.writeBranch(w)
+	fmt.Fprintln(w, "\nCommit:")
+	for _, v := range status.Staged {
+		filePath := v.Path
+		if len(v.OrigPath) > 0 {
+			filePath = v.OrigPath + " -> " + filePath
+		}
+		fmt.Fprintf(w, "\t%s:\t%s\n", v.Action, filePath)
+	}
+
+	fmt.Fprintln(w, "\nTrack files:")
+	type trackLine struct{ path, line string }
+	var lines []trackLine
+	for _, v := range status.Modified {
+		lines = append(lines, trackLine{v.Path, "\tmodified:\t" + v.Path})
+	}
+	for _, v := range status.Conflicted {
+		lines = append(lines, trackLine{v.Path, "\tconflict:\t" + v.Path})
+	}
+	for _, v := range status.Deleted {
+		lines = append(lines, trackLine{v.Path, "\tdelete:\t" + v.Path})
+	}
+	for _, v := range status.Renamed {
+		lines = append(lines, trackLine{v.OrigPath, "\trenamed:\t" + v.OrigPath + " -> " + v.Path})
+	}
+	for _, v := range status.Unmodified {
+		lines = append(lines, trackLine{v.Path, "\t\t" + v.Path})
+	}
+	slices.SortFunc(lines, func(a, b trackLine) int { return strings.Compare(a.path, b.path) })
+	for _, v := range lines {
+		fmt.Fprintln(w, v.line)
+	}
+
+	fmt.Fprintln(w, "\nUntrack files:")
+	for _, v := range status.Untracked {
+		if len(v.OrigPath) > 0 {
+			fmt.Fprintln(w, "\t"+v.Path+"\t(copied from "+v.OrigPath+")")
+		} else {
+			fmt.Fprintln(w, "\t"+v.Path)
+		}
+	}
+}
+
+// porcelainCode return the X column of a staged change
+func porcelainCode(action string) byte {
+	switch action {
+	case tighistory.ChangeActionToStr(tighistory.ADD):
+		return 'A'
+	case tighistory.ChangeActionToStr(tighistory.DELETE):
+		return 'D'
+	case tighistory.ChangeActionToStr(tighistory.RENAME):
+		return 'R'
+	}
+	return 'M'
+}
+
+// WritePorcelain write the status in the porcelain v1 format, also used by the short format:
+// "XY path", X is the staged change and Y the working tree change, "XY orig -> path" for renames.
+// With nulTerminated, entries end with NUL, and renames are written "XY path\0orig\0".
+// With showBranch, the first entry is the branch header.
+func (status *TigStatus) WritePorcelain(w io.Writer, nulTerminated bool, showBranch bool) error {
+	type porcelainEntry struct {
+		x, y     byte
+		path     string
+		origPath string
+	}
+	entries := make(map[string]*porcelainEntry, len(status.Staged)+len(status.Modified))
+	get := func(filePath string) *porcelainEntry {
+		entry, ok := entries[filePath]
+		if !ok {
+			entry = &porcelainEntry{x: ' ', y: ' ', path: filePath}
+			entries[filePath] = entry
+		}
+		return entry
+	}
+	for _, v := range status.Staged {
+		entry := get(v.Path)
+		entry.x, entry.origPath = porcelainCode(v.Action), v.OrigPath
+	}
+	for _, v := range status.Modified {
+		get(v.Path).y = 'M'
+	}
+	for _, v := range status.Deleted {
+		get(v.Path).y = 'D'
+	}
+	for _, v := range status.Renamed {
+		// Not staged, so it's a deleted file and an untracked one
+		get(v.OrigPath).y = 'D'
+		get(v.Path).x, get(v.Path).y = '?', '?'
+	}
+	for _, v := range status.Conflicted {
+		entry := get(v.Path)
+		entry.x, entry.y = 'U', 'U'
+	}
+	for _, v := range status.Untracked {
+		entry := get(v.Path)
+		entry.x, entry.y = '?', '?'
+	}
+	paths := make([]string, 0, len(entries))
+	for filePath := range entries {
+		paths = append(paths, filePath)
+	}
+	slices.Sort(paths)
+	// Untracked files come last
+	slices.SortStableFunc(paths, func(a, b string) int {
+		return boolToInt(entries[a].x == '?') - boolToInt(entries[b].x == '?')
+	})
+
+	if showBranch {
+		end := "\n"
+		if nulTerminated {
+			end = "\x00"
+		}
+		if _, err := io.WriteString(w, status.branchHeader()+end); err != nil {
+			return err
+		}
+	}
+	for _, filePath := range paths {
+		entry := entries[filePath]
+		var line string
+		if nulTerminated {
+			line = fmt.Sprintf("%c%c %s\x00", entry.x, entry.y, entry.path)
+			if len(entry.origPath) > 0 {
+				line += entry.origPath + "\x00"
+			}
+		} else {
+			line = fmt.Sprintf("%c%c %s\n", entry.x, entry.y, entry.path)
+			if len(entry.origPath) > 0 {
+				line = fmt.Sprintf("%c%c %s -> %s\n", entry.x, entry.y, entry.origPath, entry.path)
+			}
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// WriteJSON write the status as a JSON object
+func (status *TigStatus) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(status)
+}
+
+// detectRenames pair deleted tracked files with untracked files (renames), and if
+// [tigconfig.TigCtx.DetectCopies], existing tracked files with untracked files (copies).
+// It returns old path -> new path for renames and new path -> source path for copies.
+func detectRenames(ctx tigconfig.TigCtx, commit *tighistory.TigCommit, headState tighistory.TigTreeState,
+	trackFiles map[string]bool, untrackFiles []string) (map[string]string, map[string]string, error) {
+	renamedTo := make(map[string]string, 8)
+	copiedFrom := make(map[string]string, 8)
+	if len(untrackFiles) == 0 {
+		return renamedTo, copiedFrom, nil
+	}
+	copies := ctx.DetectCopies()
+	var deleted, existing, added []tighistory.RenameCandidate
+	for filePath, exists := range trackFiles {
+		snapshot := IndexSnapshot(commit, headState, filePath)
+		if snapshot == nil {
+			continue
+		}
+		if !exists {
+			deleted = append(deleted, tighistory.SnapshotCandidate(filePath, snapshot))
+		} else if copies {
+			existing = append(existing, tighistory.SnapshotCandidate(filePath, snapshot))
+		}
+	}
+	if len(deleted) == 0 && len(existing) == 0 {
+		// Nothing can be renamed or copied, the untracked files are not hashed
+		return renamedTo, copiedFrom, nil
+	}
+	for _, filePath := range untrackFiles {
+		candidate, err := tighistory.WorktreeCandidate(ctx.FS, filePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		added = append(added, candidate)
+	}
+	threshold := ctx.RenameThreshold()
+	renamed := make(map[string]bool, 8)
+	for _, pair := range tighistory.MatchRenames(deleted, added, threshold) {
+		renamedTo[pair.From] = pair.To
+		renamed[pair.To] = true
+	}
+	var notRenamed []tighistory.RenameCandidate
+	for _, candidate := range added {
+		if !renamed[candidate.Path] {
+			notRenamed = append(notRenamed, candidate)
+		}
+	}
+	for _, pair := range tighistory.MatchCopies(existing, notRenamed, threshold) {
+		copiedFrom[pair.To] = pair.From
+	}
+	return renamedTo, copiedFrom, nil
+}
@@ -0,0 +1,215 @@
+package tigindex
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfile"
+	"tig/internal/tighistory"
+)
+
+// newTestRepo create a repository in a temporary directory, move to it and load it
+func newTestRepo(t *testing.T) (tigconfig.TigCtx, *tighistory.TigCommitTree) {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+	ctx := tigconfig.TigCtx{ProjectPath: dir, TigPath: path.Join(dir, tigconfig.TigRootPath)}
+	if err := ctx.Init(); err != nil {
+		t.Fatalf("Init(): %s", err)
+	}
+	if err := ctx.LoadConfig(); err != nil {
+		t.Fatalf("LoadConfig(): %s", err)
+	}
+	if err := ctx.LoadFS(); err != nil {
+		t.Fatalf("LoadFS(): %s", err)
+	}
+	tree, err := tighistory.LoadCommits(ctx)
+	if err != nil {
+		t.Fatalf("LoadCommits(): %s", err)
+	}
+	return ctx, tree
+}
+
+// writeFiles write the files of the working tree
+func writeFiles(t *testing.T, files map[string]string) {
+	for filePath, content := range files {
+		if err := os.MkdirAll(path.Dir(filePath), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := tigfile.WriteFileString(filePath, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// commitFiles write the files, stage them and commit them
+func commitFiles(t *testing.T, ctx tigconfig.TigCtx, tree *tighistory.TigCommitTree, msg string, files map[string]string) {
+	writeFiles(t, files)
+	var fileList []string
+	for filePath := range files {
+		fileList = append(fileList, filePath)
+	}
+	if err := AddFile(ctx, tree, fileList, ADD_PATHS); err != nil {
+		t.Fatalf("AddFile(): %s", err)
+	}
+	if err := tighistory.Commit(ctx, tree, msg); err != nil {
+		t.Fatalf("Commit(): %s", err)
+	}
+}
+
+// getStatus return the status, it must not fail
+func getStatus(t *testing.T, ctx tigconfig.TigCtx, tree *tighistory.TigCommitTree) *TigStatus {
+	status, err := GetStatus(&ctx, tree)
+	if err != nil {
+		t.Fatalf("GetStatus(): %s", err)
+	}
+	return status
+}
+
+func TestStatusRenames(t *testing.T) {
+	ctx, tree := newTestRepo(t)
+	commitFiles(t, ctx, tree, "one", map[string]string{"a.txt": "1\n2\n3\n4\n", "b.txt": "b\nc\nd\n"})
+
+	// Copies are not detected by default
+	writeFiles(t, map[string]string{"c.txt": "b\nc\nd\n"})
+	status := getStatus(t, ctx, tree)
+	if len(status.Untracked) != 1 || status.Untracked[0] != (StatusEntry{Path: "c.txt"}) {
+		t.Fatalf("c.txt must be untracked, not a copy: %+v", status.Untracked)
+	}
+	ctx.SetConfig(tigconfig.CONFIG_RENAME_COPIES, "true")
+	status = getStatus(t, ctx, tree)
+	if len(status.Untracked) != 1 || status.Untracked[0] != (StatusEntry{Path: "c.txt", OrigPath: "b.txt"}) {
+		t.Fatalf("c.txt must be a copy of b.txt: %+v", status.Untracked)
+	}
+
+	// A deleted file is paired with a similar untracked file
+	if err := os.Remove("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, map[string]string{"d.txt": "1\n2\n3\n4\n5\n"})
+	status = getStatus(t, ctx, tree)
+	if len(status.Renamed) != 1 || status.Renamed[0] != (StatusEntry{Path: "d.txt", OrigPath: "a.txt"}) {
+		t.Fatalf("a.txt must be renamed to d.txt: %+v", status.Renamed)
+	}
+	if len(status.Deleted) != 0 || len(status.Untracked) != 1 {
+		t.Fatalf("Only c.txt must be untracked, nothing deleted: %+v %+v", status.Untracked, status.Deleted)
+	}
+}
+
+func TestStatusStagedRename(t *testing.T) {
+	ctx, tree := newTestRepo(t)
+	commitFiles(t, ctx, tree, "one", map[string]string{"src/x.go": "package src\n\nfunc X() {}\n", "y.txt": "y\n"})
+	if err := os.Rename("src/x.go", "src/z.go"); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddFile(ctx, tree, nil, ADD_ALL); err != nil {
+		t.Fatalf("AddFile(-A): %s", err)
+	}
+
+	// The staged deletion and the staged new file are a rename
+	status := getStatus(t, ctx, tree)
+	expected := StatusEntry{Path: "src/z.go", OrigPath: "src/x.go", Action: tighistory.ChangeActionToStr(tighistory.RENAME)}
+	if len(status.Staged) != 1 || status.Staged[0] != expected {
+		t.Fatalf("src/x.go must be staged as renamed to src/z.go: %+v", status.Staged)
+	}
+	var out bytes.Buffer
+	if err := status.WritePorcelain(&out, false, false); err != nil {
+		t.Fatalf("WritePorcelain(): %s", err)
+	}
+	if out.String() != "R  src/x.go -> src/z.go\n" {
+		t.Fatalf("WritePorcelain() must write the staged rename, not %q", out.String())
+	}
+	out.Reset()
+	status.WriteHuman(&out)
+	if !strings.Contains(out.String(), "\trenamed:\tsrc/x.go -> src/z.go\n") {
+		t.Fatalf("WriteHuman() must write the staged rename:\n%s", out.String())
+	}
+}
+
+// statusRepo create a repository with every kind of status entry
+func statusRepo(t *testing.T) (tigconfig.TigCtx, *tighistory.TigCommitTree) {
+	ctx, tree := newTestRepo(t)
+	commitFiles(t, ctx, tree, "one", map[string]string{"a.txt": "a\n", "b.txt": "b\n", "c.txt": "c\n", "d.txt": "d\n"})
+	writeFiles(t, map[string]string{"a.txt": "a2\n", "n.txt": "n\n"})
+	if err := AddFile(ctx, tree, []string{"a.txt", "n.txt"}, ADD_PATHS); err != nil {
+		t.Fatalf("AddFile(): %s", err)
+	}
+	if err := MoveFile(ctx, tree, "d.txt", "e.txt", false); err != nil {
+		t.Fatalf("MoveFile(): %s", err)
+	}
+	writeFiles(t, map[string]string{"a.txt": "a3\n", "b.txt": "b2\n", "u.txt": "u\n"})
+	if err := os.Remove("c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	return ctx, tree
+}
+
+func TestStatusPorcelain(t *testing.T) {
+	ctx, tree := statusRepo(t)
+	status := getStatus(t, ctx, tree)
+
+	for _, test := range []struct {
+		nulTerminated, showBranch bool
+		expected                  string
+	}{
+		{false, false, "MM a.txt\n M b.txt\n D c.txt\nR  d.txt -> e.txt\nA  n.txt\n?? u.txt\n"},
+		{false, true, "## main\nMM a.txt\n M b.txt\n D c.txt\nR  d.txt -> e.txt\nA  n.txt\n?? u.txt\n"},
+		{true, false, "MM a.txt\x00 M b.txt\x00 D c.txt\x00R  e.txt\x00d.txt\x00A  n.txt\x00?? u.txt\x00"},
+		{true, true, "## main\x00MM a.txt\x00 M b.txt\x00 D c.txt\x00R  e.txt\x00d.txt\x00A  n.txt\x00?? u.txt\x00"},
+	} {
+		var out bytes.Buffer
+		if err := status.WritePorcelain(&out, test.nulTerminated, test.showBranch); err != nil {
+			t.Fatalf("WritePorcelain(): %s", err)
+		}
+		if out.String() != test.expected {
+			t.Fatalf("WritePorcelain(-z %v, -b %v) must write %q, not %q", test.nulTerminated, test.showBranch,
+				test.expected, out.String())
+		}
+	}
+}
+
+func TestStatusJSON(t *testing.T) {
+	ctx, tree := statusRepo(t)
+	status := getStatus(t, ctx, tree)
+
+	var out bytes.Buffer
+	if err := status.WriteJSON(&out); err != nil {
+		t.Fatalf("WriteJSON(): %s", err)
+	}
+	if !bytes.Contains(out.Bytes(), []byte(`"conflicted": []`)) {
+		t.Fatalf("WriteJSON() must write empty lists, not null:\n%s", out.String())
+	}
+	var decoded TigStatus
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("WriteJSON() must write JSON: %s\n%s", err, out.String())
+	}
+	if decoded.Branch != "main" || decoded.Head != tree.HeadId() {
+		t.Fatalf("WriteJSON() must write the branch and HEAD: %s %s", decoded.Branch, decoded.Head)
+	}
+	staged := []StatusEntry{{Path: "a.txt", Action: "modified"}, {Path: "e.txt", OrigPath: "d.txt", Action: "renamed"},
+		{Path: "n.txt", Action: "new"}}
+	for _, test := range []struct {
+		name              string
+		entries, expected []StatusEntry
+	}{
+		{"staged", decoded.Staged, staged},
+		{"modified", decoded.Modified, []StatusEntry{{Path: "a.txt"}, {Path: "b.txt"}}},
+		{"deleted", decoded.Deleted, []StatusEntry{{Path: "c.txt"}}},
+		{"untracked", decoded.Untracked, []StatusEntry{{Path: "u.txt"}}},
+	} {
+		if !slices.Equal(test.entries, test.expected) {
+			t.Fatalf("WriteJSON() must write the %s files %+v, not %+v", test.name, test.expected, test.entries)
+		}
+	}
+}
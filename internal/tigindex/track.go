@@ -0,0 +1,219 @@
+package tigindex
+
+import (
+	"fmt"
+	"slices"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfile"
+	"tig/internal/tigfs"
+	"tig/internal/tigindexfile"
+	"time"
+)
+
+// TrackEntry is a tracked file with the stat and the hash of its working file
+type TrackEntry struct {
+	Path   string
+	Stat   tigfile.FileStat
+	Hash   string // Empty if unknown or racy
+	hashed string // Hash computed for Stat by this process, even racy, not saved
+}
+
+// TrackIndex is the list of tracked files with their stat cache
+type TrackIndex struct {
+	Entries map[string]*TrackEntry
+	dirty   bool // The cache was refreshed and must be saved
+}
+
+// LoadTrackIndex read the tracked files of the index
+func LoadTrackIndex(ctx tigconfig.TigCtx) (*TrackIndex, error) {
+	file, err := tigindexfile.Load(ctx.TigPath)
+	if err != nil {
+		return nil, fmt.Errorf("LoadTrackIndex: %w", err)
+	}
+	index := &TrackIndex{Entries: make(map[string]*TrackEntry, len(file.Entries))}
+	for _, entry := range file.Entries {
+		if entry.Has(tigindexfile.FLAG_TRACKED) {
+			index.Entries[entry.Path] = &TrackEntry{Path: entry.Path, Stat: entry.Stat, Hash: entry.Hash}
+		}
+	}
+	return index, nil
+}
+
+// Save write the tracked files to the index, the staged changes are kept
+func (index *TrackIndex) Save(ctx tigconfig.TigCtx) error {
+	err := tigindexfile.Update(ctx.TigPath, func(file *tigindexfile.Index) error {
+		file.ClearFlags(tigindexfile.FLAG_TRACKED)
+		for _, entry := range index.Entries {
+			fileEntry := file.Get(entry.Path)
+			fileEntry.Flags |= tigindexfile.FLAG_TRACKED
+			fileEntry.Stat, fileEntry.Hash = entry.Stat, entry.Hash
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Save track index: %w", err)
+	}
+	index.dirty = false
+	return nil
+}
+
+// SaveCache write the refreshed stats and hashes to the index, the lock must be held.
+// The index is read again, so the files tracked or untracked since [LoadTrackIndex] are kept:
+// only the entries still tracked are updated.
+func (index *TrackIndex) SaveCache(ctx tigconfig.TigCtx) error {
+	err := tigindexfile.Update(ctx.TigPath, func(file *tigindexfile.Index) error {
+		for _, entry := range index.Entries {
+			if fileEntry := file.Find(entry.Path); fileEntry != nil && fileEntry.Has(tigindexfile.FLAG_TRACKED) {
+				fileEntry.Stat, fileEntry.Hash = entry.Stat, entry.Hash
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Save track index cache: %w", err)
+	}
+	index.dirty = false
+	return nil
+}
+
+// Paths return the tracked files, sorted
+func (index *TrackIndex) Paths() []string {
+	paths := make([]string, 0, len(index.Entries))
+	for filePath := range index.Entries {
+		paths = append(paths, filePath)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// cached return the hash of entry if it is still valid for stat
+func (entry *TrackEntry) cached(stat tigfile.FileStat) (string, bool) {
+	if entry == nil || entry.Stat != stat {
+		return "", false
+	}
+	if len(entry.hashed) > 0 {
+		return entry.hashed, true
+	}
+	return entry.Hash, len(entry.Hash) > 0
+}
+
+// update set the stat and the hash of entry, a racy stat is not saved with its hash
+func (index *TrackIndex) update(entry *TrackEntry, stat tigfile.FileStat, hash string, now time.Time) {
+	if entry == nil {
+		return
+	}
+	entry.Stat, entry.Hash, entry.hashed = stat, hash, hash
+	if stat.IsRacy(now) {
+		// Could change again with the same stat, don't trust it next time
+		entry.Hash = ""
+	}
+	index.dirty = true
+}
+
+// Hash return the hash of the working file filepath. The cached hash is used when the
+// stat of the file did not change, otherwise the file is hashed and the cache refreshed.
+func (index *TrackIndex) Hash(filepath string) (string, error) {
+	hash, _, err := index.hashStat(filepath)
+	return hash, err
+}
+
+// hashStat return the hash of the working file filepath, like [TrackIndex.Hash], and its stat
+func (index *TrackIndex) hashStat(filepath string) (string, tigfile.FileStat, error) {
+	now := time.Now()
+	stat, err := tigfile.Stat(filepath)
+	if err != nil {
+		return "", stat, err
+	}
+	entry := index.Entries[filepath]
+	hash, ok := entry.cached(stat)
+	if ok && len(entry.Hash) > 0 {
+		return hash, stat, nil
+	}
+	if !ok {
+		if hash, err = tigfile.HashFile(filepath); err != nil {
+			return "", stat, err
+		}
+	}
+	// Hashed now, or racy when hashed and maybe not anymore
+	index.update(entry, stat, hash, now)
+	return hash, stat, nil
+}
+
+// Refresh stat every file of fileList and hash the changed ones with a pool of workers,
+// so the next [TrackIndex.Hash] calls on these files only cost a stat
+func (index *TrackIndex) Refresh(fileList []string, workers int) error {
+	now := time.Now()
+	stats := make([]tigfile.FileStat, len(fileList))
+	hashes := make([]string, len(fileList))
+	err := tigfile.ForEachParallel(len(fileList), workers, func(i int) error {
+		stat, err := tigfile.Stat(fileList[i])
+		if err != nil {
+			return fmt.Errorf("Refresh: %w", err)
+		}
+		stats[i] = stat
+		if hash, ok := index.Entries[fileList[i]].cached(stat); ok {
+			hashes[i] = hash
+			return nil
+		}
+		if hashes[i], err = tigfile.HashFile(fileList[i]); err != nil {
+			return fmt.Errorf("Refresh: %s: %w", fileList[i], err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i, filePath := range fileList {
+		if entry := index.Entries[filePath]; entry != nil && (entry.Stat != stats[i] || len(entry.Hash) == 0) {
+			index.update(entry, stats[i], hashes[i], now)
+		}
+	}
+	return nil
+}
+
+// HasChanged check if the working file filepath differs from snapshot, like [tigfs.TigFS.HasChanged]
+// but using the stat cache
+func (index *TrackIndex) HasChanged(filepath string, snapshot *tigfs.TigFileSnapshot) (bool, error) {
+	if snapshot == nil {
+		return true, nil
+	}
+	hash, stat, err := index.hashStat(filepath)
+	if err != nil {
+		return false, err
+	}
+	same, err := snapshot.SameContent(hash, stat.Size)
+	return !same, err
+}
+
+// IsDirty return true if the cache was refreshed since the index was loaded
+func (index *TrackIndex) IsDirty() bool {
+	return index.dirty
+}
+
+// GetTrackedFiles return the tracked files
+func GetTrackedFiles(ctx tigconfig.TigCtx) ([]string, error) {
+	index, err := LoadTrackIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return index.Paths(), nil
+}
+
+// SetTrackedFiles overwrite the track file with fileMap keys, the cache of the files kept is preserved
+func SetTrackedFiles(ctx tigconfig.TigCtx, fileMap map[string]bool) error {
+	index, err := LoadTrackIndex(ctx)
+	if err != nil {
+		return err
+	}
+	for filePath := range index.Entries {
+		if !fileMap[filePath] {
+			delete(index.Entries, filePath)
+		}
+	}
+	for filePath := range fileMap {
+		if _, ok := index.Entries[filePath]; !ok {
+			index.Entries[filePath] = &TrackEntry{Path: filePath}
+		}
+	}
+	return index.Save(ctx)
+}
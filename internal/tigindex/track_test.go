@@ -0,0 +1,92 @@
+package tigindex
+
+import (
+	"os"
+	"path"
+	"testing"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfile"
+	"time"
+)
+
+func TestTrackIndexHash(t *testing.T) {
+	tmpDirPath := t.TempDir()
+	ctx := tigconfig.TigCtx{TigPath: tmpDirPath}
+	filePath := path.Join(tmpDirPath, "file")
+	if err := os.WriteFile(filePath, []byte("aaa"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	index := &TrackIndex{Entries: map[string]*TrackEntry{filePath: {Path: filePath}}}
+
+	// Just written, the entry is racy and its hash is not cached
+	hash, err := index.Hash(filePath)
+	if err != nil {
+		t.Fatalf("Hash(): %s", err)
+	}
+	if hash != tigfile.HashBytes([]byte("aaa")) || len(index.Entries[filePath].Hash) != 0 {
+		t.Fatalf("Racy entry must not be cached")
+	}
+	// Same size and same mtime, the content change is still seen
+	mtime := time.Unix(0, index.Entries[filePath].Stat.Mtime)
+	if err := os.WriteFile(filePath, []byte("bbb"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(filePath, mtime, mtime)
+	if hash, _ = index.Hash(filePath); hash != tigfile.HashBytes([]byte("bbb")) {
+		t.Fatalf("Racy entry content change not detected")
+	}
+
+	// Once old enough, the hash is cached, and the cache is saved
+	time.Sleep(tigfile.RACY_WINDOW)
+	index.Hash(filePath)
+	if err := index.Save(ctx); err != nil {
+		t.Fatalf("Save(): %s", err)
+	}
+	loaded, err := LoadTrackIndex(ctx)
+	if err != nil {
+		t.Fatalf("LoadTrackIndex(): %s", err)
+	}
+	if entry := loaded.Entries[filePath]; entry == nil || entry.Hash != tigfile.HashBytes([]byte("bbb")) {
+		t.Fatalf("Cached hash not saved: %v", entry)
+	}
+}
+
+func TestTrackIndexSaveCache(t *testing.T) {
+	tmpDirPath := t.TempDir()
+	ctx := tigconfig.TigCtx{TigPath: tmpDirPath}
+	for _, name := range []string{"a", "b", "c"} {
+		if err := os.WriteFile(path.Join(tmpDirPath, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(tigfile.RACY_WINDOW) // Not racy, the hashes are cached
+	a, b, c := path.Join(tmpDirPath, "a"), path.Join(tmpDirPath, "b"), path.Join(tmpDirPath, "c")
+	if err := SetTrackedFiles(ctx, map[string]bool{a: true, b: true}); err != nil {
+		t.Fatalf("SetTrackedFiles(): %s", err)
+	}
+	index, err := LoadTrackIndex(ctx)
+	if err != nil {
+		t.Fatalf("LoadTrackIndex(): %s", err)
+	}
+	if err := index.Refresh([]string{a, b}, 1); err != nil || !index.IsDirty() {
+		t.Fatalf("Refresh() must update the cache: %v", err)
+	}
+
+	// Another process untracks b and tracks c meanwhile, its changes are kept
+	if err := SetTrackedFiles(ctx, map[string]bool{a: true, c: true}); err != nil {
+		t.Fatalf("SetTrackedFiles(): %s", err)
+	}
+	if err := index.SaveCache(ctx); err != nil {
+		t.Fatalf("SaveCache(): %s", err)
+	}
+	loaded, err := LoadTrackIndex(ctx)
+	if err != nil {
+		t.Fatalf("LoadTrackIndex(): %s", err)
+	}
+	if paths := loaded.Paths(); len(paths) != 2 || paths[0] != a || paths[1] != c {
+		t.Fatalf("SaveCache() must not change the tracked files: %v", paths)
+	}
+	if entry := loaded.Entries[a]; entry.Hash != tigfile.HashBytes([]byte("a")) {
+		t.Fatalf("SaveCache() must save the hash of a: %+v", entry)
+	}
+}
@@ -0,0 +1,189 @@
+// Package tigindexfile contains the binary index file: the tracked files with their stat cache,
+// and the staged changes
+package tigindexfile
+
+/*
+How to store the index (binary, see tigfile.BinaryWriter):
+- Magic "TIGI", version
+- Number of entries (uint32), then the entries sorted by path:
+	- path (string)
+	- flags (uint32): FLAG_TRACKED, FLAG_STAGED
+	- stat of the working file: size, mtime, ctime, inode (uint64), mode (uint32)
+	- hash of the working file for this stat (string), empty if unknown or racy
+	- staged action (uint32), staged hash (string), old path of a staged rename (string)
+- sha1 of the whole file
+*/
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"tig/internal/tigfile"
+)
+
+// TigIndexFileName path relative to TigRootPath
+const TigIndexFileName = "index"
+
+const (
+	INDEX_MAGIC   = "TIGI"
+	INDEX_VERSION = 1
+)
+
+// Entry flags
+const (
+	FLAG_TRACKED uint32 = 1 << 0 // The file is tracked
+	FLAG_STAGED  uint32 = 1 << 1 // The file has a staged change: Action, StagedHash, OldPath
+)
+
+// Entry is a file of the index, tracked or with a staged change
+type Entry struct {
+	Path       string
+	Flags      uint32
+	Stat       tigfile.FileStat
+	Hash       string // Hash of the working file for Stat, empty if unknown or racy
+	Action     uint32 // Staged change action
+	StagedHash string
+	OldPath    string // Path before a staged rename
+}
+
+// Index is the content of the index file, entries are sorted by path
+type Index struct {
+	Entries []*Entry
+}
+
+// Has return true if the entry has every flag of flags
+func (entry *Entry) Has(flags uint32) bool {
+	return entry.Flags&flags == flags
+}
+
+// search return the position of filepath in the entries, and true if it is there
+func (index *Index) search(filepath string) (int, bool) {
+	return slices.BinarySearchFunc(index.Entries, filepath, func(entry *Entry, target string) int {
+		return strings.Compare(entry.Path, target)
+	})
+}
+
+// Find return the entry of filepath, or nil
+func (index *Index) Find(filepath string) *Entry {
+	if i, ok := index.search(filepath); ok {
+		return index.Entries[i]
+	}
+	return nil
+}
+
+// Get return the entry of filepath, it is created if needed
+func (index *Index) Get(filepath string) *Entry {
+	i, ok := index.search(filepath)
+	if !ok {
+		index.Entries = slices.Insert(index.Entries, i, &Entry{Path: filepath})
+	}
+	return index.Entries[i]
+}
+
+// ClearFlags remove flags from every entry, entries without any flag left are removed
+func (index *Index) ClearFlags(flags uint32) {
+	index.Entries = slices.DeleteFunc(index.Entries, func(entry *Entry) bool {
+		entry.Flags &^= flags
+		if flags&FLAG_STAGED != 0 {
+			entry.Action, entry.StagedHash, entry.OldPath = 0, "", ""
+		}
+		return entry.Flags == 0
+	})
+}
+
+// Prune remove the entries without any flag
+func (index *Index) Prune() {
+	index.ClearFlags(0)
+}
+
+// Decode read an index from the content of an index file
+func Decode(data []byte) (*Index, error) {
+	r, version, err := tigfile.NewBinaryReader(data, INDEX_MAGIC)
+	if err != nil {
+		return nil, err
+	}
+	if version != INDEX_VERSION {
+		return nil, fmt.Errorf("Unsupported index version %d", version)
+	}
+	count := r.ReadUint32()
+	index := &Index{Entries: make([]*Entry, 0, min(count, 1<<16))}
+	for i := uint32(0); i < count && r.Err() == nil; i++ {
+		entry := &Entry{Path: r.ReadString(), Flags: r.ReadUint32()}
+		entry.Stat = tigfile.FileStat{Size: int64(r.ReadUint64()), Mtime: int64(r.ReadUint64()),
+			Ctime: int64(r.ReadUint64()), Inode: r.ReadUint64(), Mode: r.ReadUint32()}
+		entry.Hash = r.ReadString()
+		entry.Action, entry.StagedHash, entry.OldPath = r.ReadUint32(), r.ReadString(), r.ReadString()
+		if n := len(index.Entries); n > 0 && index.Entries[n-1].Path >= entry.Path {
+			return nil, fmt.Errorf("Index entries not sorted at %s", entry.Path)
+		}
+		index.Entries = append(index.Entries, entry)
+	}
+	if err := r.Done(); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// Encode return the content of the index file
+func (index *Index) Encode() []byte {
+	w := tigfile.NewBinaryWriter(INDEX_MAGIC, INDEX_VERSION)
+	w.WriteUint32(uint32(len(index.Entries)))
+	for _, entry := range index.Entries {
+		w.WriteString(entry.Path)
+		w.WriteUint32(entry.Flags)
+		w.WriteUint64(uint64(entry.Stat.Size))
+		w.WriteUint64(uint64(entry.Stat.Mtime))
+		w.WriteUint64(uint64(entry.Stat.Ctime))
+		w.WriteUint64(entry.Stat.Inode)
+		w.WriteUint32(entry.Stat.Mode)
+		w.WriteString(entry.Hash)
+		w.WriteUint32(entry.Action)
+		w.WriteString(entry.StagedHash)
+		w.WriteString(entry.OldPath)
+	}
+	return w.Bytes()
+}
+
+// Load read the index of the repository in tigPath. Without index file, the legacy
+// track and commit files are migrated, no file at all is an empty index.
+func Load(tigPath string) (*Index, error) {
+	data, err := tigfile.ReadFileBytes(path.Join(tigPath, TigIndexFileName), -1)
+	if errors.Is(err, os.ErrNotExist) {
+		index, err := migrateLegacy(tigPath)
+		if err != nil {
+			return nil, fmt.Errorf("Index migration: %w", err)
+		}
+		return index, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Load index: %w", err)
+	}
+	index, err := Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("Load index: %w", err)
+	}
+	return index, nil
+}
+
+// Save write the index of the repository in tigPath
+func (index *Index) Save(tigPath string) error {
+	if err := tigfile.WriteFileAtomic(path.Join(tigPath, TigIndexFileName), index.Encode()); err != nil {
+		return fmt.Errorf("Save index: %w", err)
+	}
+	return nil
+}
+
+// Update load the index, apply update and save it
+func Update(tigPath string, update func(index *Index) error) error {
+	index, err := Load(tigPath)
+	if err != nil {
+		return err
+	}
+	if err := update(index); err != nil {
+		return err
+	}
+	return index.Save(tigPath)
+}
@@ -0,0 +1,95 @@
+package tigindexfile
+
+import (
+	"errors"
+	"os"
+	"path"
+	"testing"
+	"tig/internal/tigfile"
+)
+
+func TestIndexEncodeDecode(t *testing.T) {
+	index := &Index{}
+	for _, filepath := range []string{"b;c.go", "a\nb.go", "z/file.go"} {
+		entry := index.Get(filepath)
+		entry.Flags |= FLAG_TRACKED
+		entry.Stat = tigfile.FileStat{Size: 12, Mtime: 34, Ctime: 56, Inode: 78, Mode: 0o644}
+		entry.Hash = "hash-" + filepath
+	}
+	staged := index.Get("new.go")
+	staged.Flags |= FLAG_STAGED
+	staged.Action, staged.StagedHash, staged.OldPath = 4, "staged", "old.go"
+
+	decoded, err := Decode(index.Encode())
+	if err != nil {
+		t.Fatalf("Decode(): %s", err)
+	}
+	if len(decoded.Entries) != 4 {
+		t.Fatalf("Decode() must return 4 entries, not %d", len(decoded.Entries))
+	}
+	for i, entry := range index.Entries {
+		if *decoded.Entries[i] != *entry {
+			t.Fatalf("Entry mismatch: %+v != %+v", *decoded.Entries[i], *entry)
+		}
+	}
+	if entry := decoded.Find("a\nb.go"); entry == nil || !entry.Has(FLAG_TRACKED) {
+		t.Fatalf("Find() must return the tracked entry")
+	}
+	if decoded.Find("missing.go") != nil {
+		t.Fatalf("Find() must return nil for an unknown path")
+	}
+
+	decoded.ClearFlags(FLAG_STAGED)
+	if len(decoded.Entries) != 3 || decoded.Find("new.go") != nil {
+		t.Fatalf("ClearFlags() must remove the entries without flags")
+	}
+}
+
+func TestIndexChecksum(t *testing.T) {
+	index := &Index{}
+	index.Get("file.go").Flags |= FLAG_TRACKED
+	data := index.Encode()
+	data[len(INDEX_MAGIC)+6] ^= 0xff
+	if _, err := Decode(data); !errors.Is(err, tigfile.ErrBadChecksum) {
+		t.Fatalf("Decode() of a corrupted index must fail with ErrBadChecksum, not %v", err)
+	}
+	if _, err := Decode(index.Encode()[:10]); err == nil {
+		t.Fatalf("Decode() of a truncated index must fail")
+	}
+}
+
+func TestIndexMigrateLegacy(t *testing.T) {
+	tigPath := t.TempDir()
+	err := tigfile.WriteFileLines(path.Join(tigPath, LegacyTrackFileName),
+		[]string{"old.go", "3;100;200;300;420;abc;stat.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tigfile.WriteFileLines(path.Join(tigPath, LegacyCommitFileName),
+		[]string{"2;stat.go;def", "4;new.go;ghi;old.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := Load(tigPath)
+	if err != nil {
+		t.Fatalf("Load(): %s", err)
+	}
+	stat := index.Find("stat.go")
+	if stat == nil || !stat.Has(FLAG_TRACKED|FLAG_STAGED) || stat.Hash != "abc" || stat.Stat.Inode != 300 ||
+		stat.Action != 2 || stat.StagedHash != "def" {
+		t.Fatalf("Bad migrated entry: %+v", stat)
+	}
+	if entry := index.Find("new.go"); entry == nil || entry.Has(FLAG_TRACKED) || entry.OldPath != "old.go" {
+		t.Fatalf("Bad migrated rename: %+v", entry)
+	}
+	for _, name := range []string{LegacyTrackFileName, LegacyCommitFileName} {
+		if _, err := os.Stat(path.Join(tigPath, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("Legacy file %s must be removed", name)
+		}
+	}
+	reloaded, err := Load(tigPath)
+	if err != nil || len(reloaded.Entries) != len(index.Entries) {
+		t.Fatalf("Load() after migration: %v", err)
+	}
+}
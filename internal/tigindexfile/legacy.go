@@ -0,0 +1,113 @@
+package tigindexfile
+
+/*
+Legacy text files replaced by the index, read only to migrate a repository:
+
+How tracked files were stored (.tig/track):
+- A line per tracked file -> "size;mtime;ctime;inode;mode;hash;path", or only "path" for older ones
+
+How the staged changes were stored (.tig/commit):
+- A line per staged file -> "action;filepath;hash", a rename has the old path as 4th element
+*/
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"tig/internal/tigfile"
+)
+
+// Legacy file names, relative to TigRootPath
+const (
+	LegacyTrackFileName  = "track"
+	LegacyCommitFileName = "commit"
+)
+
+// Number of fields of a legacy track file line with stat
+const legacyTrackFields = 7
+
+// readLegacyLines return the lines of a legacy file, none if it does not exist
+func readLegacyLines(filepath string) ([]string, bool, error) {
+	lines, err := tigfile.ReadFileLines(filepath, tigfile.MAX_FILE_SIZE)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	return lines, err == nil, err
+}
+
+// parseLegacyTrackLine add the file of a track file line to index
+func parseLegacyTrackLine(index *Index, line string) {
+	data := strings.SplitN(line, ";", legacyTrackFields)
+	var numbers [5]uint64
+	valid := len(data) == legacyTrackFields
+	for i := 0; valid && i < len(numbers); i++ {
+		n, err := strconv.ParseUint(data[i], 10, 64)
+		numbers[i], valid = n, err == nil
+	}
+	if !valid {
+		index.Get(path.Clean(line)).Flags |= FLAG_TRACKED
+		return
+	}
+	entry := index.Get(path.Clean(data[6]))
+	entry.Flags |= FLAG_TRACKED
+	entry.Hash = data[5]
+	entry.Stat = tigfile.FileStat{Size: int64(numbers[0]), Mtime: int64(numbers[1]),
+		Ctime: int64(numbers[2]), Inode: numbers[3], Mode: uint32(numbers[4])}
+}
+
+// parseLegacyCommitLine add the staged change of a commit file line to index
+func parseLegacyCommitLine(index *Index, line string) error {
+	data := strings.Split(line, ";")
+	if len(data) != 3 && len(data) != 4 {
+		return errors.New("Commit file bad format, must contains 3 or 4 elem on each line")
+	}
+	action, err := strconv.Atoi(data[0])
+	if err != nil {
+		return fmt.Errorf("Commit file bad format: %w", err)
+	}
+	entry := index.Get(path.Clean(data[1]))
+	entry.Flags |= FLAG_STAGED
+	entry.Action, entry.StagedHash = uint32(action), data[2]
+	if len(data) == 4 {
+		entry.OldPath = data[3]
+	}
+	return nil
+}
+
+// migrateLegacy build the index from the legacy track and commit files. If there are any,
+// the index is saved and they are removed.
+func migrateLegacy(tigPath string) (*Index, error) {
+	index := &Index{}
+	trackPath, commitPath := path.Join(tigPath, LegacyTrackFileName), path.Join(tigPath, LegacyCommitFileName)
+	trackLines, hasTrack, err := readLegacyLines(trackPath)
+	if err != nil {
+		return nil, err
+	}
+	commitLines, hasCommit, err := readLegacyLines(commitPath)
+	if err != nil {
+		return nil, err
+	}
+	if !hasTrack && !hasCommit {
+		return index, nil
+	}
+	for _, line := range trackLines {
+		parseLegacyTrackLine(index, line)
+	}
+	for _, line := range commitLines {
+		if err := parseLegacyCommitLine(index, line); err != nil {
+			return nil, err
+		}
+	}
+	if err := index.Save(tigPath); err != nil {
+		return nil, err
+	}
+	for _, legacyPath := range []string{trackPath, commitPath} {
+		if err := os.Remove(legacyPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return index, nil
+}
@@ -0,0 +1,334 @@
+// Package tigmaint contains the maintenance commands, checking and cleaning the repository storage
+package tigmaint
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfile"
+	"tig/internal/tigfs"
+	"tig/internal/tighistory"
+	"tig/internal/tigindexfile"
+	"tig/internal/tigstash"
+)
+
+var ErrCorrupt = errors.New("The repository is corrupted")
+
+// FsckReport is the result of [Fsck]
+type FsckReport struct {
+	Problems []string // Corruptions, the repository cannot be used safely
+	Dangling []string // Objects nothing references, harmless
+}
+
+// problem add a corruption to the report
+func (report *FsckReport) problem(format string, args ...any) {
+	report.Problems = append(report.Problems, fmt.Sprintf(format, args...))
+}
+
+// dangling add an unreferenced object to the report
+func (report *FsckReport) dangling(format string, args ...any) {
+	report.Dangling = append(report.Dangling, fmt.Sprintf(format, args...))
+}
+
+// Write print the report, one line per problem or dangling object
+func (report *FsckReport) Write(w io.Writer) {
+	for _, line := range report.Problems {
+		fmt.Fprintln(w, line)
+	}
+	for _, line := range report.Dangling {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// blobNames return the names of the blob files of the FS directory, sorted
+func blobNames(fs *tigfs.TigFS) ([]string, error) {
+	entries, err := os.ReadDir(fs.DirPath)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == path.Base(fs.IndexPath) || strings.HasPrefix(name, tigfile.ATOMIC_TMP_PREFIX) {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// lfsNames return the names of the objects of the LFS store, sorted, none if the store does not exist
+func lfsNames(lfs *tigfs.TigLFS) ([]string, error) {
+	entries, err := os.ReadDir(lfs.DirPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasPrefix(entry.Name(), tigfile.ATOMIC_TMP_PREFIX) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// lfsPointers return the pointer files among list, by the hash of their object. The snapshots whose
+// blob is missing are skipped.
+func lfsPointers(list []*tigfs.TigFileSnapshot) (map[string]*tigfs.TigFileSnapshot, error) {
+	pointers := make(map[string]*tigfs.TigFileSnapshot, 8)
+	for _, snapshot := range list {
+		pointer, ok, err := snapshot.LFSPointer()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if _, seen := pointers[pointer.Hash]; ok && !seen {
+			pointers[pointer.Hash] = snapshot
+		}
+	}
+	return pointers, nil
+}
+
+// snapshots return every snapshot of the FS, sorted by file path then from the oldest
+func snapshots(fs *tigfs.TigFS) []*tigfs.TigFileSnapshot {
+	filePaths := make([]string, 0, len(fs.Files))
+	for filePath := range fs.Files {
+		filePaths = append(filePaths, filePath)
+	}
+	slices.Sort(filePaths)
+	var list []*tigfs.TigFileSnapshot
+	for _, filePath := range filePaths {
+		var fileList []*tigfs.TigFileSnapshot
+		for ptr := fs.Files[filePath].Head; ptr != nil; ptr = ptr.Previous {
+			fileList = append(fileList, ptr)
+		}
+		slices.Reverse(fileList)
+		list = append(list, fileList...)
+	}
+	return list
+}
+
+// Fsck check the storage of the repository:
+//   - every blob is rehashed against its name, and every snapshot of the FS has its blob
+//   - every LFS object is rehashed against its name, and every pointer file has its object, in the
+//     store or in the cache
+//   - every change of the commits and the stashes, and every staged file, references an existing snapshot
+//   - the parent id of every commit is the commit it is stored under, refs and HEAD point to existing commits
+//
+// Blobs, LFS objects, snapshots and commits nothing references are reported as dangling. ctx.FS is loaded.
+func Fsck(ctx *tigconfig.TigCtx) (*FsckReport, error) {
+	report := &FsckReport{}
+	if err := ctx.LoadFS(); err != nil {
+		report.problem("bad FS index: %s", err)
+		return report, nil
+	}
+
+	// Blobs and snapshots
+	names, err := blobNames(ctx.FS)
+	if err != nil {
+		return nil, fmt.Errorf("Fsck: %w", err)
+	}
+	blobPaths := make([]string, len(names))
+	for i, name := range names {
+		blobPaths[i] = path.Join(ctx.FS.DirPath, name)
+	}
+	hashes, err := tigfile.HashFiles(blobPaths, ctx.Workers())
+	if err != nil {
+		return nil, fmt.Errorf("Fsck: %w", err)
+	}
+	blobs := make(map[string]bool, len(names))
+	for i, name := range names {
+		blobs[name] = true
+		if hashes[i] != name {
+			report.problem("bad blob %s: content hash is %s", name, hashes[i])
+		}
+	}
+	referencedBlobs := make(map[string]bool, len(names))
+	for _, snapshot := range snapshots(ctx.FS) {
+		referencedBlobs[snapshot.Path] = true
+		if !blobs[snapshot.Path] {
+			report.problem("missing blob %s for snapshot %s of %s", snapshot.Path, snapshot.Hash, snapshot.File.Path)
+		} else if snapshot.Path != snapshot.Hash {
+			report.problem("bad snapshot %s of %s: stored in blob %s", snapshot.Hash, snapshot.File.Path, snapshot.Path)
+		}
+	}
+
+	// LFS objects of the pointer files
+	objectNames, err := lfsNames(ctx.FS.LFS)
+	if err != nil {
+		return nil, fmt.Errorf("Fsck: %w", err)
+	}
+	objectPaths := make([]string, len(objectNames))
+	for i, name := range objectNames {
+		objectPaths[i] = ctx.FS.LFS.ObjectPath(name)
+	}
+	objectHashes, err := tigfile.HashFiles(objectPaths, ctx.Workers())
+	if err != nil {
+		return nil, fmt.Errorf("Fsck: %w", err)
+	}
+	objects := make(map[string]bool, len(objectNames))
+	for i, name := range objectNames {
+		objects[name] = true
+		if objectHashes[i] != name {
+			report.problem("bad LFS object %s: content hash is %s", name, objectHashes[i])
+		}
+	}
+	pointers, err := lfsPointers(snapshots(ctx.FS))
+	if err != nil {
+		return nil, fmt.Errorf("Fsck: %w", err)
+	}
+	pointerHashes := make([]string, 0, len(pointers))
+	for hash := range pointers {
+		pointerHashes = append(pointerHashes, hash)
+	}
+	slices.Sort(pointerHashes)
+	for _, hash := range pointerHashes {
+		if objects[hash] {
+			continue
+		}
+		cached := false
+		if len(ctx.FS.LFS.CachePath) > 0 {
+			_, err := os.Stat(path.Join(ctx.FS.LFS.CachePath, hash))
+			cached = err == nil
+		}
+		if !cached {
+			snapshot := pointers[hash]
+			report.problem("missing LFS object %s for snapshot %s of %s", hash, snapshot.Hash, snapshot.File.Path)
+		}
+	}
+
+	// Commits, stashes and index, resolved here to report each missing snapshot
+	referenced := make(map[*tigfs.TigFileSnapshot]bool, 64)
+	resolve := func(changes []tighistory.TigChange, owner string) {
+		for _, change := range changes {
+			if err := change.Resolve(ctx.FS); err != nil {
+				report.problem("missing snapshot %s of %s in %s", change.FileSnapshot.Hash, change.Path, owner)
+			} else {
+				referenced[change.FileSnapshot] = true
+			}
+		}
+	}
+	tree, err := tighistory.LoadCommitsUnresolved(*ctx)
+	if err != nil {
+		report.problem("bad commit tree: %s", err)
+		return report, nil
+	}
+	shallow, err := tighistory.LoadShallow(*ctx)
+	if err != nil {
+		report.problem("bad shallow file: %s", err)
+	}
+	commits := make(map[string]bool, 64)
+	tree.Tree.Walk(func(node *tighistory.NTree[*tighistory.TigCommit]) {
+		commit := node.Value
+		if commit == nil {
+			return
+		}
+		if commits[commit.Id] {
+			report.problem("duplicate commit %s", commit.Id)
+		}
+		commits[commit.Id] = true
+		parentId := "-"
+		if node.Parent != nil && node.Parent.Value != nil {
+			parentId = node.Parent.Value.Id
+		}
+		if commit.ParentId != parentId && !(parentId == "-" && shallow[commit.Id]) {
+			report.problem("broken link from commit %s to parent %s", commit.Id, commit.ParentId)
+		}
+		resolve(commit.Changes, "commit "+commit.Id)
+	})
+	roots := make([]string, 0, len(tree.Refs)+1)
+	refNames := make([]string, 0, len(tree.Refs))
+	for name := range tree.Refs {
+		refNames = append(refNames, name)
+	}
+	slices.Sort(refNames)
+	for _, name := range refNames {
+		if id := tree.Refs[name]; !commits[id] {
+			report.problem("bad ref %s: commit %s does not exist", name, id)
+		} else {
+			roots = append(roots, id)
+		}
+	}
+	head, err := tigfile.ReadFileBytes(path.Join(ctx.TigPath, tighistory.TigHeadFileName), -1)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("Fsck: %w", err)
+	}
+	if headId := strings.TrimSpace(string(head)); len(headId) > 0 && headId != "-" &&
+		!strings.HasPrefix(headId, tighistory.HEAD_REF_PREFIX) {
+		if !commits[headId] {
+			report.problem("bad HEAD: commit %s does not exist", headId)
+		} else {
+			roots = append(roots, headId)
+		}
+	}
+
+	stashes, err := tigstash.LoadUnresolved(*ctx)
+	if err != nil {
+		report.problem("bad stash file: %s", err)
+	}
+	for i, stash := range stashes {
+		owner := fmt.Sprintf("stash@{%d}", i)
+		if commits[stash.ParentId] {
+			roots = append(roots, stash.ParentId)
+		} else if stash.ParentId != "-" {
+			report.problem("broken link from %s to parent %s", owner, stash.ParentId)
+		}
+		resolve(stash.Changes, owner)
+		resolve(stash.Index, owner+" index")
+	}
+
+	index, err := tigindexfile.Load(ctx.TigPath)
+	if err != nil {
+		report.problem("bad index: %s", err)
+	} else {
+		for _, entry := range index.Entries {
+			if !entry.Has(tigindexfile.FLAG_STAGED) {
+				continue
+			}
+			change := tighistory.TigChange{Path: entry.Path, FileSnapshot: &tigfs.TigFileSnapshot{Hash: entry.StagedHash}}
+			resolve([]tighistory.TigChange{change}, "index")
+		}
+	}
+
+	// Dangling objects, only meaningful if nothing is missing
+	if len(report.Problems) > 0 {
+		return report, nil
+	}
+	for _, name := range names {
+		if !referencedBlobs[name] {
+			report.dangling("dangling blob %s", name)
+		}
+	}
+	for _, name := range objectNames {
+		if pointers[name] == nil {
+			report.dangling("dangling LFS object %s", name)
+		}
+	}
+	for _, snapshot := range snapshots(ctx.FS) {
+		if !referenced[snapshot] {
+			report.dangling("dangling snapshot %s of %s", snapshot.Hash, snapshot.File.Path)
+		}
+	}
+	reachable := make(map[string]bool, len(commits))
+	for _, root := range roots {
+		for ptr := tree.Get(root); ptr != nil && ptr.Value != nil && !reachable[ptr.Value.Id]; ptr = ptr.Parent {
+			reachable[ptr.Value.Id] = true
+		}
+	}
+	// An unreachable commit is reported once, at the tip of its unreachable branch
+	tree.Tree.Walk(func(node *tighistory.NTree[*tighistory.TigCommit]) {
+		if node.Value != nil && len(node.Childs) == 0 && !reachable[node.Value.Id] {
+			report.dangling("dangling commit %s", node.Value.Id)
+		}
+	})
+	return report, nil
+}
@@ -0,0 +1,145 @@
+package tigmaint
+
+import (
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfile"
+	"tig/internal/tighistory"
+	"tig/internal/tigindex"
+)
+
+// newTestRepo create a repository in a temporary directory, move to it and commit files
+func newTestRepo(t *testing.T, files map[string]string) tigconfig.TigCtx {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+	ctx := tigconfig.TigCtx{ProjectPath: dir, TigPath: path.Join(dir, tigconfig.TigRootPath)}
+	if err := ctx.Init(); err != nil {
+		t.Fatalf("Init(): %s", err)
+	}
+	if err := ctx.LoadConfig(); err != nil {
+		t.Fatalf("LoadConfig(): %s", err)
+	}
+	if err := ctx.LoadFS(); err != nil {
+		t.Fatalf("LoadFS(): %s", err)
+	}
+	var paths []string
+	for filePath, content := range files {
+		if err := tigfile.WriteFileString(filePath, content); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, filePath)
+	}
+	tree, err := tighistory.LoadCommits(ctx)
+	if err != nil {
+		t.Fatalf("LoadCommits(): %s", err)
+	}
+	if err := tigindex.AddFile(ctx, tree, paths, tigindex.ADD_PATHS); err != nil {
+		t.Fatalf("AddFile(): %s", err)
+	}
+	if err := tighistory.Commit(ctx, tree, "first"); err != nil {
+		t.Fatalf("Commit(): %s", err)
+	}
+	return ctx
+}
+
+// fsck run [Fsck] and return the lines of its report
+func fsck(t *testing.T, ctx tigconfig.TigCtx) ([]string, []string) {
+	report, err := Fsck(&ctx)
+	if err != nil {
+		t.Fatalf("Fsck(): %s", err)
+	}
+	return report.Problems, report.Dangling
+}
+
+func TestFsck(t *testing.T) {
+	ctx := newTestRepo(t, map[string]string{"a.txt": "a\n", "b.txt": "b\n"})
+	if problems, dangling := fsck(t, ctx); len(problems)+len(dangling) != 0 {
+		t.Fatalf("Fsck() of a clean repository must report nothing: %v %v", problems, dangling)
+	}
+
+	// A blob no snapshot references is dangling
+	data := []byte("lost\n")
+	lost := tigfile.HashBytes(data)
+	if err := tigfile.WriteFileAtomic(path.Join(ctx.FS.DirPath, lost), data); err != nil {
+		t.Fatal(err)
+	}
+	if problems, dangling := fsck(t, ctx); len(problems) != 0 || !slices.Equal(dangling, []string{"dangling blob " + lost}) {
+		t.Fatalf("Fsck() must report the dangling blob %s: %v %v", lost, problems, dangling)
+	}
+
+	// A blob whose content does not match its name, a missing blob, a ref to a missing commit
+	blob := tigfile.HashBytes([]byte("a\n"))
+	if err := tigfile.WriteFileAtomic(path.Join(ctx.FS.DirPath, lost), []byte("changed\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path.Join(ctx.FS.DirPath, blob)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tigfile.WriteFileString(path.Join(ctx.TigPath, "refs/heads/ghost"), "0123456789\n"); err != nil {
+		t.Fatal(err)
+	}
+	problems, _ := fsck(t, ctx)
+	for _, expected := range []string{"bad blob " + lost, "missing blob " + blob, "bad ref refs/heads/ghost"} {
+		if !slices.ContainsFunc(problems, func(line string) bool { return strings.HasPrefix(line, expected) }) {
+			t.Fatalf("Fsck() must report %q: %v", expected, problems)
+		}
+	}
+}
+
+func TestFsckLFS(t *testing.T) {
+	ctx := newTestRepo(t, map[string]string{"a.txt": "a\n"})
+	tree, err := tighistory.LoadCommits(ctx)
+	if err != nil {
+		t.Fatalf("LoadCommits(): %s", err)
+	}
+	if err := ctx.FS.LFS.Track([]string{"*.bin"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tigfile.WriteFileString("big.bin", "big\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tigindex.AddFile(ctx, tree, []string{"big.bin"}, tigindex.ADD_PATHS); err != nil {
+		t.Fatalf("AddFile(): %s", err)
+	}
+	if err := tighistory.Commit(ctx, tree, "big"); err != nil {
+		t.Fatalf("Commit(): %s", err)
+	}
+	if problems, dangling := fsck(t, ctx); len(problems)+len(dangling) != 0 {
+		t.Fatalf("Fsck() of a repository with a large file must report nothing: %v %v", problems, dangling)
+	}
+
+	// An LFS object no pointer file references is dangling
+	lost, err := ctx.FS.LFS.Import(strings.NewReader("lost\n"))
+	if err != nil {
+		t.Fatalf("Import(): %s", err)
+	}
+	if problems, dangling := fsck(t, ctx); len(problems) != 0 || !slices.Equal(dangling, []string{"dangling LFS object " + lost.Hash}) {
+		t.Fatalf("Fsck() must report the dangling LFS object %s: %v %v", lost.Hash, problems, dangling)
+	}
+
+	// An LFS object whose content does not match its name, a missing LFS object
+	big := tigfile.HashBytes([]byte("big\n"))
+	if err := tigfile.WriteFileAtomic(ctx.FS.LFS.ObjectPath(lost.Hash), []byte("changed\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(ctx.FS.LFS.ObjectPath(big)); err != nil {
+		t.Fatal(err)
+	}
+	problems, _ := fsck(t, ctx)
+	for _, expected := range []string{"bad LFS object " + lost.Hash, "missing LFS object " + big} {
+		if !slices.ContainsFunc(problems, func(line string) bool { return strings.HasPrefix(line, expected) }) {
+			t.Fatalf("Fsck() must report %q: %v", expected, problems)
+		}
+	}
+}
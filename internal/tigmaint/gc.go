@@ -0,0 +1,313 @@
+package tigmaint
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfile"
+	"tig/internal/tigfs"
+	"tig/internal/tighistory"
+	"tig/internal/tigstash"
+	"time"
+)
+
+// Default age under which unreachable objects are kept: two weeks
+const DEFAULT_GC_GRACE = 14 * 24 * time.Hour
+
+// PruneOptions are the options of [Prune]
+type PruneOptions struct {
+	DryRun  bool          // Report what would be removed, remove nothing
+	Grace   time.Duration // Unreachable objects younger than Grace are kept, a concurrent writer may be using them
+	Compact bool          // Rewrite the FS index file even if nothing is removed
+}
+
+// PruneReport is the result of [Prune]
+type PruneReport struct {
+	Commits    int
+	Snapshots  int
+	Blobs      int
+	LFSObjects int
+	Bytes      int64 // Size of the removed blobs and LFS objects
+}
+
+// ParseGrace parse a grace period: a duration ("12h"), a number of days ("14d") or "now"
+func ParseGrace(value string) (time.Duration, error) {
+	if value == "now" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err == nil && n >= 0 {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	}
+	grace, err := time.ParseDuration(value)
+	if err != nil || grace < 0 {
+		return 0, fmt.Errorf("Bad grace period %s, must be like 12h, 14d or now", value)
+	}
+	return grace, nil
+}
+
+// Grace return the configured grace period, see [ParseGrace]
+func Grace(ctx tigconfig.TigCtx) (time.Duration, error) {
+	value := ctx.GetConfig(tigconfig.CONFIG_GC_GRACE, "")
+	if len(value) == 0 {
+		return DEFAULT_GC_GRACE, nil
+	}
+	return ParseGrace(value)
+}
+
+// markCommits return the ids of the commits reachable from the refs, HEAD and the stashes.
+// Tig keeps no reflog: a commit left behind by a moved or deleted ref is only kept by the grace period.
+func markCommits(tree *tighistory.TigCommitTree, stashes tigstash.TigStashList) map[string]bool {
+	roots := make([]*tighistory.NTree[*tighistory.TigCommit], 0, len(tree.Refs)+len(stashes)+1)
+	roots = append(roots, tree.Head)
+	for _, id := range tree.Refs {
+		roots = append(roots, tree.Get(id))
+	}
+	for _, stash := range stashes {
+		roots = append(roots, tree.Get(stash.ParentId))
+	}
+	marked := make(map[string]bool, 64)
+	for _, root := range roots {
+		for ptr := root; ptr != nil && ptr.Value != nil && !marked[ptr.Value.Id]; ptr = ptr.Parent {
+			marked[ptr.Value.Id] = true
+		}
+	}
+	return marked
+}
+
+// sweepCommits detach the unreachable commits whose subtree was written before expire, return them.
+// The write time is used, not the date: a fetched commit may be much older than its copy here.
+func sweepCommits(tree *tighistory.TigCommitTree, marked map[string]bool, expire time.Time) []*tighistory.NTree[*tighistory.TigCommit] {
+	// Children first, a commit can only go with all its descendants
+	var nodes []*tighistory.NTree[*tighistory.TigCommit]
+	tree.Tree.Walk(func(node *tighistory.NTree[*tighistory.TigCommit]) {
+		nodes = append(nodes, node)
+	})
+	removable := make(map[*tighistory.NTree[*tighistory.TigCommit]]bool, len(nodes))
+	for i := len(nodes) - 1; i >= 0; i-- {
+		node := nodes[i]
+		if node.Value == nil || marked[node.Value.Id] || node.Value.WrittenTime().After(expire) {
+			continue
+		}
+		removable[node] = true
+		for _, child := range node.Childs {
+			removable[node] = removable[node] && removable[child]
+		}
+	}
+	var removed []*tighistory.NTree[*tighistory.TigCommit]
+	for _, node := range nodes {
+		if !removable[node] || removable[node.Parent] {
+			continue
+		}
+		node.Walk(func(sub *tighistory.NTree[*tighistory.TigCommit]) {
+			removed = append(removed, sub)
+		})
+		node.Parent.Remove(node)
+	}
+	return removed
+}
+
+// blobTime return the modification time of a blob, the zero time if it does not exist
+func blobTime(blobPath string) (time.Time, int64, error) {
+	info, err := os.Stat(blobPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return time.Time{}, 0, nil
+		}
+		return time.Time{}, 0, err
+	}
+	return info.ModTime(), info.Size(), nil
+}
+
+// Prune remove the commits, the snapshots and the blobs nothing references. A mark and sweep:
+//   - the commits reachable from the refs, HEAD and the parents of the stashes are marked, there
+//     is no reflog to mark from
+//   - the snapshots of the marked commits, of the stashes and of the staged changes are marked
+//   - the blobs of the kept snapshots, and the LFS objects of their pointer files, are marked
+//   - the unmarked objects older than the grace period are removed
+//
+// The tree is saved before the FS index, and the FS index before the blobs are removed, so an
+// interruption only leaves unreferenced objects behind. The LFS cache, shared, is not swept.
+// The lock must be held.
+func Prune(ctx tigconfig.TigCtx, tree *tighistory.TigCommitTree, options PruneOptions, w io.Writer) (*PruneReport, error) {
+	report := &PruneReport{}
+	expire := time.Now().Add(-options.Grace)
+	verb := "Removing"
+	if options.DryRun {
+		verb = "Would remove"
+	}
+
+	stashes, err := tigstash.Load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Prune: %w", err)
+	}
+	current, err := tighistory.GetCurrentCommit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Prune: %w", err)
+	}
+
+	// Commits
+	removed := sweepCommits(tree, markCommits(tree, stashes), expire)
+	for _, node := range removed {
+		fmt.Fprintf(w, "%s commit %s\n", verb, node.Value.Id)
+	}
+	report.Commits = len(removed)
+
+	// Snapshots
+	marked := make(map[*tigfs.TigFileSnapshot]bool, 64)
+	markChanges := func(changes []tighistory.TigChange) {
+		for _, change := range changes {
+			marked[change.FileSnapshot] = true
+		}
+	}
+	tree.Tree.Walk(func(node *tighistory.NTree[*tighistory.TigCommit]) {
+		if node.Value != nil {
+			markChanges(node.Value.Changes)
+		}
+	})
+	for _, stash := range stashes {
+		markChanges(stash.Changes)
+		markChanges(stash.Index)
+	}
+	markChanges(current.Changes)
+	var sweptSnapshots []*tigfs.TigFileSnapshot
+	for _, snapshot := range snapshots(ctx.FS) {
+		if marked[snapshot] {
+			continue
+		}
+		modTime, _, err := blobTime(snapshot.BlobPath())
+		if err != nil {
+			return nil, fmt.Errorf("Prune: %w", err)
+		}
+		if modTime.After(expire) {
+			continue
+		}
+		fmt.Fprintf(w, "%s snapshot %s of %s\n", verb, snapshot.Hash, snapshot.File.Path)
+		sweptSnapshots = append(sweptSnapshots, snapshot)
+	}
+	report.Snapshots = len(sweptSnapshots)
+
+	// Blobs, shared by the snapshots of the same content
+	used := make(map[string]bool, 64)
+	swept := make(map[*tigfs.TigFileSnapshot]bool, len(sweptSnapshots))
+	for _, snapshot := range sweptSnapshots {
+		swept[snapshot] = true
+	}
+	for _, snapshot := range snapshots(ctx.FS) {
+		if !swept[snapshot] {
+			used[snapshot.Path] = true
+		}
+	}
+	names, err := blobNames(ctx.FS)
+	if err != nil {
+		return nil, fmt.Errorf("Prune: %w", err)
+	}
+	entries, err := os.ReadDir(ctx.FS.DirPath)
+	if err != nil {
+		return nil, fmt.Errorf("Prune: %w", err)
+	}
+	// Temporary files of the interrupted atomic writes
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), tigfile.ATOMIC_TMP_PREFIX) {
+			names = append(names, entry.Name())
+		}
+	}
+	var sweptBlobs []string
+	for _, name := range names {
+		if used[name] {
+			continue
+		}
+		modTime, size, err := blobTime(path.Join(ctx.FS.DirPath, name))
+		if err != nil {
+			return nil, fmt.Errorf("Prune: %w", err)
+		}
+		if modTime.After(expire) {
+			continue
+		}
+		fmt.Fprintf(w, "%s blob %s\n", verb, name)
+		sweptBlobs = append(sweptBlobs, name)
+		report.Bytes += size
+	}
+	report.Blobs = len(sweptBlobs)
+
+	// LFS objects, of the pointer files of the kept snapshots
+	var kept []*tigfs.TigFileSnapshot
+	for _, snapshot := range snapshots(ctx.FS) {
+		if !swept[snapshot] {
+			kept = append(kept, snapshot)
+		}
+	}
+	pointers, err := lfsPointers(kept)
+	if err != nil {
+		return nil, fmt.Errorf("Prune: %w", err)
+	}
+	objectNames, err := lfsNames(ctx.FS.LFS)
+	if err != nil {
+		return nil, fmt.Errorf("Prune: %w", err)
+	}
+	// Temporary files of the interrupted imports
+	lfsEntries, err := os.ReadDir(ctx.FS.LFS.DirPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("Prune: %w", err)
+	}
+	for _, entry := range lfsEntries {
+		if strings.HasPrefix(entry.Name(), tigfile.ATOMIC_TMP_PREFIX) {
+			objectNames = append(objectNames, entry.Name())
+		}
+	}
+	var sweptObjects []string
+	for _, name := range objectNames {
+		if pointers[name] != nil {
+			continue
+		}
+		modTime, size, err := blobTime(ctx.FS.LFS.ObjectPath(name))
+		if err != nil {
+			return nil, fmt.Errorf("Prune: %w", err)
+		}
+		if modTime.After(expire) {
+			continue
+		}
+		fmt.Fprintf(w, "%s LFS object %s\n", verb, name)
+		sweptObjects = append(sweptObjects, name)
+		report.Bytes += size
+	}
+	report.LFSObjects = len(sweptObjects)
+	if options.DryRun {
+		return report, nil
+	}
+
+	if len(removed) > 0 {
+		if err := tree.Save(ctx); err != nil {
+			return nil, fmt.Errorf("Prune: %w", err)
+		}
+	}
+	for _, snapshot := range sweptSnapshots {
+		ctx.FS.Forget(snapshot)
+	}
+	if options.Compact {
+		err = ctx.FS.Compact()
+	} else {
+		err = ctx.FS.Save()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Prune: %w", err)
+	}
+	for _, name := range sweptBlobs {
+		if err := os.Remove(path.Join(ctx.FS.DirPath, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("Prune: %w", err)
+		}
+	}
+	for _, name := range sweptObjects {
+		if err := os.Remove(ctx.FS.LFS.ObjectPath(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("Prune: %w", err)
+		}
+	}
+	return report, nil
+}
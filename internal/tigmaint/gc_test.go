@@ -0,0 +1,108 @@
+package tigmaint
+
+import (
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"tig/internal/tigfile"
+	"tig/internal/tighistory"
+	"tig/internal/tigindex"
+	"time"
+)
+
+func TestPrune(t *testing.T) {
+	ctx := newTestRepo(t, map[string]string{"a.txt": "a\n"})
+	tree, err := tighistory.LoadCommits(ctx)
+	if err != nil {
+		t.Fatalf("LoadCommits(): %s", err)
+	}
+
+	// A committed large file, and an LFS object nothing references
+	if err := ctx.FS.LFS.Track([]string{"*.bin"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tigfile.WriteFileString("big.bin", "big\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tigindex.AddFile(ctx, tree, []string{"big.bin"}, tigindex.ADD_PATHS); err != nil {
+		t.Fatalf("AddFile(): %s", err)
+	}
+	if err := tighistory.Commit(ctx, tree, "big"); err != nil {
+		t.Fatalf("Commit(): %s", err)
+	}
+	big := tigfile.HashBytes([]byte("big\n"))
+	orphan, err := ctx.FS.LFS.Import(strings.NewReader("orphan\n"))
+	if err != nil {
+		t.Fatalf("Import(): %s", err)
+	}
+
+	// An unreachable commit written recently with an old date, and a snapshot replaced before it was committed
+	lost := &tighistory.TigCommit{Id: "lost", ParentId: tree.HeadId(), Date: time.Now().AddDate(-1, 0, 0).Unix(),
+		Written: time.Now().Add(-time.Hour).Unix(), Changes: tree.Head.Value.Changes}
+	tree.Head.Add(lost)
+	if err := tree.Save(ctx); err != nil {
+		t.Fatalf("Save(): %s", err)
+	}
+	for _, content := range []string{"staged\n", "replaced\n"} {
+		if err := tigfile.WriteFileString("a.txt", content); err != nil {
+			t.Fatal(err)
+		}
+		if err := tigindex.AddFile(ctx, tree, []string{"a.txt"}, tigindex.ADD_PATHS); err != nil {
+			t.Fatalf("AddFile(): %s", err)
+		}
+	}
+	staged := tigfile.HashBytes([]byte("staged\n"))
+
+	// Everything is younger than the grace period
+	report, err := Prune(ctx, tree, PruneOptions{Grace: 2 * time.Hour}, io.Discard)
+	if err != nil {
+		t.Fatalf("Prune(): %s", err)
+	}
+	if *report != (PruneReport{}) {
+		t.Fatalf("Prune() must keep the objects younger than the grace period: %+v", report)
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		ctx.FS = nil
+		if err := ctx.LoadFS(); err != nil {
+			t.Fatalf("LoadFS(): %s", err)
+		}
+		tree, err = tighistory.LoadCommits(ctx)
+		if err != nil {
+			t.Fatalf("LoadCommits(): %s", err)
+		}
+		report, err := Prune(ctx, tree, PruneOptions{DryRun: dryRun}, io.Discard)
+		if err != nil {
+			t.Fatalf("Prune(dry run %v): %s", dryRun, err)
+		}
+		expected := PruneReport{Commits: 1, Snapshots: 1, Blobs: 1, LFSObjects: 1,
+			Bytes: int64(len("staged\n") + len("orphan\n"))}
+		if *report != expected {
+			t.Fatalf("Prune(dry run %v) must report %+v, not %+v", dryRun, expected, *report)
+		}
+		_, err = os.Stat(path.Join(ctx.FS.DirPath, staged))
+		if dryRun != (err == nil) {
+			t.Fatalf("Prune(dry run %v) blob %s must be kept only in dry run: %v", dryRun, staged, err)
+		}
+		_, err = os.Stat(ctx.FS.LFS.ObjectPath(orphan.Hash))
+		if dryRun != (err == nil) {
+			t.Fatalf("Prune(dry run %v) LFS object %s must be kept only in dry run: %v", dryRun, orphan.Hash, err)
+		}
+		if _, err := os.Stat(ctx.FS.LFS.ObjectPath(big)); err != nil {
+			t.Fatalf("Prune(dry run %v) must keep the LFS object %s of big.bin: %s", dryRun, big, err)
+		}
+	}
+
+	tree, err = tighistory.LoadCommits(ctx)
+	if err != nil {
+		t.Fatalf("LoadCommits() after Prune(): %s", err)
+	}
+	if tree.Get("lost") != nil {
+		t.Fatalf("Prune() must remove the unreachable commit")
+	}
+	if problems, dangling := fsck(t, ctx); len(problems)+len(dangling) != 0 {
+		t.Fatalf("Fsck() after Prune() must report nothing: %v %v", problems, dangling)
+	}
+}
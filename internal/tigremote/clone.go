@@ -0,0 +1,289 @@
+// Package tigremote contains the commands exchanging commits with other repositories
+package tigremote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfs"
+	"tig/internal/tighistory"
+	"tig/internal/tigindex"
+)
+
+// Name of the remote a repository is cloned from
+const DEFAULT_REMOTE = "origin"
+
+// Clone copy the repository source into the new directory dest: its FS snapshots, its commits and its
+// branches, as remote branches of origin. The branch checked out in source is checked out in dest.
+// With depth > 0, only the depth last commits of this branch are copied. source is a path of the
+// local filesystem, the URL of a repository served by tig serve, or an ssh:// URL. The LFS objects of
+// the copied pointer files are copied too.
+func Clone(source string, dest string, depth int) error {
+	if isURL(source) {
+		return cloneRemote(source, dest, depth)
+	}
+	return cloneLocal(source, dest, depth)
+}
+
+// cloneLocal clone the repository at the path source, its snapshots are hardlinked when possible
+func cloneLocal(source string, dest string, depth int) (err error) {
+	srcCtx, err := tigconfig.OpenRepository(source)
+	if err != nil {
+		return fmt.Errorf("Clone: %w", err)
+	}
+	if err := srcCtx.LoadFS(); err != nil {
+		return fmt.Errorf("Clone: %w", err)
+	}
+	srcTree, err := tighistory.LoadCommits(srcCtx)
+	if err != nil {
+		return fmt.Errorf("Clone: %w", err)
+	}
+	url, err := filepath.Abs(source)
+	if err != nil {
+		return fmt.Errorf("Clone: %w", err)
+	}
+
+	ctx, cleanup, err := initCloneDest(dest)
+	if err != nil {
+		return fmt.Errorf("Clone: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			cleanup()
+		}
+	}()
+	lock, err := ctx.Lock()
+	if err != nil {
+		return fmt.Errorf("Clone: %w", err)
+	}
+	defer lock.Unlock()
+
+	branch := srcTree.Branch
+	if len(branch) == 0 {
+		branch = srcCtx.DefaultBranch()
+	}
+	tip := srcTree.RefNode(tighistory.REF_HEADS + branch)
+	tree := &tighistory.TigCommitTree{Branch: branch}
+	branches := make(map[string]string, len(srcTree.Refs))
+	if depth > 0 {
+		root, head := srcTree.ShallowHistory(tip, depth)
+		tree.Tree, tree.Head = *root, head
+		if tip != nil {
+			branches[tighistory.REF_HEADS+branch] = tip.Value.Id
+			if err := tighistory.SaveShallow(ctx, []string{root.Childs[0].Value.Id}); err != nil {
+				return fmt.Errorf("Clone: %w", err)
+			}
+		}
+	} else {
+		tree.Tree, tree.Head = srcTree.Tree, tip
+		for name, id := range AdvertiseRefs(srcTree).Refs {
+			branches[name] = id
+		}
+	}
+	if tree.Head == nil {
+		tree.Head = &tree.Tree
+	}
+
+	// Snapshots first, the commits reference them
+	if _, err := ctx.FS.Import(srcCtx.FS, referencedBy(&tree.Tree), true); err != nil {
+		return fmt.Errorf("Clone: %w", err)
+	}
+	pointers, err := fsLFSPointers(ctx.FS)
+	if err != nil {
+		return fmt.Errorf("Clone: %w", err)
+	}
+	if err := downloadLFS(ctx, &localRemote{url: url}, pointers, io.Discard); err != nil {
+		return fmt.Errorf("Clone: %w", err)
+	}
+	return finishClone(ctx, tree, url, branches)
+}
+
+// cloneRemote clone the repository served at url, its commits and snapshots are downloaded in a pack
+func cloneRemote(url string, dest string, depth int) (err error) {
+	// The destination has no config yet, only the environment can set the ssh command
+	var noConfig tigconfig.TigCtx
+	remote, err := openURL(url, noConfig.SSHCommand())
+	if err != nil {
+		return fmt.Errorf("Clone: %w", err)
+	}
+	defer remote.Close()
+	adv, err := remote.Refs()
+	if err != nil {
+		return fmt.Errorf("Clone: %w", err)
+	}
+	if err := checkAdvertisement(adv); err != nil {
+		return fmt.Errorf("Clone: %w", err)
+	}
+
+	ctx, cleanup, err := initCloneDest(dest)
+	if err != nil {
+		return fmt.Errorf("Clone: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			cleanup()
+		}
+	}()
+	lock, err := ctx.Lock()
+	if err != nil {
+		return fmt.Errorf("Clone: %w", err)
+	}
+	defer lock.Unlock()
+
+	branch := adv.Head
+	if len(branch) == 0 {
+		branch = ctx.DefaultBranch()
+	}
+	tip, ok := adv.Refs[tighistory.REF_HEADS+branch]
+	branches := adv.Refs
+	if depth > 0 {
+		branches = make(map[string]string, 1)
+		if ok {
+			branches[tighistory.REF_HEADS+branch] = tip
+		}
+	}
+	var wants []string
+	for _, id := range branches {
+		if !slices.Contains(wants, id) {
+			wants = append(wants, id)
+		}
+	}
+	slices.Sort(wants)
+
+	tree := &tighistory.TigCommitTree{Branch: branch}
+	tree.Head = &tree.Tree
+	if len(wants) > 0 {
+		pack, err := fetchPack(ctx, tree, remote, wants, nil, depth)
+		if err != nil {
+			return fmt.Errorf("Clone: %w", err)
+		}
+		pointers, err := packLFSPointers(ctx.FS, pack)
+		if err != nil {
+			return fmt.Errorf("Clone: %w", err)
+		}
+		if err := downloadLFS(ctx, remote, pointers, io.Discard); err != nil {
+			return fmt.Errorf("Clone: %w", err)
+		}
+	}
+	if ok {
+		if tree.Head = tree.Get(tip); tree.Head == nil {
+			return fmt.Errorf("Clone: commit %s of %s was not received", tip, branch)
+		}
+	}
+	return finishClone(ctx, tree, url, branches)
+}
+
+// finishClone save the tree of the clone, its remote branches and its origin remote, then check out HEAD
+func finishClone(ctx tigconfig.TigCtx, tree *tighistory.TigCommitTree, url string, branches map[string]string) error {
+	if err := tree.Save(ctx); err != nil {
+		return fmt.Errorf("Clone: %w", err)
+	}
+	names := make([]string, 0, len(branches))
+	for name := range branches {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		remoteRef := tighistory.REF_REMOTES + DEFAULT_REMOTE + "/" + tighistory.ShortRefName(name)
+		if err := tree.SetRef(ctx, remoteRef, branches[name]); err != nil {
+			return fmt.Errorf("Clone: %w", err)
+		}
+	}
+
+	ctx.SetRemote(DEFAULT_REMOTE, url)
+	ctx.SetBranchUpstream(tree.Branch, DEFAULT_REMOTE, tree.Branch)
+	if err := ctx.SaveConfig(); err != nil {
+		return fmt.Errorf("Clone: %w", err)
+	}
+	return checkout(ctx)
+}
+
+// initCloneDest create the repository dest, which must not exist or be an empty directory.
+// cleanup remove what the clone wrote in dest, dest itself if it did not exist.
+func initCloneDest(dest string) (ctx tigconfig.TigCtx, cleanup func(), err error) {
+	absDest, err := filepath.Abs(dest)
+	if err != nil {
+		return ctx, nil, err
+	}
+	entries, err := os.ReadDir(absDest)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return ctx, nil, err
+	}
+	if len(entries) > 0 {
+		return ctx, nil, fmt.Errorf("Destination %s already exists and is not empty", dest)
+	}
+	created := err != nil
+	if err := os.MkdirAll(absDest, 0o755); err != nil {
+		return ctx, nil, err
+	}
+	cleanup = func() {
+		if created {
+			os.RemoveAll(absDest)
+			return
+		}
+		entries, _ := os.ReadDir(absDest)
+		for _, entry := range entries {
+			os.RemoveAll(path.Join(absDest, entry.Name()))
+		}
+	}
+	if ctx, err = initRepository(absDest); err != nil {
+		cleanup()
+		return ctx, nil, err
+	}
+	return ctx, cleanup, nil
+}
+
+// initRepository create a repository in the directory dir and load it
+func initRepository(dir string) (tigconfig.TigCtx, error) {
+	ctx := tigconfig.TigCtx{ProjectPath: dir, TigPath: path.Join(dir, tigconfig.TigRootPath)}
+	if err := ctx.Init(); err != nil {
+		return ctx, err
+	}
+	if err := ctx.LoadConfig(); err != nil {
+		return ctx, err
+	}
+	if err := ctx.LoadFS(); err != nil {
+		return ctx, err
+	}
+	return ctx, nil
+}
+
+// referencedBy return a filter keeping the snapshots referenced by the commits of tree
+func referencedBy(tree *tighistory.NTree[*tighistory.TigCommit]) func(*tigfs.TigFileSnapshot) bool {
+	referenced := make(map[*tigfs.TigFileSnapshot]bool, 64)
+	tree.Walk(func(node *tighistory.NTree[*tighistory.TigCommit]) {
+		if node.Value == nil {
+			return
+		}
+		for _, change := range node.Value.Changes {
+			referenced[change.FileSnapshot] = true
+		}
+	})
+	return func(snapshot *tigfs.TigFileSnapshot) bool {
+		return referenced[snapshot]
+	}
+}
+
+// checkout write the files of HEAD in the project directory of ctx and track them
+func checkout(ctx tigconfig.TigCtx) error {
+	tree, err := tighistory.LoadCommits(ctx)
+	if err != nil {
+		return fmt.Errorf("Checkout: %w", err)
+	}
+	tracked := make(map[string]bool, 32)
+	for filePath, snapshot := range tree.HeadState() {
+		if err := snapshot.Restore(path.Join(ctx.ProjectPath, filePath)); err != nil {
+			return fmt.Errorf("Checkout: %w", err)
+		}
+		tracked[filePath] = true
+	}
+	if err := tigindex.SetTrackedFiles(ctx, tracked); err != nil {
+		return fmt.Errorf("Checkout: %w", err)
+	}
+	return nil
+}
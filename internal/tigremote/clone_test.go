@@ -0,0 +1,136 @@
+package tigremote
+
+import (
+	"os"
+	"path"
+	"testing"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfile"
+	"tig/internal/tighistory"
+	"tig/internal/tigindex"
+)
+
+// chdir move to dir until the end of the test, tig paths are relative to the project
+func chdir(t *testing.T, dir string) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+}
+
+// openTestRepo load the repository of the current directory
+func openTestRepo(t *testing.T) (tigconfig.TigCtx, *tighistory.TigCommitTree) {
+	ctx, err := tigconfig.OpenRepository(".")
+	if err != nil {
+		t.Fatalf("OpenRepository(): %s", err)
+	}
+	if err := ctx.LoadFS(); err != nil {
+		t.Fatalf("LoadFS(): %s", err)
+	}
+	tree, err := tighistory.LoadCommits(ctx)
+	if err != nil {
+		t.Fatalf("LoadCommits(): %s", err)
+	}
+	return ctx, tree
+}
+
+// commitFiles write the files in the current repository and commit them
+func commitFiles(t *testing.T, msg string, files map[string]string) {
+	var paths []string
+	for filePath, content := range files {
+		if err := tigfile.WriteFileString(filePath, content); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, filePath)
+	}
+	ctx, tree := openTestRepo(t)
+	if err := tigindex.AddFile(ctx, tree, paths, tigindex.ADD_PATHS); err != nil {
+		t.Fatalf("AddFile(): %s", err)
+	}
+	if err := tighistory.Commit(ctx, tree, msg); err != nil {
+		t.Fatalf("Commit(): %s", err)
+	}
+}
+
+// newTestRepo create a repository in a temporary directory and move to it
+func newTestRepo(t *testing.T) string {
+	dir := t.TempDir()
+	chdir(t, dir)
+	ctx := tigconfig.TigCtx{ProjectPath: dir, TigPath: path.Join(dir, tigconfig.TigRootPath)}
+	if err := ctx.Init(); err != nil {
+		t.Fatalf("Init(): %s", err)
+	}
+	return dir
+}
+
+func TestClone(t *testing.T) {
+	source := newTestRepo(t)
+	commitFiles(t, "one", map[string]string{"a.txt": "1\n", "b.txt": "b\n"})
+	commitFiles(t, "two", map[string]string{"a.txt": "1\n2\n"})
+	commitFiles(t, "three", map[string]string{"a.txt": "1\n2\n3\n"})
+
+	for _, depth := range []int{0, 2} {
+		dest := path.Join(t.TempDir(), "clone")
+		if err := Clone(source, dest, depth); err != nil {
+			t.Fatalf("Clone(depth %d): %s", depth, err)
+		}
+		chdir(t, dest)
+		ctx, tree := openTestRepo(t)
+		commits := 0
+		for ptr := tree.Head; ptr != nil && ptr.Value != nil; ptr = ptr.Parent {
+			commits++
+		}
+		if expected := map[int]int{0: 3, 2: 2}[depth]; commits != expected {
+			t.Fatalf("Clone(depth %d) must have %d commits, not %d", depth, expected, commits)
+		}
+		if tree.Branch != "main" || tree.Refs["refs/remotes/origin/main"] != tree.HeadId() {
+			t.Fatalf("Clone(depth %d) must check out main, tracking origin/main", depth)
+		}
+		if url := ctx.GetConfig("remote.origin.url", ""); url != source {
+			t.Fatalf("Clone(depth %d) origin url must be %s, not %s", depth, source, url)
+		}
+		for filePath, content := range map[string]string{"a.txt": "1\n2\n3\n", "b.txt": "b\n"} {
+			data, err := tigfile.ReadFileBytes(filePath, -1)
+			if err != nil || string(data) != content {
+				t.Fatalf("Clone(depth %d) %s must contain %q, not %q (%v)", depth, filePath, content, data, err)
+			}
+		}
+		status, err := tigindex.GetStatus(&ctx, tree)
+		if err != nil {
+			t.Fatalf("GetStatus(): %s", err)
+		}
+		if len(status.Unmodified) != 2 || len(status.Modified)+len(status.Untracked)+len(status.Staged) != 0 {
+			t.Fatalf("Clone(depth %d) must have a clean working tree: %+v", depth, status)
+		}
+	}
+}
+
+func TestCloneCleanup(t *testing.T) {
+	source := newTestRepo(t)
+	commitFiles(t, "one", map[string]string{"a.txt": "1\n"})
+	// A missing blob makes the clone fail once dest is initialized
+	ctx, _ := openTestRepo(t)
+	if err := os.Remove(path.Join(ctx.FS.DirPath, tigfile.HashBytes([]byte("1\n")))); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := path.Join(t.TempDir(), "clone")
+	if err := Clone(source, dest, 0); err == nil {
+		t.Fatalf("Clone() of a repository with a missing blob must fail")
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Fatalf("Clone() must remove the destination it created: %v", err)
+	}
+	// An existing empty destination is kept, empty
+	dest = t.TempDir()
+	if err := Clone(source, dest, 0); err == nil {
+		t.Fatalf("Clone() of a repository with a missing blob must fail")
+	}
+	if entries, err := os.ReadDir(dest); err != nil || len(entries) != 0 {
+		t.Fatalf("Clone() must leave the existing destination empty: %v %v", entries, err)
+	}
+}
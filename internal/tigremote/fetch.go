@@ -0,0 +1,96 @@
+package tigremote
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"tig/internal/tigconfig"
+	"tig/internal/tighistory"
+)
+
+// DefaultRemote return the remote tracked by the current branch, or origin
+func DefaultRemote(ctx tigconfig.TigCtx, tree *tighistory.TigCommitTree) string {
+	if remote, _, ok := ctx.BranchUpstream(tree.Branch); ok && remote != "." {
+		return remote
+	}
+	return DEFAULT_REMOTE
+}
+
+// writeRefUpdate print the move of a ref from old to new, like "   1a2b3c4..5d6e7f8  main -> origin/main"
+func writeRefUpdate(w io.Writer, old string, new string, from string, to string, forced bool) {
+	short := func(id string) string { return id[:min(len(id), 7)] }
+	if len(old) == 0 {
+		fmt.Fprintf(w, " * [new branch]      %s -> %s\n", from, to)
+	} else if forced {
+		fmt.Fprintf(w, " + %s...%s %s -> %s (forced update)\n", short(old), short(new), from, to)
+	} else {
+		fmt.Fprintf(w, "   %s..%s  %s -> %s\n", short(old), short(new), from, to)
+	}
+}
+
+// Fetch download the branches of the remote name: the missing commits and snapshots are added,
+// and the remote branches refs/remotes/<name>/<branch> are moved to the tips of the remote.
+// The LFS objects of the pointer files of the commits the remote branches move to are downloaded, the
+// remote branches are not moved if one is missing.
+func Fetch(ctx tigconfig.TigCtx, tree *tighistory.TigCommitTree, name string, w io.Writer) error {
+	remote, err := OpenRemote(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+	adv, err := remote.Refs()
+	if err != nil {
+		return fmt.Errorf("Fetch: %w", err)
+	}
+	if err := checkAdvertisement(adv); err != nil {
+		return fmt.Errorf("Fetch: %w", err)
+	}
+	refs := adv.Refs
+	refNames := make([]string, 0, len(refs))
+	var wants []string
+	for refName, id := range refs {
+		refNames = append(refNames, refName)
+		if tree.Get(id) == nil && !slices.Contains(wants, id) {
+			wants = append(wants, id)
+		}
+	}
+	slices.Sort(refNames)
+	slices.Sort(wants)
+	if len(wants) > 0 {
+		pack, err := fetchPack(ctx, tree, remote, wants, commitIds(tree), 0)
+		if err != nil {
+			return fmt.Errorf("Fetch: %w", err)
+		}
+		fmt.Fprintf(w, "Received %d commits and %d snapshots\n", len(pack.Commits), len(pack.Snapshots))
+	}
+	// From the remote branches, which are not moved by a failed download: it is retried by the next fetch
+	tips, olds := make([]string, 0, len(refs)), make([]string, 0, len(refs))
+	for _, refName := range refNames {
+		tips = append(tips, refs[refName])
+		olds = append(olds, tree.Refs[tighistory.REF_REMOTES+name+"/"+tighistory.ShortRefName(refName)])
+	}
+	pointers, err := commitLFSPointers(tree, tips, reachable(tree, olds))
+	if err != nil {
+		return fmt.Errorf("Fetch: %w", err)
+	}
+	if err := downloadLFS(ctx, remote, pointers, w); err != nil {
+		return fmt.Errorf("Fetch: %w", err)
+	}
+	for _, refName := range refNames {
+		branch := tighistory.ShortRefName(refName)
+		remoteRef := tighistory.REF_REMOTES + name + "/" + branch
+		old, id := tree.Refs[remoteRef], refs[refName]
+		if old == id {
+			continue
+		}
+		if tree.Get(id) == nil {
+			return fmt.Errorf("Fetch: commit %s of %s was not received", id, refName)
+		}
+		if err := tree.SetRef(ctx, remoteRef, id); err != nil {
+			return fmt.Errorf("Fetch: %w", err)
+		}
+		forced := len(old) > 0 && !isAncestor(tree, old, id)
+		writeRefUpdate(w, old, id, branch, name+"/"+branch, forced)
+	}
+	return nil
+}
@@ -0,0 +1,353 @@
+package tigremote
+
+/*
+How to exchange commits over HTTP, <repo> is the path of a repository under the served directory:
+- GET  <repo>/info/refs: the ref advertisement (JSON RefAdvertisement)
+- POST <repo>/upload-pack: the wants and haves (JSON uploadRequest), answered by the pack stream
+- POST <repo>/receive-pack: a line with the ref updates (JSON receiveRequest) followed by the pack stream,
+  answered by "ok"
+- GET  <repo>/lfs/objects/<hash>: the content of an LFS object
+- PUT  <repo>/lfs/objects/<hash>: store the content of an LFS object, answered by "ok"
+- Errors are answered with a status code >= 400 and the error message,
+  409 Conflict for a non fast-forward update, 413 Request Entity Too Large for a body over the limits
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfile"
+	"tig/internal/tigfs"
+	"tig/internal/tighistory"
+	"time"
+)
+
+// URL paths of the HTTP protocol, relative to the repository
+const (
+	HTTP_INFO_REFS    = "/info/refs"
+	HTTP_UPLOAD_PACK  = "/upload-pack"
+	HTTP_RECEIVE_PACK = "/receive-pack"
+	HTTP_LFS_OBJECTS  = "/lfs/objects/" // Followed by the hash of the object
+)
+
+// Size limits of the HTTP bodies
+const (
+	HTTP_MAX_JSON_SIZE         = 32 << 20 // JSON requests and answers: the wants and haves, the refs
+	HTTP_DEFAULT_MAX_BODY_SIZE = 4 << 30  // Streams: the pushed packs and LFS objects, see [Server.MaxBodySize]
+)
+
+// Timeout of the HTTP remotes to connect, and to wait for the answer once the request is sent.
+// The transfer itself has no timeout, a pack or an LFS object can be big.
+const HTTP_TIMEOUT = 5 * time.Minute
+
+// httpClient is the client of the HTTP remotes
+var httpClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   30 * time.Second,
+		ResponseHeaderTimeout: HTTP_TIMEOUT,
+		IdleConnTimeout:       90 * time.Second,
+	},
+}
+
+// uploadRequest is the body of an upload-pack request
+type uploadRequest struct {
+	Wants []string `json:"wants"`
+	Haves []string `json:"haves"`
+	Depth int      `json:"depth,omitempty"`
+}
+
+// receiveRequest is the header of a receive-pack request, the pack stream follows
+type receiveRequest struct {
+	Updates []RefUpdate `json:"updates"`
+	Force   bool        `json:"force"`
+}
+
+// httpRemote is a repository served by [Server]
+type httpRemote struct {
+	url    string
+	client *http.Client
+}
+
+// jsonBody return a request body: the JSON line of request, followed by rest if not nil
+func jsonBody(request any, rest io.Reader) (io.Reader, error) {
+	header, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	body := bytes.NewReader(append(header, '\n'))
+	if rest == nil {
+		return body, nil
+	}
+	return io.MultiReader(body, rest), nil
+}
+
+// copyTo return the function copying an answer to w
+func copyTo(w io.Writer) func(io.Reader) error {
+	return func(r io.Reader) error {
+		_, err := io.Copy(w, r)
+		return err
+	}
+}
+
+// do send a request to the server, the body of its answer is given to read if not nil
+func (remote *httpRemote) do(method string, urlPath string, body io.Reader, read func(io.Reader) error) error {
+	req, err := http.NewRequest(method, remote.url+urlPath, body)
+	if err != nil {
+		return err
+	}
+	resp, err := remote.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		text := strings.TrimSpace(string(msg))
+		if resp.StatusCode == http.StatusConflict {
+			return fmt.Errorf("%w: %s", ErrNonFastForward, strings.TrimPrefix(text, ErrNonFastForward.Error()+": "))
+		}
+		return fmt.Errorf("%s: %s", resp.Status, text)
+	}
+	if read == nil {
+		return nil
+	}
+	return read(resp.Body)
+}
+
+func (remote *httpRemote) Refs() (*RefAdvertisement, error) {
+	adv := &RefAdvertisement{}
+	err := remote.do(http.MethodGet, HTTP_INFO_REFS, nil, func(r io.Reader) error {
+		if err := json.NewDecoder(io.LimitReader(r, HTTP_MAX_JSON_SIZE)).Decode(adv); err != nil {
+			return fmt.Errorf("Bad answer from %s: %w", remote.url, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return adv, nil
+}
+
+func (remote *httpRemote) Fetch(wants []string, haves []string, depth int, w io.Writer) error {
+	body, err := jsonBody(uploadRequest{Wants: wants, Haves: haves, Depth: depth}, nil)
+	if err != nil {
+		return err
+	}
+	return remote.do(http.MethodPost, HTTP_UPLOAD_PACK, body, copyTo(w))
+}
+
+func (remote *httpRemote) Push(r io.Reader, updates []RefUpdate, force bool) error {
+	body, err := jsonBody(receiveRequest{Updates: updates, Force: force}, r)
+	if err != nil {
+		return err
+	}
+	return remote.do(http.MethodPost, HTTP_RECEIVE_PACK, body, nil)
+}
+
+func (remote *httpRemote) DownloadLFS(hash string, w io.Writer) error {
+	return remote.do(http.MethodGet, HTTP_LFS_OBJECTS+hash, nil, copyTo(w))
+}
+
+func (remote *httpRemote) UploadLFS(hash string, r io.Reader) error {
+	return remote.do(http.MethodPut, HTTP_LFS_OBJECTS+hash, r, nil)
+}
+
+func (remote *httpRemote) Close() error {
+	return nil
+}
+
+// Server serve the repositories under the directory Root over HTTP
+type Server struct {
+	Root        string
+	MaxBodySize int64 // Size limit of a pushed pack or LFS object, HTTP_DEFAULT_MAX_BODY_SIZE if 0
+}
+
+// maxBodySize return the size limit of a pushed pack or LFS object
+func (server *Server) maxBodySize() int64 {
+	if server.MaxBodySize > 0 {
+		return server.MaxBodySize
+	}
+	return HTTP_DEFAULT_MAX_BODY_SIZE
+}
+
+// httpError is an error answered with its status code
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func (e *httpError) Unwrap() error {
+	return e.err
+}
+
+// ServeHTTP answer a request of the protocol
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var err error
+	if repoPath, ok := strings.CutSuffix(r.URL.Path, HTTP_INFO_REFS); ok && r.Method == http.MethodGet {
+		err = server.infoRefs(w, repoPath)
+	} else if repoPath, ok := strings.CutSuffix(r.URL.Path, HTTP_UPLOAD_PACK); ok && r.Method == http.MethodPost {
+		err = server.uploadPack(w, r, repoPath)
+	} else if repoPath, ok := strings.CutSuffix(r.URL.Path, HTTP_RECEIVE_PACK); ok && r.Method == http.MethodPost {
+		err = server.receivePack(w, r, repoPath)
+	} else if i := strings.LastIndex(r.URL.Path, HTTP_LFS_OBJECTS); i != -1 &&
+		(r.Method == http.MethodGet || r.Method == http.MethodPut) {
+		err = server.lfsObject(w, r, r.URL.Path[:i], r.URL.Path[i+len(HTTP_LFS_OBJECTS):])
+	} else {
+		err = &httpError{http.StatusNotFound, errors.New("Not found")}
+	}
+	if err == nil {
+		return
+	}
+	status := http.StatusInternalServerError
+	var statusErr *httpError
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		status = http.StatusRequestEntityTooLarge
+	} else if errors.As(err, &statusErr) {
+		status = statusErr.status
+	} else if errors.Is(err, ErrNonFastForward) {
+		status = http.StatusConflict
+	} else if errors.Is(err, ErrBadPack) || errors.Is(err, tigfile.ErrHashMismatch) ||
+		errors.Is(err, tighistory.ErrBadRefName) {
+		status = http.StatusBadRequest
+	} else if errors.Is(err, tigfs.ErrLFSMissing) {
+		status = http.StatusNotFound
+	} else if errors.Is(err, tigfile.ErrLocked) || errors.Is(err, tigfile.ErrStaleLock) {
+		status = http.StatusServiceUnavailable
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// repoContext return the context of the repository at the URL path repoPath
+func (server *Server) repoContext(repoPath string) (tigconfig.TigCtx, error) {
+	relPath := strings.Trim(path.Clean("/"+repoPath), "/")
+	if len(relPath) == 0 {
+		relPath = "."
+	}
+	if !filepath.IsLocal(relPath) {
+		return tigconfig.TigCtx{}, &httpError{http.StatusNotFound, fmt.Errorf("Bad repository %s", repoPath)}
+	}
+	ctx, err := tigconfig.OpenRepository(filepath.Join(server.Root, relPath))
+	if err != nil {
+		return ctx, &httpError{http.StatusNotFound, err}
+	}
+	return ctx, nil
+}
+
+// openRepo load the repository at the URL path repoPath
+func (server *Server) openRepo(repoPath string) (tigconfig.TigCtx, *tighistory.TigCommitTree, error) {
+	ctx, err := server.repoContext(repoPath)
+	if err != nil {
+		return ctx, nil, err
+	}
+	if err := ctx.LoadFS(); err != nil {
+		return ctx, nil, err
+	}
+	tree, err := tighistory.LoadCommits(ctx)
+	return ctx, tree, err
+}
+
+// writeJSON answer value encoded in JSON
+func writeJSON(w http.ResponseWriter, value any) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(value)
+}
+
+func (server *Server) infoRefs(w http.ResponseWriter, repoPath string) error {
+	_, tree, err := server.openRepo(repoPath)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, AdvertiseRefs(tree))
+}
+
+func (server *Server) uploadPack(w http.ResponseWriter, r *http.Request, repoPath string) error {
+	var request uploadRequest
+	body := http.MaxBytesReader(w, r.Body, HTTP_MAX_JSON_SIZE)
+	if err := json.NewDecoder(body).Decode(&request); err != nil {
+		return &httpError{http.StatusBadRequest, err}
+	}
+	_, tree, err := server.openRepo(repoPath)
+	if err != nil {
+		return err
+	}
+	pack, err := BuildPack(tree, request.Wants, reachable(tree, request.Haves), request.Depth)
+	if err != nil {
+		return &httpError{http.StatusBadRequest, err}
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	return WritePack(w, pack)
+}
+
+func (server *Server) receivePack(w http.ResponseWriter, r *http.Request, repoPath string) error {
+	var request receiveRequest
+	packStream, err := readHeader(http.MaxBytesReader(w, r.Body, server.maxBodySize()), &request)
+	if err != nil {
+		return &httpError{http.StatusBadRequest, fmt.Errorf("Bad receive-pack request: %w", err)}
+	}
+	ctx, err := server.repoContext(repoPath)
+	if err != nil {
+		return err
+	}
+	lock, err := ctx.Lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	ctx, tree, err := server.openRepo(repoPath)
+	if err != nil {
+		return err
+	}
+	if err := ReceivePack(ctx, tree, packStream, request.Updates, request.Force); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "ok\n")
+	return err
+}
+
+// lfsObject answer the download (GET) or the upload (PUT) of the LFS object hash
+func (server *Server) lfsObject(w http.ResponseWriter, r *http.Request, repoPath string, hash string) error {
+	if !tigfs.IsLFSHash(hash) {
+		return &httpError{http.StatusNotFound, fmt.Errorf("Bad LFS object %s", hash)}
+	}
+	ctx, err := server.repoContext(repoPath)
+	if err != nil {
+		return err
+	}
+	remote := &localRemote{url: ctx.TigPath}
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		return remote.DownloadLFS(hash, w)
+	}
+	if err := remote.UploadLFS(hash, http.MaxBytesReader(w, r.Body, server.maxBodySize())); err != nil {
+		return &httpError{http.StatusBadRequest, err}
+	}
+	_, err = io.WriteString(w, "ok\n")
+	return err
+}
+
+// Serve serve the repositories under root on the TCP address addr, until an error occurs.
+// A client must send the headers of its request in time, a transfer itself has no timeout.
+func Serve(root string, addr string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           &Server{Root: root},
+		ReadHeaderTimeout: 30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+	return server.ListenAndServe()
+}
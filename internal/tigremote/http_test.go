@@ -0,0 +1,202 @@
+package tigremote
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfile"
+	"tig/internal/tighistory"
+)
+
+func TestHTTP(t *testing.T) {
+	root := t.TempDir()
+	bare := path.Join(root, "repo")
+	bareCtx := tigconfig.TigCtx{ProjectPath: root, TigPath: bare}
+	if err := bareCtx.Init(); err != nil {
+		t.Fatalf("Init(): %s", err)
+	}
+	server := httptest.NewServer(&Server{Root: root})
+	defer server.Close()
+	url := server.URL + "/repo"
+
+	if err := Clone(server.URL+"/missing", path.Join(t.TempDir(), "missing"), 0); err == nil {
+		t.Fatalf("Clone() of a missing repository must fail")
+	}
+
+	// Clone the empty repository then push its commits
+	first := path.Join(t.TempDir(), "first")
+	if err := Clone(url, first, 0); err != nil {
+		t.Fatalf("Clone(): %s", err)
+	}
+	chdir(t, first)
+	commitFiles(t, "one", map[string]string{"a.txt": "1\n", "b.txt": "b\n"})
+	commitFiles(t, "two", map[string]string{"a.txt": "1\n2\n"})
+	commitFiles(t, "three", map[string]string{"a.txt": "1\n2\n3\n"})
+	ctx, tree := openTestRepo(t)
+	if err := Push(ctx, tree, DEFAULT_REMOTE, nil, false, io.Discard); err != nil {
+		t.Fatalf("Push(): %s", err)
+	}
+	pushed := tree.HeadId()
+
+	for _, depth := range []int{0, 2} {
+		dest := path.Join(t.TempDir(), "clone")
+		if err := Clone(url, dest, depth); err != nil {
+			t.Fatalf("Clone(depth %d): %s", depth, err)
+		}
+		chdir(t, dest)
+		ctx, tree := openTestRepo(t)
+		commits := 0
+		for ptr := tree.Head; ptr != nil && ptr.Value != nil; ptr = ptr.Parent {
+			commits++
+		}
+		if expected := map[int]int{0: 3, 2: 2}[depth]; commits != expected || tree.HeadId() != pushed {
+			t.Fatalf("Clone(depth %d) must have %d commits up to %s, not %d up to %s", depth, expected, pushed, commits, tree.HeadId())
+		}
+		if remoteURL := ctx.GetConfig("remote.origin.url", ""); remoteURL != url {
+			t.Fatalf("Clone(depth %d) origin url must be %s, not %s", depth, url, remoteURL)
+		}
+		for filePath, content := range map[string]string{"a.txt": "1\n2\n3\n", "b.txt": "b\n"} {
+			data, err := tigfile.ReadFileBytes(filePath, -1)
+			if err != nil || string(data) != content {
+				t.Fatalf("Clone(depth %d) %s must contain %q, not %q (%v)", depth, filePath, content, data, err)
+			}
+		}
+	}
+
+	// The full clone is behind after a new push of first: its push is rejected, then it fetches
+	chdir(t, first)
+	commitFiles(t, "four", map[string]string{"a.txt": "4\n"})
+	ctx, tree = openTestRepo(t)
+	if err := Push(ctx, tree, DEFAULT_REMOTE, nil, false, io.Discard); err != nil {
+		t.Fatalf("Push() fast-forward: %s", err)
+	}
+	pushed = tree.HeadId()
+
+	second := path.Join(t.TempDir(), "second")
+	if err := Clone(url, second, 1); err != nil {
+		t.Fatalf("Clone(): %s", err)
+	}
+	chdir(t, first)
+	commitFiles(t, "five", map[string]string{"a.txt": "5\n"})
+	ctx, tree = openTestRepo(t)
+	if err := Push(ctx, tree, DEFAULT_REMOTE, nil, false, io.Discard); err != nil {
+		t.Fatalf("Push() fast-forward: %s", err)
+	}
+	pushed = tree.HeadId()
+
+	chdir(t, second)
+	commitFiles(t, "other", map[string]string{"c.txt": "c\n"})
+	ctx, tree = openTestRepo(t)
+	if err := Push(ctx, tree, DEFAULT_REMOTE, nil, false, io.Discard); !errors.Is(err, ErrNonFastForward) {
+		t.Fatalf("Push() non fast-forward must fail with ErrNonFastForward, not %v", err)
+	}
+	if err := Fetch(ctx, tree, DEFAULT_REMOTE, io.Discard); err != nil {
+		t.Fatalf("Fetch(): %s", err)
+	}
+	_, tree = openTestRepo(t)
+	if id := tree.Refs["refs/remotes/origin/main"]; id != pushed || tree.Get(pushed) == nil {
+		t.Fatalf("Fetch() must move origin/main to %s, not %s", pushed, id)
+	}
+}
+
+func TestHTTPBodyLimit(t *testing.T) {
+	root := t.TempDir()
+	bare := path.Join(root, "repo")
+	bareCtx := tigconfig.TigCtx{ProjectPath: root, TigPath: bare}
+	if err := bareCtx.Init(); err != nil {
+		t.Fatalf("Init(): %s", err)
+	}
+	server := httptest.NewServer(&Server{Root: root, MaxBodySize: 1024})
+	defer server.Close()
+
+	dest := path.Join(t.TempDir(), "clone")
+	if err := Clone(server.URL+"/repo", dest, 0); err != nil {
+		t.Fatalf("Clone(): %s", err)
+	}
+	chdir(t, dest)
+	commitFiles(t, "big", map[string]string{"big.txt": strings.Repeat("big\n", 1024)})
+	ctx, tree := openTestRepo(t)
+	if err := Push(ctx, tree, DEFAULT_REMOTE, nil, false, io.Discard); err == nil ||
+		!strings.Contains(err.Error(), strconv.Itoa(http.StatusRequestEntityTooLarge)) {
+		t.Fatalf("Push() of a pack over the limit must be answered with %d, not %v", http.StatusRequestEntityTooLarge, err)
+	}
+	remote := &localRemote{url: bare}
+	if adv, err := remote.Refs(); err != nil || len(adv.Refs) != 0 {
+		t.Fatalf("Push() over the limit must not move the remote refs: %v %v", adv, err)
+	}
+
+	big := strings.Repeat("x", 2048)
+	hash := tigfile.HashBytes([]byte(big))
+	httpRemote := &httpRemote{url: server.URL + "/repo", client: httpClient}
+	if err := httpRemote.UploadLFS(hash, strings.NewReader(big)); err == nil ||
+		!strings.Contains(err.Error(), strconv.Itoa(http.StatusRequestEntityTooLarge)) {
+		t.Fatalf("UploadLFS() of an object over the limit must be answered with %d, not %v", http.StatusRequestEntityTooLarge, err)
+	}
+}
+
+func TestHTTPBadRefName(t *testing.T) {
+	root := t.TempDir()
+	bare := path.Join(root, "repo")
+	bareCtx := tigconfig.TigCtx{ProjectPath: root, TigPath: bare}
+	if err := bareCtx.Init(); err != nil {
+		t.Fatalf("Init(): %s", err)
+	}
+	server := httptest.NewServer(&Server{Root: root})
+	defer server.Close()
+
+	// A pushed ref which is not a branch is rejected before the pack is read
+	body := `{"updates":[{"name":"refs/heads/../../../PWNED","new":"0123456789"}],"force":true}` + "\n"
+	resp, err := http.Post(server.URL+"/repo"+HTTP_RECEIVE_PACK, "application/octet-stream", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Post(): %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("receive-pack of a bad ref name must be answered with %d, not %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	if _, err := os.Stat(path.Join(root, "PWNED")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("receive-pack of a bad ref name must not write it: %v", err)
+	}
+
+	// A remote which advertises a ref which is not a branch is refused by clone and fetch
+	for _, adv := range []RefAdvertisement{
+		{Head: "main", Refs: map[string]string{"refs/heads/../../../PWNED": "0123456789"}},
+		{Head: "../../PWNED", Refs: map[string]string{}},
+	} {
+		evil := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(adv)
+		}))
+		defer evil.Close()
+		dest := path.Join(t.TempDir(), "clone")
+		if err := Clone(evil.URL+"/repo", dest, 0); !errors.Is(err, tighistory.ErrBadRefName) {
+			t.Fatalf("Clone() of %v must fail with ErrBadRefName, not %v", adv, err)
+		}
+		if _, err := os.Stat(dest); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("Clone() of %v must not write its destination: %v", adv, err)
+		}
+
+		if err := Clone(server.URL+"/repo", dest, 0); err != nil {
+			t.Fatalf("Clone(): %s", err)
+		}
+		chdir(t, dest)
+		ctx, tree := openTestRepo(t)
+		ctx.SetRemote("evil", evil.URL+"/repo")
+		if err := ctx.SaveConfig(); err != nil {
+			t.Fatalf("SaveConfig(): %s", err)
+		}
+		if err := Fetch(ctx, tree, "evil", io.Discard); !errors.Is(err, tighistory.ErrBadRefName) {
+			t.Fatalf("Fetch() of %v must fail with ErrBadRefName, not %v", adv, err)
+		}
+		if _, err := os.Stat(path.Join(ctx.TigPath, "PWNED")); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("Fetch() of %v must not write the bad ref: %v", adv, err)
+		}
+	}
+}
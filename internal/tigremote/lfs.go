@@ -0,0 +1,148 @@
+package tigremote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfs"
+	"tig/internal/tighistory"
+)
+
+// packLFSPointers return the pointers of the pointer files of pack, each object once. The snapshots
+// are read from fs: the FS of the sender, or of the receiver once the pack is applied.
+func packLFSPointers(fs *tigfs.TigFS, pack *Pack) ([]tigfs.LFSPointer, error) {
+	var pointers []tigfs.LFSPointer
+	seen := make(map[string]bool, 8)
+	for _, packSnapshot := range pack.Snapshots {
+		if packSnapshot.Size > tigfs.LFS_POINTER_MAX_SIZE {
+			continue
+		}
+		snapshot := fs.Lookup(packSnapshot.File, packSnapshot.Hash)
+		if snapshot == nil {
+			return nil, fmt.Errorf("Unknown snapshot %s of %s", packSnapshot.Hash, packSnapshot.File)
+		}
+		pointer, ok, err := snapshot.LFSPointer()
+		if err != nil {
+			return nil, err
+		}
+		if ok && !seen[pointer.Hash] {
+			seen[pointer.Hash] = true
+			pointers = append(pointers, pointer)
+		}
+	}
+	return pointers, nil
+}
+
+// importLFS store the object hash read from r in lfs, the content must match hash
+func importLFS(lfs *tigfs.TigLFS, hash string, r io.Reader) error {
+	pointer, err := lfs.Import(r)
+	if err != nil {
+		return err
+	}
+	if pointer.Hash != hash {
+		return fmt.Errorf("Bad LFS object %s: content hash is %s", hash, pointer.Hash)
+	}
+	return nil
+}
+
+// fsLFSPointers return the pointers of the pointer files of fs, each object once
+func fsLFSPointers(fs *tigfs.TigFS) ([]tigfs.LFSPointer, error) {
+	var pointers []tigfs.LFSPointer
+	seen := make(map[string]bool, 8)
+	for _, file := range fs.Files {
+		for snapshot := file.Head; snapshot != nil; snapshot = snapshot.Previous {
+			pointer, ok, err := snapshot.LFSPointer()
+			if err != nil {
+				return nil, err
+			}
+			if ok && !seen[pointer.Hash] {
+				seen[pointer.Hash] = true
+				pointers = append(pointers, pointer)
+			}
+		}
+	}
+	return pointers, nil
+}
+
+// uploadLFS send the LFS objects of pointers to the remote. They are sent before the pack of their
+// pointer files, so the remote never has a pointer file without its object.
+func uploadLFS(ctx tigconfig.TigCtx, remote Remote, pointers []tigfs.LFSPointer, w io.Writer) error {
+	for _, pointer := range pointers {
+		object, err := ctx.FS.LFS.Open(pointer.Hash)
+		if err != nil {
+			return err
+		}
+		err = remote.UploadLFS(pointer.Hash, object)
+		object.Close()
+		if err != nil {
+			return fmt.Errorf("Upload of LFS object %s: %w", pointer.Hash, err)
+		}
+	}
+	if len(pointers) > 0 {
+		fmt.Fprintf(w, "Uploaded %d LFS objects\n", len(pointers))
+	}
+	return nil
+}
+
+// commitLFSPointers return the pointers of the pointer files of the commits reachable from tips and
+// not from haves, each object once. The deleted files are skipped.
+func commitLFSPointers(tree *tighistory.TigCommitTree, tips []string, haves map[string]bool) ([]tigfs.LFSPointer, error) {
+	var pointers []tigfs.LFSPointer
+	seen := make(map[string]bool, 8)
+	walked := make(map[string]bool, 64)
+	for _, tip := range tips {
+		for ptr := tree.Get(tip); ptr != nil && ptr.Value != nil; ptr = ptr.Parent {
+			if haves[ptr.Value.Id] || walked[ptr.Value.Id] {
+				break
+			}
+			walked[ptr.Value.Id] = true
+			for _, change := range ptr.Value.Changes {
+				if change.Action == tighistory.DELETE {
+					continue
+				}
+				pointer, ok, err := change.FileSnapshot.LFSPointer()
+				if err != nil {
+					return nil, err
+				}
+				if ok && !seen[pointer.Hash] {
+					seen[pointer.Hash] = true
+					pointers = append(pointers, pointer)
+				}
+			}
+		}
+	}
+	return pointers, nil
+}
+
+// downloadLFS receive from the remote the LFS objects of pointers which are neither in the store nor
+// in the cache. Every object is tried, the objects which could not be received are returned as an error.
+func downloadLFS(ctx tigconfig.TigCtx, remote Remote, pointers []tigfs.LFSPointer, w io.Writer) error {
+	count := 0
+	var errs []error
+	for _, pointer := range pointers {
+		object, err := ctx.FS.LFS.Open(pointer.Hash)
+		if err == nil {
+			object.Close()
+			continue
+		}
+		if !errors.Is(err, tigfs.ErrLFSMissing) {
+			return err
+		}
+		reader, writer := io.Pipe()
+		go func() {
+			writer.CloseWithError(remote.DownloadLFS(pointer.Hash, writer))
+		}()
+		err = importLFS(ctx.FS.LFS, pointer.Hash, reader)
+		reader.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Missing LFS object %s: %w", pointer.Hash, err))
+			continue
+		}
+		count++
+	}
+	if count > 0 {
+		fmt.Fprintf(w, "Downloaded %d LFS objects\n", count)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,107 @@
+package tigremote
+
+import (
+	"io"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfile"
+	"tig/internal/tigfs"
+	"tig/internal/tigindex"
+)
+
+func TestLFS(t *testing.T) {
+	root := t.TempDir()
+	bare := path.Join(root, "repo")
+	bareCtx := tigconfig.TigCtx{ProjectPath: root, TigPath: bare}
+	if err := bareCtx.Init(); err != nil {
+		t.Fatalf("Init(): %s", err)
+	}
+	server := httptest.NewServer(&Server{Root: root})
+	defer server.Close()
+	url := server.URL + "/repo"
+
+	// Push a tracked file: the object is uploaded with its pointer file
+	first := path.Join(t.TempDir(), "first")
+	if err := Clone(url, first, 0); err != nil {
+		t.Fatalf("Clone(): %s", err)
+	}
+	chdir(t, first)
+	commitFiles(t, "one", map[string]string{tigfs.TigLFSFileName: "*.bin\n", "a.txt": "a\n", "big.bin": "big one\n"})
+	ctx, tree := openTestRepo(t)
+	if err := Push(ctx, tree, DEFAULT_REMOTE, nil, false, io.Discard); err != nil {
+		t.Fatalf("Push(): %s", err)
+	}
+	objectOne := tigfile.HashBytes([]byte("big one\n"))
+	if _, err := os.Stat(path.Join(bare, tigfs.TigLFSPath, objectOne)); err != nil {
+		t.Fatalf("Push() must upload the LFS object: %s", err)
+	}
+
+	// The clone downloads the object, its working tree is clean
+	second := path.Join(t.TempDir(), "second")
+	if err := Clone(url, second, 0); err != nil {
+		t.Fatalf("Clone(): %s", err)
+	}
+	chdir(t, second)
+	if data, err := tigfile.ReadFileBytes("big.bin", -1); err != nil || string(data) != "big one\n" {
+		t.Fatalf("Clone() big.bin must contain the LFS object, not %q (%v)", data, err)
+	}
+	ctx, tree = openTestRepo(t)
+	status, err := tigindex.GetStatus(&ctx, tree)
+	if err != nil {
+		t.Fatalf("GetStatus(): %s", err)
+	}
+	if len(status.Unmodified) != 3 || len(status.Modified)+len(status.Untracked)+len(status.Staged) != 0 {
+		t.Fatalf("Clone() must have a clean working tree: %+v", status)
+	}
+
+	// The fetch downloads the object of a new version
+	chdir(t, first)
+	commitFiles(t, "two", map[string]string{"big.bin": "big two\n"})
+	ctx, tree = openTestRepo(t)
+	if err := Push(ctx, tree, DEFAULT_REMOTE, nil, false, io.Discard); err != nil {
+		t.Fatalf("Push(): %s", err)
+	}
+	chdir(t, second)
+	ctx, tree = openTestRepo(t)
+	if err := Fetch(ctx, tree, DEFAULT_REMOTE, io.Discard); err != nil {
+		t.Fatalf("Fetch(): %s", err)
+	}
+	objectTwo := tigfile.HashBytes([]byte("big two\n"))
+	if _, err := os.Stat(ctx.FS.LFS.ObjectPath(objectTwo)); err != nil {
+		t.Fatalf("Fetch() must download the LFS object: %s", err)
+	}
+
+	// An object missing from the remote fails the fetch, which is retried once the object is there
+	chdir(t, first)
+	commitFiles(t, "three", map[string]string{"big.bin": "big three\n"})
+	ctx, tree = openTestRepo(t)
+	if err := Push(ctx, tree, DEFAULT_REMOTE, nil, false, io.Discard); err != nil {
+		t.Fatalf("Push(): %s", err)
+	}
+	pushed := tree.HeadId()
+	objectThree := path.Join(bare, tigfs.TigLFSPath, tigfile.HashBytes([]byte("big three\n")))
+	if err := os.Rename(objectThree, objectThree+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	chdir(t, second)
+	ctx, tree = openTestRepo(t)
+	if err := Fetch(ctx, tree, DEFAULT_REMOTE, io.Discard); err == nil {
+		t.Fatalf("Fetch() of a missing LFS object must fail")
+	}
+	ctx, tree = openTestRepo(t)
+	if tree.Refs["refs/remotes/origin/main"] == pushed {
+		t.Fatalf("Fetch() of a missing LFS object must not move origin/main")
+	}
+	if err := os.Rename(objectThree+".moved", objectThree); err != nil {
+		t.Fatal(err)
+	}
+	if err := Fetch(ctx, tree, DEFAULT_REMOTE, io.Discard); err != nil {
+		t.Fatalf("Fetch() retried: %s", err)
+	}
+	if tree.Refs["refs/remotes/origin/main"] != pushed {
+		t.Fatalf("Fetch() retried must move origin/main to %s", pushed)
+	}
+}
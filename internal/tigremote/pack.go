@@ -0,0 +1,372 @@
+package tigremote
+
+/*
+How to transfer commits between repositories (pack), a stream:
+- A line with a JSON object: the commits the receiver does not have, parents first
+- And the snapshots their changes reference, with the size of their content
+- For a shallow fetch, the commits grafted on the root (their changes add every file of their state)
+- Then the content of each snapshot, in order, as is: it is streamed from the blob, whatever its size
+
+###FILE START
+{"commits":[{"author":"Y29kZWR1ZGU=","msg":"...","date":1732000000,"id":"...","parent_id":"-",
+"changes":[{"action":1,"path":"main.go","hash":"..."}]}],
+"snapshots":[{"file":"main.go","hash":"...","path":"...","size":13}],"shallow":[]}
+package main
+###FILE END
+
+*/
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfs"
+	"tig/internal/tighistory"
+	"time"
+)
+
+var ErrNonFastForward = errors.New("Non fast-forward update rejected, use --force to overwrite")
+var ErrBadPack = errors.New("Bad pack")
+
+// PackSnapshot is a snapshot sent in a pack
+type PackSnapshot struct {
+	File     string `json:"file"` // Path of the file in the change which references it
+	Hash     string `json:"hash"`
+	Path     string `json:"path"` // Path of the blob in the FS directory
+	Size     int64  `json:"size"` // Size of the content following the header
+	snapshot *tigfs.TigFileSnapshot
+}
+
+// Pack is the set of commits and snapshots sent to another repository
+type Pack struct {
+	Commits   []*tighistory.TigCommit `json:"commits"`
+	Snapshots []PackSnapshot          `json:"snapshots"`
+	Shallow   []string                `json:"shallow,omitempty"` // Commits grafted on the root
+}
+
+// RefUpdate is a ref of the receiver moved by a push
+type RefUpdate struct {
+	Name string `json:"name"`
+	Old  string `json:"old"` // Empty for a new ref
+	New  string `json:"new"`
+}
+
+// commitIds return the ids of every commit of tree
+func commitIds(tree *tighistory.TigCommitTree) []string {
+	var ids []string
+	tree.Tree.Walk(func(node *tighistory.NTree[*tighistory.TigCommit]) {
+		if node.Value != nil {
+			ids = append(ids, node.Value.Id)
+		}
+	})
+	return ids
+}
+
+// reachable return the ids of the commits of tree reachable from tips, unknown tips are ignored
+func reachable(tree *tighistory.TigCommitTree, tips []string) map[string]bool {
+	ids := make(map[string]bool, 64)
+	for _, tip := range tips {
+		node := tree.Get(tip)
+		for ptr := node; ptr != nil && ptr.Value != nil && !ids[ptr.Value.Id]; ptr = ptr.Parent {
+			ids[ptr.Value.Id] = true
+		}
+	}
+	return ids
+}
+
+// isAncestor return true if the commit ancestor is id or one of its parents
+func isAncestor(tree *tighistory.TigCommitTree, ancestor string, id string) bool {
+	return reachable(tree, []string{id})[ancestor]
+}
+
+// BuildPack return the commits reachable from wants and not from haves, with their snapshots.
+// The commit graph is walked from each tip up to a commit the receiver has. With depth > 0, at most
+// depth commits are sent for each tip, the oldest one is grafted if its parent is not sent.
+func BuildPack(tree *tighistory.TigCommitTree, wants []string, haves map[string]bool, depth int) (*Pack, error) {
+	pack := &Pack{}
+	seen := make(map[string]bool, 64)
+	for _, want := range wants {
+		node := tree.Get(want)
+		if node == nil || node.Value == nil {
+			return nil, fmt.Errorf("Unknown commit %s", want)
+		}
+		var chain []*tighistory.TigCommit
+		for ptr := node; ptr != nil && ptr.Value != nil; ptr = ptr.Parent {
+			if haves[ptr.Value.Id] || seen[ptr.Value.Id] {
+				break
+			}
+			seen[ptr.Value.Id] = true
+			if depth > 0 && len(chain) == depth-1 && ptr.Parent != nil && ptr.Parent.Value != nil &&
+				!haves[ptr.Parent.Value.Id] && !seen[ptr.Parent.Value.Id] {
+				chain = append(chain, tree.Graft(ptr))
+				pack.Shallow = append(pack.Shallow, ptr.Value.Id)
+				break
+			}
+			chain = append(chain, ptr.Value)
+		}
+		slices.Reverse(chain)
+		pack.Commits = append(pack.Commits, chain...)
+	}
+	// Sent under the path of the change, the file may have been moved since. A rename without change
+	// is resolved by the receiver under its old path, see [tighistory.TigChange.Resolve].
+	type sentKey struct {
+		file     string
+		snapshot *tigfs.TigFileSnapshot
+	}
+	sent := make(map[sentKey]bool, 64)
+	for _, commit := range pack.Commits {
+		for _, change := range commit.Changes {
+			snapshot := change.FileSnapshot
+			if sent[sentKey{change.Path, snapshot}] ||
+				(change.Action == tighistory.RENAME && sent[sentKey{change.OldPath, snapshot}]) {
+				continue
+			}
+			sent[sentKey{change.Path, snapshot}] = true
+			info, err := os.Stat(snapshot.BlobPath())
+			if err != nil {
+				return nil, fmt.Errorf("BuildPack: %w", err)
+			}
+			pack.Snapshots = append(pack.Snapshots, PackSnapshot{
+				File: change.Path, Hash: snapshot.Hash, Path: snapshot.Path, Size: info.Size(), snapshot: snapshot,
+			})
+		}
+	}
+	return pack, nil
+}
+
+// WritePack write the stream of a pack made by [BuildPack] to w, the contents are streamed from the blobs
+func WritePack(w io.Writer, pack *Pack) error {
+	if err := json.NewEncoder(w).Encode(pack); err != nil {
+		return err
+	}
+	for _, packSnapshot := range pack.Snapshots {
+		n, err := packSnapshot.snapshot.WriteTo(w)
+		if err != nil {
+			return fmt.Errorf("WritePack: %w", err)
+		}
+		if n != packSnapshot.Size {
+			return fmt.Errorf("WritePack: blob %s changed while it was sent", packSnapshot.Path)
+		}
+	}
+	return nil
+}
+
+// afterLine return the rest of r after the end of the line, r must start with it
+func afterLine(r io.Reader) (io.Reader, error) {
+	rest := bufio.NewReader(r)
+	if c, err := rest.ReadByte(); err != nil || c != '\n' {
+		return nil, errors.New("Missing end of line")
+	}
+	return rest, nil
+}
+
+// readHeader decode the JSON line starting r into value, return the rest of r
+func readHeader(r io.Reader, value any) (io.Reader, error) {
+	decoder := json.NewDecoder(r)
+	if err := decoder.Decode(value); err != nil {
+		return nil, err
+	}
+	return afterLine(io.MultiReader(decoder.Buffered(), r))
+}
+
+// ReadPack read the header of the pack stream r, the contents of the snapshots follow in the returned
+// reader, see [ApplyPack]
+func ReadPack(r io.Reader) (*Pack, io.Reader, error) {
+	pack := &Pack{}
+	contents, err := readHeader(r, pack)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrBadPack, err)
+	}
+	return pack, contents, nil
+}
+
+// contentReader read the n bytes of a snapshot content from a pack stream, a shorter stream is a bad pack
+type contentReader struct {
+	r io.Reader
+	n int64
+}
+
+func (content *contentReader) Read(p []byte) (int, error) {
+	if content.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > content.n {
+		p = p[:content.n]
+	}
+	n, err := content.r.Read(p)
+	content.n -= int64(n)
+	if errors.Is(err, io.EOF) && content.n > 0 {
+		err = fmt.Errorf("%w: truncated content", ErrBadPack)
+	}
+	return n, err
+}
+
+// fetchPack receive from the remote the pack of the commits reachable from wants and not from haves
+// and apply it, see [ApplyPack]. The pack is streamed from the remote to the FS.
+func fetchPack(ctx tigconfig.TigCtx, tree *tighistory.TigCommitTree, remote Remote, wants []string, haves []string, depth int) (*Pack, error) {
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := remote.Fetch(wants, haves, depth, writer)
+		writer.CloseWithError(err)
+		done <- err
+	}()
+	pack, contents, err := ReadPack(reader)
+	if err == nil {
+		err = ApplyPack(ctx, tree, pack, contents)
+	}
+	reader.Close()
+	// The error of the remote explains a bad pack, unless the pack was dropped
+	if fetchErr := <-done; fetchErr != nil && !errors.Is(fetchErr, io.ErrClosedPipe) {
+		return nil, fetchErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pack, nil
+}
+
+// pushPack send pack to the remote and apply updates there, see [ReceivePack]. The pack is streamed
+// from the FS to the remote.
+func pushPack(remote Remote, pack *Pack, updates []RefUpdate, force bool) error {
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := WritePack(writer, pack)
+		writer.CloseWithError(err)
+		done <- err
+	}()
+	err := remote.Push(reader, updates, force)
+	reader.Close()
+	// The error of the sender explains a bad pack, unless the remote dropped it
+	if writeErr := <-done; writeErr != nil && !errors.Is(writeErr, io.ErrClosedPipe) {
+		return writeErr
+	}
+	return err
+}
+
+// ApplyPack add the snapshots and the commits of pack to the repository, then save its tree.
+// The contents of the snapshots are streamed from contents, see [ReadPack].
+func ApplyPack(ctx tigconfig.TigCtx, tree *tighistory.TigCommitTree, pack *Pack, contents io.Reader) error {
+	shallow, err := tighistory.LoadShallow(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range pack.Shallow {
+		shallow[id] = true
+	}
+	for _, snapshot := range pack.Snapshots {
+		if !filepath.IsLocal(snapshot.File) {
+			return fmt.Errorf("ApplyPack: bad file path %s", snapshot.File)
+		}
+		if snapshot.Size < 0 {
+			return fmt.Errorf("ApplyPack: %w: bad size of snapshot %s", ErrBadPack, snapshot.Hash)
+		}
+		blob := &contentReader{r: contents, n: snapshot.Size}
+		if _, err := ctx.FS.ImportReader(snapshot.File, snapshot.Hash, snapshot.Path, blob); err != nil {
+			return fmt.Errorf("ApplyPack: %w", err)
+		}
+		// The content of a known snapshot is not read
+		if _, err := io.Copy(io.Discard, blob); err != nil {
+			return fmt.Errorf("ApplyPack: %w", err)
+		}
+	}
+	for _, packCommit := range pack.Commits {
+		if tree.Get(packCommit.Id) != nil {
+			continue
+		}
+		parent := tree.Get(packCommit.ParentId)
+		if slices.Contains(pack.Shallow, packCommit.Id) {
+			parent = &tree.Tree
+		}
+		if parent == nil {
+			return fmt.Errorf("ApplyPack: missing parent %s of commit %s", packCommit.ParentId, packCommit.Id)
+		}
+		// Copied, the changes of the sender are linked to its own FS
+		commit := *packCommit
+		commit.Written = time.Now().Unix()
+		commit.Changes = slices.Clone(packCommit.Changes)
+		for i := range commit.Changes {
+			change := &commit.Changes[i]
+			if !filepath.IsLocal(change.Path) || (len(change.OldPath) > 0 && !filepath.IsLocal(change.OldPath)) {
+				return fmt.Errorf("ApplyPack: bad file path %s in commit %s", change.Path, commit.Id)
+			}
+			if err := change.Resolve(ctx.FS); err != nil {
+				return fmt.Errorf("ApplyPack: %w", err)
+			}
+		}
+		parent.Add(&commit)
+	}
+	if len(pack.Shallow) > 0 {
+		ids := make([]string, 0, len(shallow))
+		for id := range shallow {
+			ids = append(ids, id)
+		}
+		slices.Sort(ids)
+		if err := tighistory.SaveShallow(ctx, ids); err != nil {
+			return err
+		}
+	}
+	return tree.Save(ctx)
+}
+
+// ReceivePack apply pack to the repository and move its refs as asked by updates. The updates which
+// are not fast-forward are rejected unless force, and so is the checked out branch of a non bare repository,
+// or a ref which is not a branch, see [tighistory.CheckRefName].
+// Every update is checked before the contents of the pack stream r are read, so nothing is written if
+// one is rejected.
+func ReceivePack(ctx tigconfig.TigCtx, tree *tighistory.TigCommitTree, r io.Reader, updates []RefUpdate, force bool) error {
+	for _, update := range updates {
+		if err := tighistory.CheckRefName(update.Name); err != nil {
+			return err
+		}
+	}
+	pack, contents, err := ReadPack(r)
+	if err != nil {
+		return err
+	}
+	// Parents of the commits of the pack, not in tree yet
+	parents := make(map[string]string, len(pack.Commits))
+	for _, commit := range pack.Commits {
+		parents[commit.Id] = commit.ParentId
+		if slices.Contains(pack.Shallow, commit.Id) {
+			parents[commit.Id] = "-"
+		}
+	}
+	// isPackAncestor is [isAncestor] over the commits of tree and pack
+	isPackAncestor := func(ancestor string, id string) bool {
+		for ; parents[id] != ""; id = parents[id] {
+			if id == ancestor {
+				return true
+			}
+		}
+		return isAncestor(tree, ancestor, id)
+	}
+	for _, update := range updates {
+		current := tree.Refs[update.Name]
+		if _, ok := parents[update.New]; !ok && tree.Get(update.New) == nil {
+			return fmt.Errorf("Unknown commit %s for %s", update.New, update.Name)
+		}
+		if !ctx.IsBare() && update.Name == tighistory.REF_HEADS+tree.Branch {
+			return fmt.Errorf("Cannot update %s, it is checked out in the remote repository", update.Name)
+		}
+		if len(current) > 0 && !force && !isPackAncestor(current, update.New) {
+			return fmt.Errorf("%w: %s", ErrNonFastForward, update.Name)
+		}
+	}
+	if err := ApplyPack(ctx, tree, pack, contents); err != nil {
+		return err
+	}
+	for _, update := range updates {
+		if err := tree.SetRef(ctx, update.Name, update.New); err != nil {
+			return fmt.Errorf("ReceivePack: %w", err)
+		}
+	}
+	return nil
+}
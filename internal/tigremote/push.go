@@ -0,0 +1,83 @@
+package tigremote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"tig/internal/tigconfig"
+	"tig/internal/tighistory"
+)
+
+// Push send the branches to the remote name and move its branches to their tips. The missing commits
+// and snapshots are found by walking the commit graph from the tips up to the branches of the remote.
+// Without branches, the current branch is pushed. The LFS objects of the pushed pointer files are uploaded first.
+func Push(ctx tigconfig.TigCtx, tree *tighistory.TigCommitTree, name string, branches []string, force bool, w io.Writer) error {
+	if len(branches) == 0 {
+		if len(tree.Branch) == 0 {
+			return errors.New("HEAD is detached, give the branch to push")
+		}
+		branches = []string{tree.Branch}
+	}
+	remote, err := OpenRemote(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+	adv, err := remote.Refs()
+	if err != nil {
+		return fmt.Errorf("Push: %w", err)
+	}
+	refs := adv.Refs
+
+	var updates []RefUpdate
+	var wants []string
+	for _, branch := range branches {
+		refName := tighistory.REF_HEADS + branch
+		id, ok := tree.Refs[refName]
+		if !ok {
+			return fmt.Errorf("Branch %s has no commit", branch)
+		}
+		old := refs[refName]
+		if old == id {
+			continue
+		}
+		if len(old) > 0 && !force && !isAncestor(tree, old, id) {
+			// The remote has commits we don't have, or the branch was rewritten
+			return fmt.Errorf("%w: %s, fetch first", ErrNonFastForward, branch)
+		}
+		updates = append(updates, RefUpdate{Name: refName, Old: old, New: id})
+		wants = append(wants, id)
+	}
+	if len(updates) == 0 {
+		fmt.Fprintln(w, "Everything up-to-date")
+		return nil
+	}
+
+	remoteTips := make([]string, 0, len(refs))
+	for _, id := range refs {
+		remoteTips = append(remoteTips, id)
+	}
+	pack, err := BuildPack(tree, wants, reachable(tree, remoteTips), 0)
+	if err != nil {
+		return fmt.Errorf("Push: %w", err)
+	}
+	pointers, err := packLFSPointers(ctx.FS, pack)
+	if err != nil {
+		return fmt.Errorf("Push: %w", err)
+	}
+	if err := uploadLFS(ctx, remote, pointers, w); err != nil {
+		return fmt.Errorf("Push: %w", err)
+	}
+	if err := pushPack(remote, pack, updates, force); err != nil {
+		return fmt.Errorf("Push: %w", err)
+	}
+	for _, update := range updates {
+		branch := tighistory.ShortRefName(update.Name)
+		if err := tree.SetRef(ctx, tighistory.REF_REMOTES+name+"/"+branch, update.New); err != nil {
+			return fmt.Errorf("Push: %w", err)
+		}
+		forced := len(update.Old) > 0 && !isAncestor(tree, update.Old, update.New)
+		writeRefUpdate(w, update.Old, update.New, branch, branch, forced)
+	}
+	return nil
+}
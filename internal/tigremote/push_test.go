@@ -0,0 +1,227 @@
+package tigremote
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path"
+	"testing"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfile"
+	"tig/internal/tighistory"
+	"tig/internal/tigindex"
+)
+
+func TestFetchPush(t *testing.T) {
+	bare := path.Join(t.TempDir(), "bare")
+	bareCtx := tigconfig.TigCtx{ProjectPath: path.Dir(bare), TigPath: bare}
+	if err := bareCtx.Init(); err != nil {
+		t.Fatalf("Init(): %s", err)
+	}
+	first, second := path.Join(t.TempDir(), "first"), path.Join(t.TempDir(), "second")
+
+	// Clone the empty repository then push its first commit
+	if err := Clone(bare, first, 0); err != nil {
+		t.Fatalf("Clone(): %s", err)
+	}
+	chdir(t, first)
+	commitFiles(t, "one", map[string]string{"a.txt": "1\n"})
+	ctx, tree := openTestRepo(t)
+	if err := Push(ctx, tree, DEFAULT_REMOTE, nil, false, io.Discard); err != nil {
+		t.Fatalf("Push() new branch: %s", err)
+	}
+
+	if err := Clone(bare, second, 0); err != nil {
+		t.Fatalf("Clone(): %s", err)
+	}
+	chdir(t, first)
+	commitFiles(t, "two", map[string]string{"a.txt": "1\n2\n"})
+	ctx, tree = openTestRepo(t)
+	if err := Push(ctx, tree, DEFAULT_REMOTE, nil, false, io.Discard); err != nil {
+		t.Fatalf("Push() fast-forward: %s", err)
+	}
+	pushed := tree.HeadId()
+
+	// second is behind: its push is rejected until forced
+	chdir(t, second)
+	commitFiles(t, "other", map[string]string{"b.txt": "b\n"})
+	ctx, tree = openTestRepo(t)
+	if err := Push(ctx, tree, DEFAULT_REMOTE, nil, false, io.Discard); !errors.Is(err, ErrNonFastForward) {
+		t.Fatalf("Push() non fast-forward must fail with ErrNonFastForward, not %v", err)
+	}
+	if err := Fetch(ctx, tree, DEFAULT_REMOTE, io.Discard); err != nil {
+		t.Fatalf("Fetch(): %s", err)
+	}
+	ctx, tree = openTestRepo(t)
+	if id := tree.Refs["refs/remotes/origin/main"]; id != pushed {
+		t.Fatalf("Fetch() must move origin/main to %s, not %s", pushed, id)
+	}
+	if node := tree.Get(pushed); node == nil || len(tree.State(node)) != 1 {
+		t.Fatalf("Fetch() must add the commits of the remote with their snapshots")
+	}
+	if err := Push(ctx, tree, DEFAULT_REMOTE, nil, true, io.Discard); err != nil {
+		t.Fatalf("Push() forced: %s", err)
+	}
+	remote := &localRemote{url: bare}
+	adv, err := remote.Refs()
+	if err != nil || adv.Refs["refs/heads/main"] != tree.HeadId() {
+		t.Fatalf("Push() forced must move the remote main to %s: %v", tree.HeadId(), err)
+	}
+}
+
+func TestPushMoved(t *testing.T) {
+	bare := path.Join(t.TempDir(), "bare")
+	bareCtx := tigconfig.TigCtx{ProjectPath: path.Dir(bare), TigPath: bare}
+	if err := bareCtx.Init(); err != nil {
+		t.Fatalf("Init(): %s", err)
+	}
+	first := path.Join(t.TempDir(), "first")
+	if err := Clone(bare, first, 0); err != nil {
+		t.Fatalf("Clone(): %s", err)
+	}
+	chdir(t, first)
+	commitFiles(t, "one", map[string]string{"a.txt": "a\n"})
+	ctx, tree := openTestRepo(t)
+	if err := tigindex.MoveFile(ctx, tree, "a.txt", "b.txt", false); err != nil {
+		t.Fatalf("MoveFile(): %s", err)
+	}
+	if err := tighistory.Commit(ctx, tree, "two"); err != nil {
+		t.Fatalf("Commit(): %s", err)
+	}
+	ctx, tree = openTestRepo(t)
+	if err := Push(ctx, tree, DEFAULT_REMOTE, nil, false, io.Discard); err != nil {
+		t.Fatalf("Push(): %s", err)
+	}
+
+	// The snapshot of a.txt, moved to b.txt in first, is sent under the path of each change
+	remote := &localRemote{url: bare}
+	_, bareTree, err := remote.open()
+	if err != nil {
+		t.Fatalf("open() after Push() of a moved file: %s", err)
+	}
+	two := bareTree.Get(tree.HeadId())
+	if two == nil {
+		t.Fatalf("Push() must add the commit %s", tree.HeadId())
+	}
+	if state := bareTree.State(two); state["b.txt"] == nil || state["a.txt"] != nil {
+		t.Fatalf("Push() must send the rename of a.txt to b.txt: %v", state)
+	}
+	if state := bareTree.State(two.Parent); state["a.txt"] == nil {
+		t.Fatalf("Push() must send a.txt of the first commit: %v", state)
+	}
+
+	// A local clone copies the moves of the FS
+	third := path.Join(t.TempDir(), "third")
+	if err := Clone(first, third, 0); err != nil {
+		t.Fatalf("Clone(): %s", err)
+	}
+	chdir(t, third)
+	_, cloneTree := openTestRepo(t)
+	if state := cloneTree.State(cloneTree.Head.Parent); state["a.txt"] == nil {
+		t.Fatalf("Clone() must resolve a.txt of the first commit: %v", state)
+	}
+}
+
+func TestReceivePackRejected(t *testing.T) {
+	origin := newTestRepo(t)
+	commitFiles(t, "one", map[string]string{"a.txt": "1\n"})
+	ctx, tree := openTestRepo(t)
+	one := tree.HeadId()
+	clone := path.Join(t.TempDir(), "clone")
+	if err := Clone(origin, clone, 0); err != nil {
+		t.Fatalf("Clone(): %s", err)
+	}
+	commitFiles(t, "two", map[string]string{"a.txt": "1\n2\n"})
+	ctx, tree = openTestRepo(t)
+	two := tree.HeadId()
+	if err := tree.SetRef(ctx, "refs/heads/feature", two); err != nil {
+		t.Fatalf("SetRef(): %s", err)
+	}
+
+	chdir(t, clone)
+	commitFiles(t, "three", map[string]string{"b.txt": "b\n"})
+	_, cloneTree := openTestRepo(t)
+	three := cloneTree.HeadId()
+	pack, err := BuildPack(cloneTree, []string{three}, map[string]bool{one: true}, 0)
+	if err != nil {
+		t.Fatalf("BuildPack(): %s", err)
+	}
+
+	remote := &localRemote{url: origin}
+	for _, test := range []struct {
+		updates []RefUpdate
+		force   bool
+	}{
+		{[]RefUpdate{{Name: "refs/heads/main", Old: two, New: three}}, true},
+		{[]RefUpdate{{Name: "refs/heads/feature", Old: two, New: three}}, false},
+		{[]RefUpdate{{Name: "refs/heads/feature", Old: two, New: three}, {Name: "refs/heads/main", Old: two, New: three}}, true},
+		{[]RefUpdate{{Name: "refs/heads/../../feature", New: three}}, true},
+	} {
+		if err := pushPack(remote, pack, test.updates, test.force); err == nil {
+			t.Fatalf("Push(%v, force %v) must be rejected", test.updates, test.force)
+		}
+		// Nothing is written by a rejected push
+		_, tree, err := remote.open()
+		if err != nil {
+			t.Fatalf("open(): %s", err)
+		}
+		if tree.Get(three) != nil || tree.Refs["refs/heads/feature"] != two {
+			t.Fatalf("Push(%v, force %v) rejected must not add the pack or move the refs", test.updates, test.force)
+		}
+	}
+
+	if err := pushPack(remote, pack, []RefUpdate{{Name: "refs/heads/feature", Old: two, New: three}}, true); err != nil {
+		t.Fatalf("Push() forced: %s", err)
+	}
+	_, tree, err = remote.open()
+	if err != nil {
+		t.Fatalf("open(): %s", err)
+	}
+	if tree.Get(three) == nil || tree.Refs["refs/heads/feature"] != three {
+		t.Fatalf("Push() forced must add the pack and move feature to %s", three)
+	}
+}
+
+func TestPackStream(t *testing.T) {
+	origin := newTestRepo(t)
+	commitFiles(t, "one", map[string]string{"a.txt": "1\n"})
+	clone := path.Join(t.TempDir(), "clone")
+	if err := Clone(origin, clone, 0); err != nil {
+		t.Fatalf("Clone(): %s", err)
+	}
+	chdir(t, clone)
+	commitFiles(t, "two", map[string]string{"b.txt": "content of b\n"})
+	_, tree := openTestRepo(t)
+	two := tree.HeadId()
+	pack, err := BuildPack(tree, []string{two}, reachable(tree, []string{tree.Head.Parent.Value.Id}), 0)
+	if err != nil {
+		t.Fatalf("BuildPack(): %s", err)
+	}
+	var stream bytes.Buffer
+	if err := WritePack(&stream, pack); err != nil {
+		t.Fatalf("WritePack(): %s", err)
+	}
+	if !bytes.HasSuffix(stream.Bytes(), []byte("}\ncontent of b\n")) {
+		t.Fatalf("WritePack() must write the header line then the contents as is:\n%s", stream.String())
+	}
+
+	remote := &localRemote{url: origin}
+	update := []RefUpdate{{Name: "refs/heads/feature", New: two}}
+	truncated := stream.Bytes()[:stream.Len()-1]
+	if err := remote.Push(bytes.NewReader(truncated), update, false); !errors.Is(err, ErrBadPack) {
+		t.Fatalf("Push() of a truncated pack must fail with ErrBadPack, not %v", err)
+	}
+	corrupted := bytes.Replace(stream.Bytes(), []byte("content of b"), []byte("content of c"), 1)
+	if err := remote.Push(bytes.NewReader(corrupted), update, false); !errors.Is(err, tigfile.ErrHashMismatch) {
+		t.Fatalf("Push() of a corrupted pack must fail with ErrHashMismatch, not %v", err)
+	}
+	if _, tree, err := remote.open(); err != nil || tree.Get(two) != nil {
+		t.Fatalf("Push() of a bad pack must not add its commits: %v", err)
+	}
+	if err := remote.Push(bytes.NewReader(stream.Bytes()), update, false); err != nil {
+		t.Fatalf("Push(): %s", err)
+	}
+	if _, tree, err := remote.open(); err != nil || tree.Get(two) == nil {
+		t.Fatalf("Push() must add the commits of the pack: %v", err)
+	}
+}
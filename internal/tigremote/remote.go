@@ -0,0 +1,224 @@
+package tigremote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfs"
+	"tig/internal/tighistory"
+)
+
+var ErrNoRemote = errors.New("No such remote")
+
+// RefAdvertisement is the list of the branches a remote offers
+type RefAdvertisement struct {
+	Head string            `json:"head"` // Branch checked out in the remote, empty if HEAD is detached
+	Refs map[string]string `json:"refs"` // Ref name -> commit id
+}
+
+// Remote is a repository commits are exchanged with
+type Remote interface {
+	// Refs return the branches of the remote
+	Refs() (*RefAdvertisement, error)
+	// Fetch write to w the pack stream of the commits reachable from wants and not from haves, see [BuildPack]
+	Fetch(wants []string, haves []string, depth int, w io.Writer) error
+	// Push send the pack stream read from r then apply updates, see [ReceivePack]
+	Push(r io.Reader, updates []RefUpdate, force bool) error
+	// DownloadLFS write the LFS object hash of the remote to w
+	DownloadLFS(hash string, w io.Writer) error
+	// UploadLFS send the LFS object hash, read from r, to the remote
+	UploadLFS(hash string, r io.Reader) error
+	Close() error
+}
+
+// OpenRemote connect to the remote name
+func OpenRemote(ctx tigconfig.TigCtx, name string) (Remote, error) {
+	url, ok := ctx.RemoteURL(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoRemote, name)
+	}
+	return openURL(url, ctx.SSHCommand())
+}
+
+// isURL return true if url is not a path of the local filesystem
+func isURL(url string) bool {
+	return strings.Contains(url, "://")
+}
+
+// openURL connect to the repository at url, sshCommand connects to the host of an ssh:// url
+func openURL(url string, sshCommand string) (Remote, error) {
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		return &httpRemote{url: strings.TrimSuffix(url, "/"), client: httpClient}, nil
+	}
+	if strings.HasPrefix(url, "ssh://") {
+		return newSSHRemote(url, sshCommand)
+	}
+	if isURL(url) {
+		return nil, fmt.Errorf("Unsupported remote url %s", url)
+	}
+	return &localRemote{url: url}, nil
+}
+
+// AddRemote add the remote name, a relative path url is made absolute
+func AddRemote(ctx tigconfig.TigCtx, name string, url string) error {
+	if len(name) == 0 || strings.ContainsAny(name, "/= \t") || strings.HasPrefix(name, "-") {
+		return fmt.Errorf("Bad remote name %s", name)
+	}
+	if _, ok := ctx.RemoteURL(name); ok {
+		return fmt.Errorf("Remote %s already exists", name)
+	}
+	if !isURL(url) {
+		absURL, err := filepath.Abs(url)
+		if err != nil {
+			return fmt.Errorf("AddRemote: %w", err)
+		}
+		url = absURL
+	}
+	ctx.SetRemote(name, url)
+	return ctx.SaveConfig()
+}
+
+// RemoveRemote remove the remote name, its remote branches and the upstream of the branches tracking it
+func RemoveRemote(ctx tigconfig.TigCtx, name string) error {
+	if _, ok := ctx.RemoteURL(name); !ok {
+		return fmt.Errorf("%w: %s", ErrNoRemote, name)
+	}
+	ctx.RemoveRemote(name)
+	if err := ctx.SaveConfig(); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(path.Join(ctx.TigPath, tighistory.REF_REMOTES, name)); err != nil {
+		return fmt.Errorf("RemoveRemote: %w", err)
+	}
+	return nil
+}
+
+// AdvertiseRefs return the branches of the repository
+func AdvertiseRefs(tree *tighistory.TigCommitTree) *RefAdvertisement {
+	adv := &RefAdvertisement{Head: tree.Branch, Refs: make(map[string]string, len(tree.Refs))}
+	for name, id := range tree.Refs {
+		if strings.HasPrefix(name, tighistory.REF_HEADS) {
+			adv.Refs[name] = id
+		}
+	}
+	return adv
+}
+
+// checkAdvertisement return an error if the head or a ref of adv is not a branch, see [tighistory.CheckRefName]:
+// the refs of a remote are written under the refs directory
+func checkAdvertisement(adv *RefAdvertisement) error {
+	if len(adv.Head) > 0 {
+		if err := tighistory.CheckRefName(tighistory.REF_HEADS + adv.Head); err != nil {
+			return err
+		}
+	}
+	for name := range adv.Refs {
+		if err := tighistory.CheckRefName(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// localRemote is a repository of the local filesystem, url is its project or tig directory
+type localRemote struct {
+	url string
+}
+
+// open load the remote repository
+func (remote *localRemote) open() (tigconfig.TigCtx, *tighistory.TigCommitTree, error) {
+	ctx, err := tigconfig.OpenRepository(remote.url)
+	if err != nil {
+		return ctx, nil, err
+	}
+	if err := ctx.LoadFS(); err != nil {
+		return ctx, nil, err
+	}
+	tree, err := tighistory.LoadCommits(ctx)
+	return ctx, tree, err
+}
+
+func (remote *localRemote) Refs() (*RefAdvertisement, error) {
+	_, tree, err := remote.open()
+	if err != nil {
+		return nil, err
+	}
+	return AdvertiseRefs(tree), nil
+}
+
+// buildPack return the pack of the commits reachable from wants and not from haves, see [BuildPack]
+func (remote *localRemote) buildPack(wants []string, haves []string, depth int) (*Pack, error) {
+	_, tree, err := remote.open()
+	if err != nil {
+		return nil, err
+	}
+	return BuildPack(tree, wants, reachable(tree, haves), depth)
+}
+
+func (remote *localRemote) Fetch(wants []string, haves []string, depth int, w io.Writer) error {
+	pack, err := remote.buildPack(wants, haves, depth)
+	if err != nil {
+		return err
+	}
+	return WritePack(w, pack)
+}
+
+func (remote *localRemote) Push(r io.Reader, updates []RefUpdate, force bool) error {
+	ctx, err := tigconfig.OpenRepository(remote.url)
+	if err != nil {
+		return err
+	}
+	lock, err := ctx.Lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	ctx, tree, err := remote.open()
+	if err != nil {
+		return err
+	}
+	return ReceivePack(ctx, tree, r, updates, force)
+}
+
+// openLFS return the LFS store of the remote
+func (remote *localRemote) openLFS() (*tigfs.TigLFS, error) {
+	ctx, err := tigconfig.OpenRepository(remote.url)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.OpenLFS()
+}
+
+func (remote *localRemote) DownloadLFS(hash string, w io.Writer) error {
+	if !tigfs.IsLFSHash(hash) {
+		return fmt.Errorf("Bad LFS object %s", hash)
+	}
+	lfs, err := remote.openLFS()
+	if err != nil {
+		return err
+	}
+	object, err := lfs.Open(hash)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+	_, err = io.Copy(w, object)
+	return err
+}
+
+func (remote *localRemote) UploadLFS(hash string, r io.Reader) error {
+	lfs, err := remote.openLFS()
+	if err != nil {
+		return err
+	}
+	return importLFS(lfs, hash, r)
+}
+
+func (remote *localRemote) Close() error {
+	return nil
+}
@@ -0,0 +1,343 @@
+package tigremote
+
+/*
+How to exchange commits over stdin/stdout, with tig upload-pack <path> or tig receive-pack <path>
+run on the remote host through ssh:
+- The messages are JSON objects, one per line
+- The server first sends its refs: {"refs":{...}}
+- upload-pack: the client sends the wants and haves (uploadRequest), the server answers {} followed
+  by the pack stream, then exits
+- receive-pack: the client sends the ref updates (receiveRequest) followed by the pack stream, the
+  server answers {}
+- The client closes stdin without request when it has nothing to ask, the server exits
+- A failure is answered with {"error":"...","non_fast_forward":true} and ends the session
+- An LFS object is not sent in JSON: tig lfs-download <path> <hash> writes its content to stdout,
+  tig lfs-upload <path> <hash> reads it from stdin. A failure is reported on stderr with a non zero exit
+
+###FILE START
+{"refs":{"head":"main","refs":{"refs/heads/main":"..."}}}
+{"wants":["..."],"haves":[],"depth":1}
+{}
+{"commits":[...],"snapshots":[...],"shallow":["..."]}
+<the contents of the snapshots>
+###FILE END
+
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	neturl "net/url"
+	"os/exec"
+	"strings"
+	"tig/internal/tigfs"
+)
+
+// Commands served over stdin/stdout, run on the remote host as "tig <service> <path>"
+const (
+	SERVICE_UPLOAD_PACK  = "upload-pack"
+	SERVICE_RECEIVE_PACK = "receive-pack"
+	SERVICE_LFS_DOWNLOAD = "lfs-download" // tig lfs-download <path> <hash>
+	SERVICE_LFS_UPLOAD   = "lfs-upload"   // tig lfs-upload <path> <hash>
+)
+
+// stdioReply is a message of the server
+type stdioReply struct {
+	Error          string            `json:"error,omitempty"`
+	NonFastForward bool              `json:"non_fast_forward,omitempty"`
+	Refs           *RefAdvertisement `json:"refs,omitempty"`
+}
+
+// errorReply return the reply reporting err
+func errorReply(err error) stdioReply {
+	return stdioReply{Error: err.Error(), NonFastForward: errors.Is(err, ErrNonFastForward)}
+}
+
+// serveStdio answer the session of service on the repository at repoPath, handle is called with
+// the request decoded from r and the rest of r. It return the function writing the data following
+// the reply, if any.
+func serveStdio(repoPath string, r io.Reader, w io.Writer, request any,
+	handle func(*localRemote, io.Reader) (func(io.Writer) error, error)) error {
+	encoder := json.NewEncoder(w)
+	remote := &localRemote{url: repoPath}
+	adv, err := remote.Refs()
+	if err != nil {
+		encoder.Encode(errorReply(err))
+		return err
+	}
+	if err := encoder.Encode(stdioReply{Refs: adv}); err != nil {
+		return err
+	}
+	rest, err := readHeader(r, request)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		err = fmt.Errorf("Bad request: %w", err)
+		encoder.Encode(errorReply(err))
+		return err
+	}
+	write, err := handle(remote, rest)
+	if err != nil {
+		encoder.Encode(errorReply(err))
+		return err
+	}
+	if err := encoder.Encode(stdioReply{}); err != nil {
+		return err
+	}
+	if write == nil {
+		return nil
+	}
+	return write(w)
+}
+
+// ServeUploadPack answer the fetch of a client on r and w, see the protocol above
+func ServeUploadPack(repoPath string, r io.Reader, w io.Writer) error {
+	var request uploadRequest
+	return serveStdio(repoPath, r, w, &request, func(remote *localRemote, _ io.Reader) (func(io.Writer) error, error) {
+		pack, err := remote.buildPack(request.Wants, request.Haves, request.Depth)
+		if err != nil {
+			return nil, err
+		}
+		return func(w io.Writer) error { return WritePack(w, pack) }, nil
+	})
+}
+
+// ServeReceivePack answer the push of a client on r and w, see the protocol above
+func ServeReceivePack(repoPath string, r io.Reader, w io.Writer) error {
+	var request receiveRequest
+	return serveStdio(repoPath, r, w, &request, func(remote *localRemote, packStream io.Reader) (func(io.Writer) error, error) {
+		return nil, remote.Push(packStream, request.Updates, request.Force)
+	})
+}
+
+// ServeLFSDownload write the LFS object hash of the repository at repoPath to w
+func ServeLFSDownload(repoPath string, hash string, w io.Writer) error {
+	remote := &localRemote{url: repoPath}
+	return remote.DownloadLFS(hash, w)
+}
+
+// ServeLFSUpload store the LFS object hash read from r in the repository at repoPath
+func ServeLFSUpload(repoPath string, hash string, r io.Reader) error {
+	remote := &localRemote{url: repoPath}
+	return remote.UploadLFS(hash, r)
+}
+
+// sshRemote is a repository reached by running tig upload-pack or tig receive-pack on its host
+type sshRemote struct {
+	command []string // Command and arguments up to the host
+	path    string   // Path of the repository on the host
+	upload  *sshSession
+}
+
+// sshSession is a running service of the remote
+type sshSession struct {
+	service string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  io.Reader
+	decoder *json.Decoder
+	stderr  bytes.Buffer
+	refs    *RefAdvertisement
+}
+
+// shellQuote quote s for the shell of the remote host, ssh joins the arguments of the remote command
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// newSSHRemote parse url, ssh://[user@]host[:port]/path, a path starting with /~/ is relative to the home directory
+func newSSHRemote(url string, sshCommand string) (*sshRemote, error) {
+	parsed, err := neturl.Parse(url)
+	if err != nil || len(parsed.Hostname()) == 0 || len(parsed.Path) == 0 {
+		return nil, fmt.Errorf("Bad ssh url %s", url)
+	}
+	command := strings.Fields(sshCommand)
+	if len(command) == 0 {
+		return nil, errors.New("Empty ssh command")
+	}
+	if len(parsed.Port()) > 0 {
+		command = append(command, "-p", parsed.Port())
+	}
+	host := parsed.Hostname()
+	if parsed.User != nil {
+		host = parsed.User.Username() + "@" + host
+	}
+	command = append(command, host)
+	repoPath := parsed.Path
+	if strings.HasPrefix(repoPath, "/~/") {
+		repoPath = repoPath[len("/~/"):]
+	}
+	return &sshRemote{command: command, path: repoPath}, nil
+}
+
+// remoteCommand return the command running "tig <service> <path> [args...]" on the remote host
+func (remote *sshRemote) remoteCommand(service string, args ...string) *exec.Cmd {
+	cmdArgs := append(remote.command[1:len(remote.command):len(remote.command)], "tig", service, shellQuote(remote.path))
+	return exec.Command(remote.command[0], append(cmdArgs, args...)...)
+}
+
+// connect start service on the remote host and read its refs
+func (remote *sshRemote) connect(service string) (*sshSession, error) {
+	session := &sshSession{service: service, cmd: remote.remoteCommand(service)}
+	session.cmd.Stderr = &session.stderr
+	stdin, err := session.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := session.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := session.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("Cannot run %s: %w", remote.command[0], err)
+	}
+	session.stdin, session.stdout, session.decoder = stdin, stdout, json.NewDecoder(stdout)
+	reply, err := session.read()
+	if err != nil {
+		return nil, err
+	}
+	if reply.Refs == nil {
+		return nil, session.fail(errors.New("No refs received"))
+	}
+	session.refs = reply.Refs
+	return session, nil
+}
+
+// read return the next reply of the server, a failure reply is returned as an error and ends the session
+func (session *sshSession) read() (*stdioReply, error) {
+	reply := &stdioReply{}
+	if err := session.decoder.Decode(reply); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = errors.New("Connection closed")
+		}
+		return nil, session.fail(err)
+	}
+	if len(reply.Error) > 0 {
+		session.close()
+		if reply.NonFastForward {
+			return nil, fmt.Errorf("%w: %s", ErrNonFastForward, strings.TrimPrefix(reply.Error, ErrNonFastForward.Error()+": "))
+		}
+		return nil, fmt.Errorf("%s: %s", session.service, reply.Error)
+	}
+	return reply, nil
+}
+
+// rest return the output of the server following its last reply
+func (session *sshSession) rest() (io.Reader, error) {
+	return afterLine(io.MultiReader(session.decoder.Buffered(), session.stdout))
+}
+
+// send write a request to the server
+func (session *sshSession) send(request any) error {
+	if err := json.NewEncoder(session.stdin).Encode(request); err != nil {
+		return session.fail(err)
+	}
+	return nil
+}
+
+// fail end the session, err is completed by the error output of the command
+func (session *sshSession) fail(err error) error {
+	session.close()
+	if msg := strings.TrimSpace(session.stderr.String()); len(msg) > 0 {
+		return fmt.Errorf("%s: %w: %s", session.service, err, msg)
+	}
+	return fmt.Errorf("%s: %w", session.service, err)
+}
+
+// close end the session and wait for the command
+func (session *sshSession) close() error {
+	session.stdin.Close()
+	return session.cmd.Wait()
+}
+
+func (remote *sshRemote) Refs() (*RefAdvertisement, error) {
+	if remote.upload == nil {
+		session, err := remote.connect(SERVICE_UPLOAD_PACK)
+		if err != nil {
+			return nil, err
+		}
+		remote.upload = session
+	}
+	return remote.upload.refs, nil
+}
+
+func (remote *sshRemote) Fetch(wants []string, haves []string, depth int, w io.Writer) error {
+	if _, err := remote.Refs(); err != nil {
+		return err
+	}
+	// The server answers a single request
+	session := remote.upload
+	remote.upload = nil
+	if err := session.send(uploadRequest{Wants: wants, Haves: haves, Depth: depth}); err != nil {
+		return err
+	}
+	if _, err := session.read(); err != nil {
+		return err
+	}
+	packStream, err := session.rest()
+	if err != nil {
+		return session.fail(err)
+	}
+	if _, err := io.Copy(w, packStream); err != nil {
+		return session.fail(err)
+	}
+	return session.close()
+}
+
+func (remote *sshRemote) Push(r io.Reader, updates []RefUpdate, force bool) error {
+	session, err := remote.connect(SERVICE_RECEIVE_PACK)
+	if err != nil {
+		return err
+	}
+	if err := session.send(receiveRequest{Updates: updates, Force: force}); err != nil {
+		return err
+	}
+	// A failed copy is explained by the reply, the server stops reading a rejected push
+	_, copyErr := io.Copy(session.stdin, r)
+	session.stdin.Close()
+	if _, err := session.read(); err != nil {
+		return err
+	}
+	if copyErr != nil {
+		return session.fail(copyErr)
+	}
+	return session.close()
+}
+
+// runLFS run an LFS service on the object hash, with the given stdin and stdout
+func (remote *sshRemote) runLFS(service string, hash string, r io.Reader, w io.Writer) error {
+	if !tigfs.IsLFSHash(hash) {
+		return fmt.Errorf("Bad LFS object %s", hash)
+	}
+	var stderr bytes.Buffer
+	cmd := remote.remoteCommand(service, hash)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = r, w, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); len(msg) > 0 {
+			return fmt.Errorf("%s: %w: %s", service, err, msg)
+		}
+		return fmt.Errorf("%s: %w", service, err)
+	}
+	return nil
+}
+
+func (remote *sshRemote) DownloadLFS(hash string, w io.Writer) error {
+	return remote.runLFS(SERVICE_LFS_DOWNLOAD, hash, nil, w)
+}
+
+func (remote *sshRemote) UploadLFS(hash string, r io.Reader) error {
+	return remote.runLFS(SERVICE_LFS_UPLOAD, hash, r, nil)
+}
+
+func (remote *sshRemote) Close() error {
+	if remote.upload == nil {
+		return nil
+	}
+	err := remote.upload.close()
+	remote.upload = nil
+	return err
+}
@@ -0,0 +1,136 @@
+package tigremote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfile"
+	"tig/internal/tigfs"
+)
+
+// Set in the environment of the test binary run as the ssh command
+const ENV_TEST_SSH_SERVER = "TIG_TEST_SSH_SERVER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(ENV_TEST_SSH_SERVER) == "1" {
+		os.Exit(runTestSSHServer(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+// runTestSSHServer play ssh and the remote tig: [-p <port>] <host> tig <service> <quoted path> [<hash>]
+func runTestSSHServer(args []string) int {
+	if len(args) > 2 && args[0] == "-p" {
+		args = args[2:]
+	}
+	if len(args) < 4 || len(args) > 5 || args[1] != "tig" {
+		fmt.Fprintln(os.Stderr, "Bad remote command", args)
+		return 2
+	}
+	repoPath := strings.ReplaceAll(strings.Trim(args[3], "'"), `'\''`, "'")
+	var err error
+	if args[2] == SERVICE_UPLOAD_PACK {
+		err = ServeUploadPack(repoPath, os.Stdin, os.Stdout)
+	} else if args[2] == SERVICE_RECEIVE_PACK {
+		err = ServeReceivePack(repoPath, os.Stdin, os.Stdout)
+	} else if args[2] == SERVICE_LFS_DOWNLOAD && len(args) == 5 {
+		err = ServeLFSDownload(repoPath, args[4], os.Stdout)
+	} else if args[2] == SERVICE_LFS_UPLOAD && len(args) == 5 {
+		err = ServeLFSUpload(repoPath, args[4], os.Stdin)
+	} else {
+		err = fmt.Errorf("Unknown service %s", args[2])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func TestSSH(t *testing.T) {
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(tigconfig.ENV_SSH_COMMAND, executable)
+	t.Setenv(ENV_TEST_SSH_SERVER, "1")
+
+	bare := path.Join(t.TempDir(), "it's bare")
+	bareCtx := tigconfig.TigCtx{ProjectPath: path.Dir(bare), TigPath: bare}
+	if err := bareCtx.Init(); err != nil {
+		t.Fatalf("Init(): %s", err)
+	}
+	url := "ssh://user@testhost:2222" + bare
+
+	if err := Clone("ssh://testhost/missing", path.Join(t.TempDir(), "missing"), 0); err == nil ||
+		!strings.Contains(err.Error(), "not a tig repository") {
+		t.Fatalf("Clone() of a missing repository must report it, not %v", err)
+	}
+
+	first := path.Join(t.TempDir(), "first")
+	if err := Clone(url, first, 0); err != nil {
+		t.Fatalf("Clone(): %s", err)
+	}
+	chdir(t, first)
+	commitFiles(t, "one", map[string]string{"a.txt": "1\n"})
+	commitFiles(t, "two", map[string]string{"a.txt": "1\n2\n"})
+	ctx, tree := openTestRepo(t)
+	if err := Push(ctx, tree, DEFAULT_REMOTE, nil, false, io.Discard); err != nil {
+		t.Fatalf("Push(): %s", err)
+	}
+	pushed := tree.HeadId()
+
+	second := path.Join(t.TempDir(), "second")
+	if err := Clone(url, second, 1); err != nil {
+		t.Fatalf("Clone(depth 1): %s", err)
+	}
+	chdir(t, second)
+	_, tree = openTestRepo(t)
+	if tree.HeadId() != pushed || tree.Head.Parent.Value != nil {
+		t.Fatalf("Clone(depth 1) must have the single commit %s", pushed)
+	}
+	commitFiles(t, "other", map[string]string{"b.txt": "b\n"})
+
+	chdir(t, first)
+	commitFiles(t, "three", map[string]string{"a.txt": "3\n", tigfs.TigLFSFileName: "*.bin\n", "big.bin": "big\n"})
+	ctx, tree = openTestRepo(t)
+	if err := Push(ctx, tree, DEFAULT_REMOTE, nil, false, io.Discard); err != nil {
+		t.Fatalf("Push() fast-forward: %s", err)
+	}
+	pushed = tree.HeadId()
+
+	chdir(t, second)
+	ctx, tree = openTestRepo(t)
+	if err := Push(ctx, tree, DEFAULT_REMOTE, nil, false, io.Discard); !errors.Is(err, ErrNonFastForward) {
+		t.Fatalf("Push() non fast-forward must fail with ErrNonFastForward, not %v", err)
+	}
+	if err := Fetch(ctx, tree, DEFAULT_REMOTE, io.Discard); err != nil {
+		t.Fatalf("Fetch(): %s", err)
+	}
+	ctx, tree = openTestRepo(t)
+	if id := tree.Refs["refs/remotes/origin/main"]; id != pushed || tree.Get(pushed) == nil {
+		t.Fatalf("Fetch() must move origin/main to %s, not %s", pushed, id)
+	}
+	if _, err := os.Stat(ctx.FS.LFS.ObjectPath(tigfile.HashBytes([]byte("big\n")))); err != nil {
+		t.Fatalf("Fetch() must download the LFS object: %s", err)
+	}
+	// The remote refused the update, not the client
+	remote, err := OpenRemote(ctx, DEFAULT_REMOTE)
+	if err != nil {
+		t.Fatalf("OpenRemote(): %s", err)
+	}
+	defer remote.Close()
+	pack, err := BuildPack(tree, []string{tree.HeadId()}, nil, 0)
+	if err != nil {
+		t.Fatalf("BuildPack(): %s", err)
+	}
+	update := RefUpdate{Name: "refs/heads/main", Old: pushed, New: tree.HeadId()}
+	if err := pushPack(remote, pack, []RefUpdate{update}, false); !errors.Is(err, ErrNonFastForward) {
+		t.Fatalf("receive-pack must reject a non fast-forward update with ErrNonFastForward, not %v", err)
+	}
+}
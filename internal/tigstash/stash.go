@@ -0,0 +1,492 @@
+// Package tigstash contains the stash functions, to shelve work-in-progress changes
+package tigstash
+
+/*
+How to store stashes:
+- JSON list of stash entries, latest first (stash@{0} is the first one)
+- A stash entry is a commit whose parent is the HEAD at stash time:
+	- changes = working tree state of the tracked files, compared to HEAD
+	- index = staged changes (current commit file) at stash time
+
+###FILE START
+[{"author":"Y29kZWR1ZGU=","msg":"...","date":1732000000,"id":"...","parent_id":"-",
+"changes":[{"action":2,"path":"main.go","hash":"..."}],"index":[]}]
+###FILE END
+
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"tig/internal/tigconfig"
+	"tig/internal/tigdiff"
+	"tig/internal/tigfile"
+	"tig/internal/tigfs"
+	"tig/internal/tighistory"
+	"tig/internal/tigindex"
+)
+
+// TigStashFileName Path relative to TigRefsDirName
+const TigStashFileName = "stash"
+
+var ErrNoLocalChanges = errors.New("No local changes to save")
+var ErrConflict = errors.New("Conflicts while applying the stash, the stash is kept")
+
+type TigStash struct {
+	tighistory.TigCommit
+	Index []tighistory.TigChange `json:"index"`
+}
+
+type TigStashList []*TigStash
+
+func stashPath(ctx tigconfig.TigCtx) string {
+	return path.Join(ctx.TigPath, tighistory.TigRefsDirName, TigStashFileName)
+}
+
+// Load read the stash stack, an empty list is returned if there is no stash
+func Load(ctx tigconfig.TigCtx) (TigStashList, error) {
+	stashes, err := LoadUnresolved(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, stash := range stashes {
+		for i := range stash.Changes {
+			if err = stash.Changes[i].Resolve(ctx.FS); err != nil {
+				return nil, fmt.Errorf("Load stash %s: %w", stash.Id, err)
+			}
+		}
+		for i := range stash.Index {
+			if err = stash.Index[i].Resolve(ctx.FS); err != nil {
+				return nil, fmt.Errorf("Load stash %s: %w", stash.Id, err)
+			}
+		}
+	}
+	return stashes, nil
+}
+
+// LoadUnresolved read the stash stack, the changes keep detached snapshots, see [tighistory.LoadCommitsUnresolved]
+func LoadUnresolved(ctx tigconfig.TigCtx) (TigStashList, error) {
+	var stashes TigStashList
+	b, err := tigfile.ReadFileBytes(stashPath(ctx), -1)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return stashes, nil
+		}
+		return nil, fmt.Errorf("Load stash: %w", err)
+	}
+	if len(b) == 0 {
+		return stashes, nil
+	}
+	if err = json.Unmarshal(b, &stashes); err != nil {
+		return nil, fmt.Errorf("Load stash: %w", err)
+	}
+	return stashes, nil
+}
+
+// Save write the stash stack
+func (stashes TigStashList) Save(ctx tigconfig.TigCtx) error {
+	if err := tighistory.SaveFS(ctx); err != nil {
+		return fmt.Errorf("Save stash: %w", err)
+	}
+	if err := os.MkdirAll(path.Join(ctx.TigPath, tighistory.TigRefsDirName), tigfile.DIR_PERM); err != nil {
+		return fmt.Errorf("Save stash: %w", err)
+	}
+	if stashes == nil {
+		stashes = TigStashList{}
+	}
+	b, err := json.Marshal(stashes)
+	if err != nil {
+		return fmt.Errorf("Save stash: %w", err)
+	}
+	if err = tigfile.WriteFileAtomic(stashPath(ctx), b); err != nil {
+		return fmt.Errorf("Save stash: %w", err)
+	}
+	return nil
+}
+
+// Get return the stash at index n (stash@{n})
+func (stashes TigStashList) Get(n int) (*TigStash, error) {
+	if n < 0 || n >= len(stashes) {
+		return nil, fmt.Errorf("stash@{%d} does not exist", n)
+	}
+	return stashes[n], nil
+}
+
+// ParseRef parse a stash reference: "stash@{n}" or "n". Empty ref is stash@{0}.
+func ParseRef(ref string) (int, error) {
+	if len(ref) == 0 {
+		return 0, nil
+	}
+	if strings.HasPrefix(ref, "stash@{") && strings.HasSuffix(ref, "}") {
+		ref = ref[len("stash@{") : len(ref)-1]
+	}
+	n, err := strconv.Atoi(ref)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Bad stash reference: %s", ref)
+	}
+	return n, nil
+}
+
+// paths return every file path touched by the stash
+func (stash *TigStash) paths() []string {
+	seen := make(map[string]bool, len(stash.Changes)+len(stash.Index))
+	var paths []string
+	for _, changes := range [][]tighistory.TigChange{stash.Changes, stash.Index} {
+		for _, change := range changes {
+			for _, filePath := range []string{change.Path, change.OldPath} {
+				if len(filePath) > 0 && !seen[filePath] {
+					seen[filePath] = true
+					paths = append(paths, filePath)
+				}
+			}
+		}
+	}
+	return paths
+}
+
+func sameSnapshot(a, b *tigfs.TigFileSnapshot) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Hash == b.Hash
+}
+
+// worktreeChange return the change of filepath in the working tree compared to HEAD, or nil.
+// New snapshots are created in the FS for modifications not already staged.
+func worktreeChange(ctx tigconfig.TigCtx, commit *tighistory.TigCommit,
+	headState tighistory.TigTreeState, filepath string) (*tighistory.TigChange, error) {
+	headSnap := headState[filepath]
+	if _, err := os.Stat(filepath); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if headSnap == nil {
+			return nil, nil
+		}
+		return &tighistory.TigChange{Action: tighistory.DELETE, Path: filepath, FileSnapshot: headSnap}, nil
+	}
+	hasChanged, err := ctx.FS.HasChanged(filepath, headSnap)
+	if err != nil || !hasChanged {
+		return nil, err
+	}
+	action := tighistory.ChangeAction(tighistory.MODIFY)
+	if headSnap == nil {
+		action = tighistory.ADD
+	}
+	// Reuse the staged snapshot when the working file did not change since
+	if staged := commit.GetChange(filepath); staged != nil && staged.Action != tighistory.DELETE {
+		hasChanged, err = ctx.FS.HasChanged(filepath, staged.FileSnapshot)
+		if err != nil {
+			return nil, err
+		}
+		if !hasChanged {
+			return &tighistory.TigChange{Action: action, Path: filepath, FileSnapshot: staged.FileSnapshot}, nil
+		}
+	}
+	var snapshot *tigfs.TigFileSnapshot
+	if file, ok := ctx.FS.Get(filepath); ok {
+		snapshot, err = file.Add()
+	} else {
+		file, err = ctx.FS.Add(filepath)
+		if file != nil {
+			snapshot = file.Head
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tighistory.TigChange{Action: action, Path: filepath, FileSnapshot: snapshot}, nil
+}
+
+// Push save the staged and unstaged changes of tracked files in a new stash,
+// then restore the working tree to HEAD. The saved stash is written to w.
+func Push(ctx tigconfig.TigCtx, tree *tighistory.TigCommitTree, msg string, w io.Writer) error {
+	commit, err := tighistory.GetCurrentCommit(ctx)
+	if err != nil {
+		return fmt.Errorf("Push: %w", err)
+	}
+	trackList, err := tigindex.GetTrackedFiles(ctx)
+	if err != nil {
+		return fmt.Errorf("Push: %w", err)
+	}
+	stashes, err := Load(ctx)
+	if err != nil {
+		return fmt.Errorf("Push: %w", err)
+	}
+	headState := tree.HeadState()
+
+	stash := &TigStash{Index: commit.Changes}
+	for _, filePath := range trackList {
+		change, err := worktreeChange(ctx, commit, headState, filePath)
+		if err != nil {
+			return fmt.Errorf("Push: %w", err)
+		}
+		if change != nil {
+			stash.Changes = append(stash.Changes, *change)
+		}
+	}
+	if len(stash.Changes) == 0 && len(stash.Index) == 0 {
+		return ErrNoLocalChanges
+	}
+	if len(msg) == 0 {
+		msg = "WIP on " + tree.HeadId()
+	}
+	stash.SetMetadata(ctx, tree.HeadId(), msg)
+	stashes = append(TigStashList{stash}, stashes...)
+	if err = stashes.Save(ctx); err != nil {
+		return fmt.Errorf("Push: %w", err)
+	}
+
+	// Restore the working tree and the index to HEAD
+	trackMap := make(map[string]bool, len(trackList))
+	for _, filePath := range trackList {
+		trackMap[filePath] = true
+	}
+	for _, filePath := range stash.paths() {
+		if headSnap, ok := headState[filePath]; ok {
+			trackMap[filePath] = true
+			err = headSnap.Restore(filePath)
+		} else {
+			delete(trackMap, filePath)
+			err = os.Remove(filePath)
+			if errors.Is(err, os.ErrNotExist) {
+				err = nil
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("Push: %w", err)
+		}
+	}
+	if err = tigindex.SetTrackedFiles(ctx, trackMap); err != nil {
+		return fmt.Errorf("Push: %w", err)
+	}
+	if err = commit.Reset(ctx); err != nil {
+		return fmt.Errorf("Push: %w", err)
+	}
+	fmt.Fprintf(w, "Saved working directory and index state stash@{0}: %s\n", msg)
+	return nil
+}
+
+// checkClean return an error if one of paths has local changes (staged or not)
+func checkClean(ctx tigconfig.TigCtx, commit *tighistory.TigCommit,
+	headState tighistory.TigTreeState, paths []string) error {
+	for _, filePath := range paths {
+		if commit.HasFile(filePath) {
+			return fmt.Errorf("Local changes to %s would be overwritten, commit or stash them first", filePath)
+		}
+		change, err := worktreeChange(ctx, commit, headState, filePath)
+		if err != nil {
+			return err
+		}
+		if change != nil {
+			return fmt.Errorf("Local changes to %s would be overwritten, commit or stash them first", filePath)
+		}
+	}
+	return nil
+}
+
+// readSnapshotLines return the lines of a snapshot, nil snapshot is an empty file.
+// A binary or too big snapshot is not loaded, true is returned.
+func readSnapshotLines(snapshot *tigfs.TigFileSnapshot) ([]string, bool, error) {
+	if snapshot == nil {
+		return []string{}, false, nil
+	}
+	data, binary, err := snapshot.ReadText()
+	if err != nil || binary {
+		return nil, binary, err
+	}
+	return tigdiff.Lines(data), false, nil
+}
+
+// mergeChange apply a stash change on a HEAD that moved since the stash was created.
+// It returns true if the change conflicts with HEAD.
+func mergeChange(change tighistory.TigChange, baseSnap, oursSnap *tigfs.TigFileSnapshot) (bool, error) {
+	filePath := change.Path
+	if change.Action == tighistory.DELETE {
+		if sameSnapshot(oursSnap, baseSnap) {
+			if err := os.Remove(filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return false, err
+			}
+			return false, nil
+		}
+		return oursSnap != nil, nil
+	}
+	theirsSnap := change.FileSnapshot
+	if sameSnapshot(oursSnap, baseSnap) {
+		return false, theirsSnap.Restore(filePath)
+	}
+	if sameSnapshot(oursSnap, theirsSnap) {
+		return false, nil
+	}
+	base, baseBinary, err := readSnapshotLines(baseSnap)
+	if err != nil {
+		return false, err
+	}
+	ours, oursBinary, err := readSnapshotLines(oursSnap)
+	if err != nil {
+		return false, err
+	}
+	theirs, theirsBinary, err := readSnapshotLines(theirsSnap)
+	if err != nil {
+		return false, err
+	}
+	if baseBinary || oursBinary || theirsBinary {
+		// Can't merge binary files, keep ours
+		return true, nil
+	}
+	merged, conflict := tigdiff.Merge3(base, ours, theirs, "Updated upstream", "Stashed changes")
+	if err = tigfile.WriteFileBytes(filePath, tigdiff.Join(merged)); err != nil {
+		return false, err
+	}
+	return conflict, nil
+}
+
+// Apply restore the stash n in the working tree. If HEAD moved since the stash was
+// created, the stashed changes are merged in the working tree and the index is not restored.
+// With drop, the stash is removed if it was applied without conflict. The conflicts are written to w.
+func Apply(ctx tigconfig.TigCtx, tree *tighistory.TigCommitTree, n int, drop bool, w io.Writer) error {
+	stashes, err := Load(ctx)
+	if err != nil {
+		return fmt.Errorf("Apply: %w", err)
+	}
+	stash, err := stashes.Get(n)
+	if err != nil {
+		return fmt.Errorf("Apply: %w", err)
+	}
+	commit, err := tighistory.GetCurrentCommit(ctx)
+	if err != nil {
+		return fmt.Errorf("Apply: %w", err)
+	}
+	trackList, err := tigindex.GetTrackedFiles(ctx)
+	if err != nil {
+		return fmt.Errorf("Apply: %w", err)
+	}
+	headState := tree.HeadState()
+	if err = checkClean(ctx, commit, headState, stash.paths()); err != nil {
+		return fmt.Errorf("Apply: %w", err)
+	}
+	trackMap := make(map[string]bool, len(trackList))
+	for _, filePath := range trackList {
+		trackMap[filePath] = true
+	}
+
+	var conflicts []string
+	if stash.ParentId == tree.HeadId() {
+		for _, change := range stash.Changes {
+			filePath := change.Path
+			if change.Action == tighistory.DELETE {
+				err = os.Remove(filePath)
+				if errors.Is(err, os.ErrNotExist) {
+					err = nil
+				}
+			} else {
+				err = change.FileSnapshot.Restore(change.Path)
+			}
+			if err != nil {
+				return fmt.Errorf("Apply: %w", err)
+			}
+		}
+		for _, change := range stash.Index {
+			commit.Changes = append(commit.Changes, change)
+		}
+		for _, filePath := range stash.paths() {
+			if _, err := os.Stat(filePath); err == nil {
+				trackMap[filePath] = true
+			}
+		}
+	} else {
+		baseNode := tree.Get(stash.ParentId)
+		if baseNode == nil {
+			return fmt.Errorf("Apply: stash base commit %s does not exist", stash.ParentId)
+		}
+		baseState := tree.State(baseNode)
+		for _, change := range stash.Changes {
+			filePath := change.Path
+			conflict, err := mergeChange(change, baseState[filePath], headState[filePath])
+			if err != nil {
+				return fmt.Errorf("Apply: %w", err)
+			}
+			if conflict {
+				conflicts = append(conflicts, filePath)
+			}
+			if _, err := os.Stat(filePath); err == nil {
+				trackMap[filePath] = true
+			}
+		}
+	}
+	if err = tigindex.SetTrackedFiles(ctx, trackMap); err != nil {
+		return fmt.Errorf("Apply: %w", err)
+	}
+	if err = commit.Save(ctx); err != nil {
+		return fmt.Errorf("Apply: %w", err)
+	}
+	if len(conflicts) > 0 {
+		fmt.Fprintln(w, "Conflicts:")
+		for _, filePath := range conflicts {
+			fmt.Fprintln(w, "\t"+filePath)
+		}
+		return ErrConflict
+	}
+	if drop {
+		return Drop(ctx, n, w)
+	}
+	return nil
+}
+
+// Drop remove the stash n from the stack, the dropped stash is written to w
+func Drop(ctx tigconfig.TigCtx, n int, w io.Writer) error {
+	stashes, err := Load(ctx)
+	if err != nil {
+		return fmt.Errorf("Drop: %w", err)
+	}
+	stash, err := stashes.Get(n)
+	if err != nil {
+		return fmt.Errorf("Drop: %w", err)
+	}
+	stashes = append(stashes[:n], stashes[n+1:]...)
+	if err = stashes.Save(ctx); err != nil {
+		return fmt.Errorf("Drop: %w", err)
+	}
+	fmt.Fprintf(w, "Dropped stash@{%d} (%s)\n", n, stash.Id)
+	return nil
+}
+
+// List write the stash stack to w
+func List(ctx tigconfig.TigCtx, w io.Writer) error {
+	stashes, err := Load(ctx)
+	if err != nil {
+		return fmt.Errorf("List: %w", err)
+	}
+	for i, stash := range stashes {
+		fmt.Fprintf(w, "stash@{%d}: %s\n", i, stash.Message())
+	}
+	return nil
+}
+
+// Show write the files changed by the stash n to w
+func Show(ctx tigconfig.TigCtx, n int, w io.Writer) error {
+	stashes, err := Load(ctx)
+	if err != nil {
+		return fmt.Errorf("Show: %w", err)
+	}
+	stash, err := stashes.Get(n)
+	if err != nil {
+		return fmt.Errorf("Show: %w", err)
+	}
+	fmt.Fprintf(w, "stash@{%d}: %s\n", n, stash.Message())
+	fmt.Fprintln(w, "\nIndex:")
+	for _, change := range stash.Index {
+		fmt.Fprintf(w, "\t%s:\t%s\n", tighistory.ChangeActionToStr(change.Action), change.Path)
+	}
+	fmt.Fprintln(w, "\nWorking tree:")
+	for _, change := range stash.Changes {
+		fmt.Fprintf(w, "\t%s:\t%s\n", tighistory.ChangeActionToStr(change.Action), change.Path)
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package tigstash
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"tig/internal/tigconfig"
+	"tig/internal/tigfile"
+	"tig/internal/tighistory"
+	"tig/internal/tigindex"
+)
+
+// newTestRepo create a repository in a temporary directory and move to it
+func newTestRepo(t *testing.T) {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+	ctx := tigconfig.TigCtx{ProjectPath: dir, TigPath: path.Join(dir, tigconfig.TigRootPath)}
+	if err := ctx.Init(); err != nil {
+		t.Fatalf("Init(): %s", err)
+	}
+}
+
+// openTestRepo load the repository of the current directory, as a new tig command would
+func openTestRepo(t *testing.T) (tigconfig.TigCtx, *tighistory.TigCommitTree) {
+	ctx, err := tigconfig.OpenRepository(".")
+	if err != nil {
+		t.Fatalf("OpenRepository(): %s", err)
+	}
+	if err := ctx.LoadFS(); err != nil {
+		t.Fatalf("LoadFS(): %s", err)
+	}
+	tree, err := tighistory.LoadCommits(ctx)
+	if err != nil {
+		t.Fatalf("LoadCommits(): %s", err)
+	}
+	return ctx, tree
+}
+
+// addFiles write the files and stage them
+func addFiles(t *testing.T, files map[string]string) {
+	var paths []string
+	for filePath, content := range files {
+		if err := tigfile.WriteFileString(filePath, content); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, filePath)
+	}
+	ctx, tree := openTestRepo(t)
+	if err := tigindex.AddFile(ctx, tree, paths, tigindex.ADD_PATHS); err != nil {
+		t.Fatalf("AddFile(): %s", err)
+	}
+	if err := ctx.FS.Save(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// commitFiles write the files and commit them
+func commitFiles(t *testing.T, msg string, files map[string]string) {
+	addFiles(t, files)
+	ctx, tree := openTestRepo(t)
+	if err := tighistory.Commit(ctx, tree, msg); err != nil {
+		t.Fatalf("Commit(): %s", err)
+	}
+}
+
+// checkFile fail if filePath does not contain content
+func checkFile(t *testing.T, filePath string, content string) {
+	t.Helper()
+	data, err := tigfile.ReadFileBytes(filePath, -1)
+	if err != nil || string(data) != content {
+		t.Fatalf("%s must contain %q, not %q (%v)", filePath, content, data, err)
+	}
+}
+
+func TestStash(t *testing.T) {
+	newTestRepo(t)
+	commitFiles(t, "one", map[string]string{"a.txt": "a\n", "b.txt": "b\n"})
+	addFiles(t, map[string]string{"a.txt": "a staged\n"})
+	if err := tigfile.WriteFileString("b.txt", "b modified\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, tree := openTestRepo(t)
+	var out bytes.Buffer
+	if err := Push(ctx, tree, "work", &out); err != nil {
+		t.Fatalf("Push(): %s", err)
+	}
+	if expected := "Saved working directory and index state stash@{0}: work\n"; out.String() != expected {
+		t.Fatalf("Push() must write %q, not %q", expected, out.String())
+	}
+	checkFile(t, "a.txt", "a\n")
+	checkFile(t, "b.txt", "b\n")
+	ctx, tree = openTestRepo(t)
+	if commit, err := tighistory.GetCurrentCommit(ctx); err != nil || len(commit.Changes) != 0 {
+		t.Fatalf("Push() must reset the staged changes (%v)", err)
+	}
+	if err := Push(ctx, tree, "", io.Discard); !errors.Is(err, ErrNoLocalChanges) {
+		t.Fatalf("Push() of a clean tree must fail with ErrNoLocalChanges, not %v", err)
+	}
+
+	out.Reset()
+	if err := List(ctx, &out); err != nil || out.String() != "stash@{0}: work\n" {
+		t.Fatalf("List() must write the stash, not %q (%v)", out.String(), err)
+	}
+	out.Reset()
+	if err := Show(ctx, 0, &out); err != nil {
+		t.Fatalf("Show(): %s", err)
+	}
+	if expected := "stash@{0}: work\n\nIndex:\n\tmodified:\ta.txt\n\nWorking tree:\n\tmodified:\ta.txt\n\tmodified:\tb.txt\n"; out.String() != expected {
+		t.Fatalf("Show() must write %q, not %q", expected, out.String())
+	}
+
+	// HEAD did not move: the working tree and the index are restored
+	out.Reset()
+	if err := Apply(ctx, tree, 0, false, &out); err != nil || out.Len() != 0 {
+		t.Fatalf("Apply() must write nothing, not %q (%v)", out.String(), err)
+	}
+	checkFile(t, "a.txt", "a staged\n")
+	checkFile(t, "b.txt", "b modified\n")
+	ctx, tree = openTestRepo(t)
+	commit, err := tighistory.GetCurrentCommit(ctx)
+	if err != nil || len(commit.Changes) != 1 || commit.Changes[0].Path != "a.txt" {
+		t.Fatalf("Apply() must restore the staged change of a.txt: %+v (%v)", commit, err)
+	}
+	if err := Apply(ctx, tree, 0, false, io.Discard); err == nil {
+		t.Fatalf("Apply() over local changes must fail")
+	}
+
+	stashes, err := Load(ctx)
+	if err != nil {
+		t.Fatalf("Load(): %s", err)
+	}
+	out.Reset()
+	if err := Drop(ctx, 0, &out); err != nil {
+		t.Fatalf("Drop(): %s", err)
+	}
+	if expected := "Dropped stash@{0} (" + stashes[0].Id + ")\n"; out.String() != expected {
+		t.Fatalf("Drop() must write %q, not %q", expected, out.String())
+	}
+	if err := Drop(ctx, 0, io.Discard); err == nil {
+		t.Fatalf("Drop() of a missing stash must fail")
+	}
+}
+
+func TestStashMerge(t *testing.T) {
+	newTestRepo(t)
+	commitFiles(t, "one", map[string]string{"a.txt": "1\n2\n3\n4\n5\n", "b.txt": "b\n"})
+	if err := tigfile.WriteFileString("a.txt", "1\n2\n3\n4\nstashed\n"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, tree := openTestRepo(t)
+	if err := Push(ctx, tree, "clean", io.Discard); err != nil {
+		t.Fatalf("Push(): %s", err)
+	}
+	addFiles(t, map[string]string{"b.txt": "b stashed\n"})
+	ctx, tree = openTestRepo(t)
+	if err := Push(ctx, tree, "conflict", io.Discard); err != nil {
+		t.Fatalf("Push(): %s", err)
+	}
+
+	// The stashed content is staged again then replaced before the commit: unstaging it must not
+	// remove the blob the stash still references
+	addFiles(t, map[string]string{"b.txt": "b stashed\n"})
+	commitFiles(t, "two", map[string]string{"a.txt": "head\n2\n3\n4\n5\n", "b.txt": "b head\n"})
+
+	// HEAD moved: a.txt is merged without conflict, the stash is dropped
+	ctx, tree = openTestRepo(t)
+	var out bytes.Buffer
+	if err := Apply(ctx, tree, 1, true, &out); err != nil {
+		t.Fatalf("Apply(stash@{1}): %s", err)
+	}
+	if !strings.HasPrefix(out.String(), "Dropped stash@{1} (") {
+		t.Fatalf("Apply() with drop must write the dropped stash, not %q", out.String())
+	}
+	checkFile(t, "a.txt", "head\n2\n3\n4\nstashed\n")
+	ctx, tree = openTestRepo(t)
+	stashes, err := Load(ctx)
+	if err != nil || len(stashes) != 1 || stashes[0].Message() != "conflict" {
+		t.Fatalf("Apply() without conflict must drop the stash (%v)", err)
+	}
+
+	// b.txt conflicts, the stash is kept
+	out.Reset()
+	if err := Apply(ctx, tree, 0, true, &out); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Apply(stash@{0}) must fail with ErrConflict, not %v", err)
+	}
+	if expected := "Conflicts:\n\tb.txt\n"; out.String() != expected {
+		t.Fatalf("Apply() with conflicts must write %q, not %q", expected, out.String())
+	}
+	data, err := tigfile.ReadFileBytes("b.txt", -1)
+	if err != nil || !strings.Contains(string(data), "b head\n") || !strings.Contains(string(data), "b stashed\n") ||
+		!strings.Contains(string(data), "<<<<<<<") {
+		t.Fatalf("b.txt must contain both sides of the conflict, not %q (%v)", data, err)
+	}
+	ctx, _ = openTestRepo(t)
+	if stashes, err = Load(ctx); err != nil || len(stashes) != 1 {
+		t.Fatalf("Apply() with conflicts must keep the stash (%v)", err)
+	}
+}
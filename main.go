@@ -4,18 +4,53 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"tig/internal/tigconfig"
+	"tig/internal/tigfile"
 	"tig/internal/tighistory"
 	"tig/internal/tigindex"
+	"tig/internal/tigmaint"
+	"tig/internal/tigremote"
+	"tig/internal/tigstash"
 )
 
 func main() {
-	fmt.Println("### Start ###")
+	// On stderr, so machine-readable outputs stay clean
+	fmt.Fprintln(os.Stderr, "### Start ###")
 	ret := run(os.Args)
-	fmt.Println("### Done ###")
+	fmt.Fprintln(os.Stderr, "### Done ###")
 	os.Exit(ret)
 }
 
+// Commands which read or write the files of the working tree, a bare repository has none
+var workTreeCommands = map[string]bool{
+	"status": true, "add": true, "rm": true, "mv": true, "commit": true, "blame": true, "stash": true,
+	"lfs": true,
+}
+
+// Commands which do not write the repository state, they run without the lock
+var readOnlyCommands = map[string]bool{
+	"status": true, "show": true, "cat-file": true, "log": true, "blame": true, "fsck": true,
+}
+
+// unlockOnInterrupt release the lock if tig is interrupted. The writes are atomic, so the state
+// stays consistent, only the lock would be left behind.
+func unlockOnInterrupt(lock *tigfile.LockFile) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		lock.Unlock()
+		fmt.Fprintln(os.Stderr, "Interrupted")
+		os.Exit(130)
+	}()
+}
+
 func run(args []string) int {
 	var (
 		err    error
@@ -35,7 +70,7 @@ func run(args []string) int {
 	}
 
 	if command == "init" {
-		err = tigCtx.Init()
+		err = runInit(args[2:])
 		if err != nil {
 			if errors.Is(err, tigconfig.ErrAlreadyInit) {
 				fmt.Println(err)
@@ -47,6 +82,31 @@ func run(args []string) int {
 		return 0
 	}
 
+	if command == "clone" {
+		if err = runClone(args[2:]); err != nil {
+			fmt.Println("Error in command clone: ", err)
+			return 1
+		}
+		return 0
+	}
+
+	if command == "upload-pack" || command == "receive-pack" || command == "lfs-download" || command == "lfs-upload" {
+		// stdout carries the protocol, errors go to stderr
+		if err = runStdioService(command, args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "Error in command", command+":", err)
+			return 1
+		}
+		return 0
+	}
+
+	if command == "serve" {
+		if err = runServe(args[2:]); err != nil {
+			fmt.Println("Error in command serve: ", err)
+			return 1
+		}
+		return 0
+	}
+
 	err = tigCtx.LoadConfig()
 	if err != nil {
 		if errors.Is(err, tigconfig.ErrNotInit) {
@@ -56,30 +116,126 @@ func run(args []string) int {
 		fmt.Println("Error during tig configuration loading: ", err)
 		return 1
 	}
+	if tigCtx.IsBare() && workTreeCommands[command] {
+		fmt.Println("Error in command", command+":", tigconfig.ErrBare)
+		return 1
+	}
+
+	if !readOnlyCommands[command] {
+		// Held from the first read of the state to its last write
+		lock, err := tigCtx.Lock()
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		defer lock.Unlock()
+		unlockOnInterrupt(lock)
+	}
+
+	// Before the FS and the commits are loaded, they may be corrupted
+	if command == "fsck" {
+		if err = runFsck(&tigCtx); err != nil {
+			fmt.Println("Error in command fsck: ", err)
+			return 1
+		}
+		return 0
+	}
 
 	err = tigCtx.LoadFS()
 	if err != nil {
 		fmt.Println("Error during tig initialization: ", err)
 		return 1
 	}
-	_, err = tighistory.LoadCommits(tigCtx)
+	tree, err := tighistory.LoadCommits(tigCtx)
 	if err != nil {
 		fmt.Printf("Error during tree initialization: %s\n", err)
 		return 1
 	}
 
 	if command == "status" {
-		err = tigindex.GetStatus(&tigCtx)
+		err = runStatus(tigCtx, tree, args[2:])
 	} else if command == "add" {
-		err = tigindex.AddFile(tigCtx, args[2:])
+		var addArgs []string
+		mode, patch := tigindex.ADD_PATHS, false
+		for _, arg := range args[2:] {
+			if arg == "-p" || arg == "--patch" {
+				patch = true
+			} else if arg == "-A" || arg == "--all" {
+				mode = tigindex.ADD_ALL
+			} else if arg == "-u" || arg == "--update" {
+				mode = tigindex.ADD_UPDATE
+			} else {
+				addArgs = append(addArgs, arg)
+			}
+		}
+		if patch {
+			err = tigindex.AddPatch(tigCtx, tree, addArgs, os.Stdin, os.Stdout)
+		} else {
+			err = tigindex.AddFile(tigCtx, tree, addArgs, mode)
+		}
 	} else if command == "rm" {
-		err = tigindex.RemoveFile(tigCtx, args[2:])
+		var (
+			rmArgs        []string
+			cached, force bool
+		)
+		for _, arg := range args[2:] {
+			if arg == "--cached" {
+				cached = true
+			} else if arg == "-f" {
+				force = true
+			} else {
+				rmArgs = append(rmArgs, arg)
+			}
+		}
+		err = tigindex.RemoveFile(tigCtx, tree, rmArgs, cached, force)
+	} else if command == "mv" {
+		moveArgs, force := args[2:], false
+		if len(moveArgs) > 0 && moveArgs[0] == "-f" {
+			moveArgs, force = moveArgs[1:], true
+		}
+		if len(moveArgs) != 2 {
+			fmt.Println("tig mv require a source and a destination argument")
+			return 1
+		}
+		err = tigindex.MoveFile(tigCtx, tree, moveArgs[0], moveArgs[1], force)
 	} else if command == "commit" {
 		if len(args) < 3 {
 			fmt.Println("tig commit require a message argument")
 			return 1
 		}
-		err = tighistory.Commit(tigCtx, args[2])
+		err = tighistory.Commit(tigCtx, tree, args[2])
+	} else if command == "show" {
+		rev := "HEAD"
+		if len(args) > 2 {
+			rev = args[2]
+		}
+		err = tighistory.Show(tigCtx, tree, rev, os.Stdout)
+	} else if command == "cat-file" {
+		if len(args) < 4 {
+			fmt.Println("tig cat-file require a -t, -s or -p flag and an object id")
+			return 1
+		}
+		err = tighistory.CatFile(tigCtx, tree, args[2], args[3], os.Stdout)
+	} else if command == "log" {
+		err = runLog(tigCtx, tree, args[2:])
+	} else if command == "blame" {
+		err = runBlame(tigCtx, tree, args[2:])
+	} else if command == "stash" {
+		err = runStash(tigCtx, tree, args[2:])
+	} else if command == "remote" {
+		err = runRemote(tigCtx, args[2:])
+	} else if command == "fetch" {
+		remote := tigremote.DefaultRemote(tigCtx, tree)
+		if len(args) > 2 {
+			remote = args[2]
+		}
+		err = tigremote.Fetch(tigCtx, tree, remote, os.Stdout)
+	} else if command == "push" {
+		err = runPush(tigCtx, tree, args[2:])
+	} else if command == "lfs" {
+		err = runLFS(tigCtx, tree, args[2:])
+	} else if command == "gc" || command == "prune" {
+		err = runPrune(tigCtx, tree, command == "gc", args[2:])
 	} else if command == "reset" {
 		// DEV ONLY
 		err = tigCtx.Delete()
@@ -95,6 +251,10 @@ func run(args []string) int {
 	} else {
 		err = errors.New("Unknown command")
 	}
+	if err == nil {
+		// Snapshots not referenced by a saved commit or index are still written
+		err = tigCtx.FS.Save()
+	}
 	if err != nil {
 		fmt.Println("Error in command ", command, ": ", err)
 		return 1
@@ -102,3 +262,387 @@ func run(args []string) int {
 
 	return 0
 }
+
+// runInit run the init command: tig init [--bare] [<dir>]
+// A bare repository has no working tree, dir is its tig directory
+func runInit(args []string) error {
+	var paths []string
+	bare := false
+	for _, arg := range args {
+		if arg == "--bare" {
+			bare = true
+		} else {
+			paths = append(paths, arg)
+		}
+	}
+	if len(paths) > 1 {
+		return errors.New("tig init require an optional directory")
+	}
+	if len(paths) == 0 {
+		paths = append(paths, ".")
+	}
+	dir, err := filepath.Abs(paths[0])
+	if err != nil {
+		return err
+	}
+	var tigCtx tigconfig.TigCtx
+	if bare {
+		tigCtx.ProjectPath, tigCtx.TigPath = path.Dir(dir), dir
+	} else {
+		if err := os.MkdirAll(dir, tigfile.DIR_PERM); err != nil {
+			return err
+		}
+		tigCtx.ProjectPath, tigCtx.TigPath = dir, path.Join(dir, tigconfig.TigRootPath)
+	}
+	return tigCtx.Init()
+}
+
+// runFsck run the fsck command: tig fsck
+// It fails if the repository is corrupted, dangling objects are only reported
+func runFsck(tigCtx *tigconfig.TigCtx) error {
+	report, err := tigmaint.Fsck(tigCtx)
+	if err != nil {
+		return err
+	}
+	report.Write(os.Stdout)
+	if len(report.Problems) > 0 {
+		return fmt.Errorf("%w: %d problems found", tigmaint.ErrCorrupt, len(report.Problems))
+	}
+	return nil
+}
+
+// runPrune run the prune and gc commands: tig prune|gc [--dry-run] [--grace <period>]
+// gc also rewrites the FS index file
+func runPrune(tigCtx tigconfig.TigCtx, tree *tighistory.TigCommitTree, compact bool, args []string) error {
+	grace, err := tigmaint.Grace(tigCtx)
+	if err != nil {
+		return err
+	}
+	options := tigmaint.PruneOptions{Grace: grace, Compact: compact}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "-n" || arg == "--dry-run" {
+			options.DryRun = true
+		} else if arg == "--grace" || strings.HasPrefix(arg, "--grace=") {
+			value, ok := strings.CutPrefix(arg, "--grace=")
+			if !ok {
+				if i+1 >= len(args) {
+					return errors.New("--grace require a period, like 12h, 14d or now")
+				}
+				i++
+				value = args[i]
+			}
+			if options.Grace, err = tigmaint.ParseGrace(value); err != nil {
+				return err
+			}
+		} else {
+			return fmt.Errorf("Unknown option %s", arg)
+		}
+	}
+	report, err := tigmaint.Prune(tigCtx, tree, options, os.Stdout)
+	if err != nil {
+		return err
+	}
+	verb := "Removed"
+	if options.DryRun {
+		verb = "Would remove"
+	}
+	fmt.Printf("%s %d commits, %d snapshots, %d blobs and %d LFS objects (%d bytes)\n",
+		verb, report.Commits, report.Snapshots, report.Blobs, report.LFSObjects, report.Bytes)
+	return nil
+}
+
+// runClone run the clone command: tig clone [--depth <n>] <path or url> [<dest>]
+func runClone(args []string) error {
+	var paths []string
+	depth := 0
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--depth" || strings.HasPrefix(arg, "--depth=") {
+			value, ok := strings.CutPrefix(arg, "--depth=")
+			if !ok {
+				if i+1 >= len(args) {
+					return errors.New("--depth require a number of commits")
+				}
+				i++
+				value = args[i]
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return fmt.Errorf("Bad depth %s, must be a positive number", value)
+			}
+			depth = n
+		} else {
+			paths = append(paths, arg)
+		}
+	}
+	if len(paths) < 1 || len(paths) > 2 {
+		return errors.New("tig clone require a repository path or url and an optional destination")
+	}
+	source := paths[0]
+	dest := path.Base(strings.TrimRight(source, "/"))
+	if len(paths) == 2 {
+		dest = paths[1]
+	}
+	fmt.Printf("Cloning into '%s'...\n", dest)
+	return tigremote.Clone(source, dest, depth)
+}
+
+// runStdioService run the upload-pack or receive-pack command: tig <command> <path>, or the
+// lfs-download or lfs-upload command: tig <command> <path> <hash>
+// The session with the client runs on stdin and stdout, see [tigremote.ServeUploadPack]
+func runStdioService(command string, args []string) error {
+	if command == "lfs-download" || command == "lfs-upload" {
+		if len(args) != 2 {
+			return fmt.Errorf("tig %s require a repository path and an object hash", command)
+		}
+		if command == "lfs-download" {
+			return tigremote.ServeLFSDownload(args[0], args[1], os.Stdout)
+		}
+		return tigremote.ServeLFSUpload(args[0], args[1], os.Stdin)
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("tig %s require a repository path", command)
+	}
+	if command == "upload-pack" {
+		return tigremote.ServeUploadPack(args[0], os.Stdin, os.Stdout)
+	}
+	return tigremote.ServeReceivePack(args[0], os.Stdin, os.Stdout)
+}
+
+// runServe run the serve command: tig serve [--addr <host:port>] [<dir>]
+// The repositories under dir are served over HTTP, http://<addr>/<repo> can be cloned, fetched and pushed
+func runServe(args []string) error {
+	addr, dir := "localhost:8080", "."
+	var paths []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--addr" || strings.HasPrefix(arg, "--addr=") {
+			value, ok := strings.CutPrefix(arg, "--addr=")
+			if !ok {
+				if i+1 >= len(args) {
+					return errors.New("--addr require an address host:port")
+				}
+				i++
+				value = args[i]
+			}
+			addr = value
+		} else {
+			paths = append(paths, arg)
+		}
+	}
+	if len(paths) > 1 {
+		return errors.New("tig serve require an optional directory")
+	}
+	if len(paths) == 1 {
+		dir = paths[0]
+	}
+	fmt.Printf("Serving %s on http://%s\n", dir, addr)
+	return tigremote.Serve(dir, addr)
+}
+
+// runStatus run the status command: tig status [-s|--short] [-b|--branch] [--porcelain[=v1]] [--json] [-z]
+func runStatus(tigCtx tigconfig.TigCtx, tree *tighistory.TigCommitTree, args []string) error {
+	format, nulTerminated, showBranch := "human", false, false
+	var flags []string
+	for _, arg := range args {
+		if len(arg) > 2 && arg[0] == '-' && arg[1] != '-' {
+			// Combined short flags: -sb
+			for _, c := range arg[1:] {
+				flags = append(flags, "-"+string(c))
+			}
+		} else {
+			flags = append(flags, arg)
+		}
+	}
+	for _, arg := range flags {
+		if arg == "-s" || arg == "--short" {
+			format = "porcelain"
+		} else if arg == "-b" || arg == "--branch" {
+			showBranch = true
+		} else if arg == "--porcelain" || arg == "--porcelain=v1" {
+			format = "porcelain"
+		} else if strings.HasPrefix(arg, "--porcelain=") {
+			return fmt.Errorf("Unsupported porcelain version %s", arg[len("--porcelain="):])
+		} else if arg == "--json" {
+			format = "json"
+		} else if arg == "-z" {
+			nulTerminated = true
+		} else {
+			return fmt.Errorf("Unknown option %s", arg)
+		}
+	}
+	if nulTerminated && format == "human" {
+		format = "porcelain"
+	}
+	status, err := tigindex.GetStatus(&tigCtx, tree)
+	if err != nil {
+		return err
+	}
+	if format == "json" {
+		return status.WriteJSON(os.Stdout)
+	} else if format == "porcelain" {
+		return status.WritePorcelain(os.Stdout, nulTerminated, showBranch)
+	}
+	status.WriteHuman(os.Stdout)
+	return nil
+}
+
+// runRemote run the remote sub-command: list (default, -v for the urls), add <name> <url>, remove <name>
+func runRemote(tigCtx tigconfig.TigCtx, args []string) error {
+	subCommand := "list"
+	if len(args) > 0 && args[0] != "-v" {
+		subCommand, args = args[0], args[1:]
+	}
+	if subCommand == "add" {
+		if len(args) != 2 {
+			return errors.New("tig remote add require a name and an url")
+		}
+		return tigremote.AddRemote(tigCtx, args[0], args[1])
+	} else if subCommand == "remove" || subCommand == "rm" {
+		if len(args) != 1 {
+			return errors.New("tig remote remove require a name")
+		}
+		return tigremote.RemoveRemote(tigCtx, args[0])
+	} else if subCommand == "list" {
+		verbose := len(args) > 0 && args[0] == "-v"
+		for _, name := range tigCtx.Remotes() {
+			if verbose {
+				url, _ := tigCtx.RemoteURL(name)
+				fmt.Printf("%s\t%s\n", name, url)
+			} else {
+				fmt.Println(name)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("Unknown remote command %s", subCommand)
+}
+
+// runLFS run the lfs sub-command: track [<pattern>...], untrack <pattern>..., ls-files
+func runLFS(tigCtx tigconfig.TigCtx, tree *tighistory.TigCommitTree, args []string) error {
+	if len(args) == 0 {
+		return errors.New("tig lfs require a track, untrack or ls-files command")
+	}
+	subCommand, args := args[0], args[1:]
+	if subCommand == "track" && len(args) == 0 {
+		patterns, err := tigindex.LFSPatterns(tigCtx)
+		for _, pattern := range patterns {
+			fmt.Println(pattern)
+		}
+		return err
+	} else if subCommand == "track" || subCommand == "untrack" {
+		if len(args) == 0 {
+			return errors.New("tig lfs untrack require a pattern")
+		}
+		return tigindex.SetLFSTrack(tigCtx, args, subCommand == "untrack")
+	} else if subCommand == "ls-files" {
+		return tigindex.WriteLFSFiles(tigCtx, tree, os.Stdout)
+	}
+	return fmt.Errorf("Unknown lfs command %s", subCommand)
+}
+
+// runPush run the push command: tig push [-f|--force] [<remote> [<branch>...]]
+func runPush(tigCtx tigconfig.TigCtx, tree *tighistory.TigCommitTree, args []string) error {
+	var pushArgs []string
+	force := false
+	for _, arg := range args {
+		if arg == "-f" || arg == "--force" {
+			force = true
+		} else {
+			pushArgs = append(pushArgs, arg)
+		}
+	}
+	remote := tigremote.DefaultRemote(tigCtx, tree)
+	if len(pushArgs) > 0 {
+		remote, pushArgs = pushArgs[0], pushArgs[1:]
+	}
+	return tigremote.Push(tigCtx, tree, remote, pushArgs, force, os.Stdout)
+}
+
+// runStash run the stash sub-command: push (default), pop, apply, list, drop, show
+func runStash(tigCtx tigconfig.TigCtx, tree *tighistory.TigCommitTree, args []string) error {
+	subCommand := "push"
+	if len(args) > 0 {
+		subCommand, args = args[0], args[1:]
+	}
+	if subCommand == "push" {
+		var msg string
+		if len(args) >= 2 && args[0] == "-m" {
+			msg = args[1]
+		}
+		return tigstash.Push(tigCtx, tree, msg, os.Stdout)
+	} else if subCommand == "list" {
+		return tigstash.List(tigCtx, os.Stdout)
+	}
+
+	var ref string
+	if len(args) > 0 {
+		ref = args[0]
+	}
+	n, err := tigstash.ParseRef(ref)
+	if err != nil {
+		return err
+	}
+	if subCommand == "pop" {
+		return tigstash.Apply(tigCtx, tree, n, true, os.Stdout)
+	} else if subCommand == "apply" {
+		return tigstash.Apply(tigCtx, tree, n, false, os.Stdout)
+	} else if subCommand == "drop" {
+		return tigstash.Drop(tigCtx, n, os.Stdout)
+	} else if subCommand == "show" {
+		return tigstash.Show(tigCtx, n, os.Stdout)
+	}
+	return errors.New("Unknown stash command " + subCommand)
+}
+
+// runBlame parse blame options: [-L start,end] [--porcelain] <file>
+func runBlame(tigCtx tigconfig.TigCtx, tree *tighistory.TigCommitTree, args []string) error {
+	var (
+		err        error
+		start, end int
+		porcelain  bool
+		file       string
+	)
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-L" && i+1 < len(args) {
+			i++
+			start, end, err = tighistory.ParseLineRange(args[i])
+			if err != nil {
+				return err
+			}
+		} else if args[i] == "--porcelain" {
+			porcelain = true
+		} else {
+			file = args[i]
+		}
+	}
+	if len(file) == 0 {
+		return errors.New("tig blame require a file argument")
+	}
+	return tighistory.RunBlame(tigCtx, tree, file, start, end, porcelain, os.Stdout)
+}
+
+// runLog parse log options: [--follow] [-p|--patch] [-- <path>]
+func runLog(tigCtx tigconfig.TigCtx, tree *tighistory.TigCommitTree, args []string) error {
+	var (
+		follow, patch bool
+		file          string
+	)
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--follow" {
+			follow = true
+		} else if args[i] == "-p" || args[i] == "--patch" {
+			patch = true
+		} else if args[i] == "--" && i+1 < len(args) {
+			file = args[i+1]
+			break
+		} else {
+			return errors.New("Unknown log option " + args[i])
+		}
+	}
+	if follow && len(file) == 0 {
+		return errors.New("tig log --follow require a path")
+	}
+	return tighistory.Log(tigCtx, tree, file, follow, patch, os.Stdout)
+}